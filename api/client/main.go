@@ -8,6 +8,7 @@ import (
 	"github.com/MeysamBavi/go-broker/api/client/config"
 	"github.com/MeysamBavi/go-broker/api/client/scheduler"
 	"github.com/MeysamBavi/go-broker/api/client/sender"
+	"github.com/MeysamBavi/go-broker/pkg/client"
 	"log"
 )
 
@@ -16,6 +17,11 @@ var (
 	verbose                = flag.Bool("verbose", false, "log more info")
 	subjects               = flag.Int("subjects", 0, "limits the number of different subjects used")
 	ignoreUnavailableError = flag.Bool("ignore-unavailable", false, "omits unavailable grpc response in errors")
+	useTLS                 = flag.Bool("tls", false, "connect using tls")
+	caFile                 = flag.String("ca", "", "the ca certificate used to verify the server")
+	certFile               = flag.String("cert", "", "the client certificate for mutual tls")
+	keyFile                = flag.String("key", "", "the client key for mutual tls")
+	serverName             = flag.String("server-name", "", "overrides the server name used to verify the server certificate")
 )
 
 func main() {
@@ -39,6 +45,13 @@ func main() {
 		}),
 		Connections: cfg.Connections,
 		Verbose:     *verbose,
+		TLS: client.TLSConfig{
+			Enabled:    *useTLS,
+			CAFile:     *caFile,
+			CertFile:   *certFile,
+			KeyFile:    *keyFile,
+			ServerName: *serverName,
+		},
 	}
 
 	for summary := range collector.Collect(
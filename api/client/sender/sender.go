@@ -4,7 +4,9 @@ import (
 	"context"
 	"github.com/MeysamBavi/go-broker/api/client/collector"
 	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/pkg/client"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"log"
 	"sync"
@@ -21,6 +23,7 @@ type Sender struct {
 	FetchStream   <-chan *pb.FetchRequest
 	Connections   int
 	Verbose       bool
+	TLS           client.TLSConfig
 }
 
 func (s *Sender) Start() <-chan collector.ResponseLog {
@@ -52,8 +55,20 @@ func (s *Sender) Start() <-chan collector.ResponseLog {
 	return responseLogStream
 }
 
+func (s *Sender) transportCredentials() credentials.TransportCredentials {
+	if !s.TLS.Enabled {
+		return insecure.NewCredentials()
+	}
+
+	creds, err := client.NewTLSCredentials(s.TLS)
+	if err != nil {
+		log.Fatal("could not load tls credentials: ", err)
+	}
+	return creds
+}
+
 func (s *Sender) handleConnection(responseLogStream chan<- collector.ResponseLog) {
-	conn, err := grpc.Dial(s.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(s.Host, grpc.WithTransportCredentials(s.transportCredentials()))
 	defer conn.Close()
 	if err != nil {
 		log.Fatal("could not connect to server", err)
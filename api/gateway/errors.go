@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"net/http"
+)
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Reason is set from the google.rpc.ErrorInfo detail, if present
+	Reason          string            `json:"reason,omitempty"`
+	FieldViolations map[string]string `json:"field_violations,omitempty"`
+}
+
+func newErrorResponse(st *status.Status) errorResponse {
+	res := errorResponse{
+		Code:    st.Code().String(),
+		Message: st.Message(),
+	}
+
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			res.Reason = d.GetReason()
+		case *errdetails.BadRequest:
+			res.FieldViolations = make(map[string]string, len(d.GetFieldViolations()))
+			for _, v := range d.GetFieldViolations() {
+				res.FieldViolations[v.GetField()] = v.GetDescription()
+			}
+		}
+	}
+
+	return res
+}
+
+func retryAfterSeconds(st *status.Status) (int, bool) {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return int(math.Ceil(info.GetRetryDelay().AsDuration().Seconds())), true
+		}
+	}
+	return 0, false
+}
+
+// httpStatus maps grpc codes to http status codes, following google.rpc.Code,
+// except for FailedPrecondition, which the broker only returns for expired
+// or purged messages, and is mapped to 410 Gone instead of 400
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.FailedPrecondition:
+		return http.StatusGone
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,30 @@
+package gateway
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"net/http"
+	"testing"
+)
+
+func TestHttpStatus(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want int
+	}{
+		{codes.OK, http.StatusOK},
+		{codes.InvalidArgument, http.StatusBadRequest},
+		{codes.FailedPrecondition, http.StatusGone},
+		{codes.NotFound, http.StatusNotFound},
+		{codes.DeadlineExceeded, http.StatusGatewayTimeout},
+		{codes.ResourceExhausted, http.StatusTooManyRequests},
+		{codes.Unavailable, http.StatusServiceUnavailable},
+		{codes.Internal, http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.code.String(), func(t *testing.T) {
+			assert.Equal(t, test.want, httpStatus(test.code))
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package gateway
+
+import (
+	"encoding/json"
+	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	subjectParam = "subject"
+	idParam      = "id"
+	keyParam     = "key"
+
+	namespaceHeader = "Namespace"
+
+	// query parameters of the subscriptions, building their filter
+	headerQuery     = "header"
+	bodyPrefixQuery = "body_prefix"
+	filterQuery     = "filter"
+)
+
+type publishRequest struct {
+	Body                string            `json:"body"`
+	ExpirationSeconds   int32             `json:"expiration_seconds"`
+	DeliverAtUnixMillis int64             `json:"deliver_at_unix_millis"`
+	Headers             map[string]string `json:"headers"`
+	Key                 string            `json:"key"`
+	SchemaVersion       int32             `json:"schema_version"`
+	Priority            int32             `json:"priority"`
+}
+
+type requestRequest struct {
+	publishRequest
+	TimeoutMillis int32 `json:"timeout_millis"`
+}
+
+type publishResponse struct {
+	Id int32 `json:"id"`
+}
+
+type messageResponse struct {
+	Id            int32             `json:"id,omitempty"`
+	Key           string            `json:"key,omitempty"`
+	Body          string            `json:"body"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	SchemaVersion int32             `json:"schema_version,omitempty"`
+}
+
+type gateway struct {
+	broker        pb.BrokerServer
+	authenticator auth.Authenticator
+	namespaces    *namespace.Registry
+	logger        *slog.Logger
+}
+
+// NewHandler exposes broker over http/json. Every call goes through the same
+// grpc server implementation, so validation, authorization, rate limits and
+// metrics are shared; authenticator may be nil if authentication is disabled.
+// Calls ask for a namespace with the "Namespace" header, the same way grpc
+// calls do with metadata.
+//
+//	POST /v1/subjects/{subject}/messages       publishes {"body", "expiration_seconds", "deliver_at_unix_millis", "headers", "key", "schema_version", "priority"}
+//	GET  /v1/subjects/{subject}/messages/{id}  fetches a message
+//	GET  /v1/subjects/{subject}/keys/{key}     fetches the latest message of a key
+//	GET  /v1/subjects/{subject}/events         subscribes using server-sent events, filtered by "header=name=value", "body_prefix" and "filter" query parameters
+//	POST /v1/subjects/{subject}/requests       publishes like messages, with "timeout_millis", and returns the reply
+func NewHandler(broker pb.BrokerServer, authenticator auth.Authenticator, namespaces *namespace.Registry, tracerProvider trace.TracerProvider, logger *slog.Logger) http.Handler {
+	g := &gateway{
+		broker:        broker,
+		authenticator: authenticator,
+		namespaces:    namespaces,
+		logger:        logger,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/subjects/{subject}/messages", g.publish)
+	mux.HandleFunc("GET /v1/subjects/{subject}/messages/{id}", g.fetch)
+	mux.HandleFunc("GET /v1/subjects/{subject}/keys/{key}", g.fetchByKey)
+	mux.HandleFunc("GET /v1/subjects/{subject}/events", g.subscribe)
+	mux.HandleFunc("POST /v1/subjects/{subject}/requests", g.request)
+
+	return otelhttp.NewHandler(g.withIdentity(mux), "gateway",
+		otelhttp.WithTracerProvider(tracerProvider),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+// withIdentity authenticates the bearer token, if authentication is enabled,
+// resolves the namespace and sets the peer address the same way grpc does
+// for incoming calls
+func (g *gateway) withIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+		}
+
+		if g.authenticator != nil {
+			principal, err := g.authenticator.Authenticate(auth.BearerToken(r.Header.Get("Authorization")))
+			if err != nil {
+				g.writeError(w, r, status.Error(codes.Unauthenticated, err.Error()))
+				return
+			}
+			ctx = auth.WithPrincipal(ctx, principal)
+		}
+
+		ctx, err := namespace.Resolve(ctx, g.namespaces, r.Header.Get(namespaceHeader))
+		if err != nil {
+			g.writeError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (g *gateway) publish(w http.ResponseWriter, r *http.Request) {
+	var request publishRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		g.writeError(w, r, status.Errorf(codes.InvalidArgument, "invalid json body: %v", err))
+		return
+	}
+
+	res, err := g.broker.Publish(r.Context(), request.toProto(r.PathValue(subjectParam)))
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	g.writeJSON(w, r, http.StatusOK, publishResponse{Id: res.GetId()})
+}
+
+func (g *gateway) request(w http.ResponseWriter, r *http.Request) {
+	var request requestRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		g.writeError(w, r, status.Errorf(codes.InvalidArgument, "invalid json body: %v", err))
+		return
+	}
+
+	res, err := g.broker.Request(r.Context(), &pb.RequestRequest{
+		Message:       request.toProto(r.PathValue(subjectParam)),
+		TimeoutMillis: request.TimeoutMillis,
+	})
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	g.writeJSON(w, r, http.StatusOK, toMessageResponse(res))
+}
+
+func (p publishRequest) toProto(subject string) *pb.PublishRequest {
+	return &pb.PublishRequest{
+		Subject:             subject,
+		Body:                []byte(p.Body),
+		ExpirationSeconds:   p.ExpirationSeconds,
+		DeliverAtUnixMillis: p.DeliverAtUnixMillis,
+		Headers:             p.Headers,
+		Key:                 p.Key,
+		SchemaVersion:       p.SchemaVersion,
+		Priority:            p.Priority,
+	}
+}
+
+func (g *gateway) fetch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue(idParam), 10, 32)
+	if err != nil {
+		g.writeError(w, r, status.Errorf(codes.InvalidArgument, "invalid id: %v", err))
+		return
+	}
+
+	res, err := g.broker.Fetch(r.Context(), &pb.FetchRequest{
+		Subject: r.PathValue(subjectParam),
+		Id:      int32(id),
+	})
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	g.writeJSON(w, r, http.StatusOK, toMessageResponse(res))
+}
+
+func (g *gateway) fetchByKey(w http.ResponseWriter, r *http.Request) {
+	res, err := g.broker.Fetch(r.Context(), &pb.FetchRequest{
+		Subject: r.PathValue(subjectParam),
+		Key:     r.PathValue(keyParam),
+	})
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	g.writeJSON(w, r, http.StatusOK, toMessageResponse(res))
+}
+
+func (g *gateway) subscribe(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		g.writeError(w, r, status.Error(codes.Unimplemented, "streaming is not supported"))
+		return
+	}
+
+	stream := &sseStream{
+		ctx:     r.Context(),
+		w:       w,
+		flusher: flusher,
+	}
+	filter, err := toFilter(r.URL.Query())
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+
+	err = g.broker.Subscribe(&pb.SubscribeRequest{
+		Subject: r.PathValue(subjectParam),
+		Filter:  filter,
+	}, stream)
+
+	if err != nil && !stream.started {
+		g.writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		stream.writeEvent(errorEvent, newErrorResponse(status.Convert(err)))
+	}
+}
+
+// toFilter returns nil if query has no filter parameters
+func toFilter(query url.Values) (*pb.Filter, error) {
+	if !query.Has(headerQuery) && !query.Has(bodyPrefixQuery) && !query.Has(filterQuery) {
+		return nil, nil
+	}
+
+	filter := &pb.Filter{
+		BodyPrefix: []byte(query.Get(bodyPrefixQuery)),
+		Expression: query.Get(filterQuery),
+	}
+	for _, header := range query[headerQuery] {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok || name == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "header %q is not in the name=value form", header)
+		}
+		if filter.Headers == nil {
+			filter.Headers = make(map[string]string)
+		}
+		filter.Headers[name] = value
+	}
+
+	return filter, nil
+}
+
+func toMessageResponse(res *pb.MessageResponse) messageResponse {
+	return messageResponse{
+		Id:            res.GetId(),
+		Key:           res.GetKey(),
+		Body:          string(res.GetBody()),
+		Headers:       res.GetHeaders(),
+		SchemaVersion: res.GetSchemaVersion(),
+	}
+}
+
+func (g *gateway) writeJSON(w http.ResponseWriter, r *http.Request, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		g.logger.WarnContext(r.Context(), "could not write http response", logging.Error(err))
+	}
+}
+
+func (g *gateway) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	st := status.Convert(err)
+	if retryAfter, ok := retryAfterSeconds(st); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	g.writeJSON(w, r, httpStatus(st.Code()), newErrorResponse(st))
+}
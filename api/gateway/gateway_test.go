@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/MeysamBavi/go-broker/api/server"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"github.com/MeysamBavi/go-broker/internal/broker"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestGateway(t *testing.T, authenticator auth.Authenticator) *httptest.Server {
+	brokerServer := server.NewServer(broker.NewModule(), metrics.NewEmptyHandler(), store.GetDefaultTimeProvider(),
+		logging.NewNopLogger(), auth.AllowAll(), ratelimit.NoLimit(), server.ValidationConfig{}, nil)
+	ts := httptest.NewServer(NewHandler(brokerServer, authenticator, nil, trace.NewNoopTracerProvider(), logging.NewNopLogger()))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func publish(t *testing.T, ts *httptest.Server, subject, body string, expirationSeconds int) (int, publishResponse) {
+	payload, _ := json.Marshal(publishRequest{Body: body, ExpirationSeconds: int32(expirationSeconds)})
+	res, err := http.Post(ts.URL+"/v1/subjects/"+subject+"/messages", "application/json", strings.NewReader(string(payload)))
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	var published publishResponse
+	_ = json.NewDecoder(res.Body).Decode(&published)
+	return res.StatusCode, published
+}
+
+func TestPublishAndFetch(t *testing.T) {
+	ts := newTestGateway(t, nil)
+
+	code, published := publish(t, ts, "ali", "hello", 60)
+	assert.Equal(t, http.StatusOK, code)
+
+	res, err := http.Get(ts.URL + "/v1/subjects/ali/messages/" + strconv.Itoa(int(published.Id)))
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var msg messageResponse
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&msg))
+	assert.Equal(t, "hello", msg.Body)
+}
+
+func TestFetchErrorsShouldMapToHttpStatus(t *testing.T) {
+	ts := newTestGateway(t, nil)
+	publish(t, ts, "ali", "fire and forget", 0)
+
+	tests := []struct {
+		path   string
+		code   int
+		reason string
+	}{
+		{"/v1/subjects/ali/messages/1", http.StatusGone, "EXPIRED_ID"},
+		{"/v1/subjects/ali/messages/2", http.StatusNotFound, "INVALID_ID"},
+		{"/v1/subjects/ali/messages/abc", http.StatusBadRequest, ""},
+		{"/v1/subjects/ali/keys/user-1", http.StatusNotFound, "KEY_NOT_FOUND"},
+	}
+
+	for _, test := range tests {
+		res, err := http.Get(ts.URL + test.path)
+		assert.Nil(t, err)
+		var e errorResponse
+		_ = json.NewDecoder(res.Body).Decode(&e)
+		res.Body.Close()
+
+		assert.Equal(t, test.code, res.StatusCode, test.path)
+		assert.Equal(t, test.reason, e.Reason, test.path)
+	}
+}
+
+func TestUnauthenticatedShouldBeRejected(t *testing.T) {
+	authenticator, _ := auth.NewAuthenticator(auth.Config{Tokens: []auth.TokenConfig{{Token: "secret", Principal: "p"}}})
+	ts := newTestGateway(t, authenticator)
+
+	code, _ := publish(t, ts, "ali", "hello", 60)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/subjects/ali/messages/1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestSubscribeShouldStreamEvents(t *testing.T) {
+	ts := newTestGateway(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/v1/subjects/ali/events", nil)
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	publish(t, ts, "ali", "hello", 60)
+
+	scanner := bufio.NewScanner(res.Body)
+	var lines []string
+	for len(lines) < 2 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(t, []string{"event: message", `data: {"id":1,"body":"hello"}`}, lines)
+}
+
+func TestRequestWithoutReplyShouldTimeOut(t *testing.T) {
+	ts := newTestGateway(t, nil)
+
+	payload, _ := json.Marshal(requestRequest{publishRequest: publishRequest{Body: "ping"}, TimeoutMillis: 10})
+	res, err := http.Post(ts.URL+"/v1/subjects/ali/requests", "application/json", strings.NewReader(string(payload)))
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	var e errorResponse
+	_ = json.NewDecoder(res.Body).Decode(&e)
+	assert.Equal(t, http.StatusGatewayTimeout, res.StatusCode)
+	assert.Equal(t, "NO_REPLY", e.Reason)
+}
+
+func TestSubscribeShouldFilterEvents(t *testing.T) {
+	ts := newTestGateway(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := url.Values{"filter": {`body.startsWith("b")`}}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/v1/subjects/ali/events?"+query.Encode(), nil)
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+
+	publish(t, ts, "ali", "alpha", 60)
+	publish(t, ts, "ali", "beta", 60)
+
+	scanner := bufio.NewScanner(res.Body)
+	var lines []string
+	for len(lines) < 2 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(t, []string{"event: message", `data: {"id":2,"body":"beta"}`}, lines)
+}
+
+func TestSubscribeWithInvalidFilterShouldFail(t *testing.T) {
+	ts := newTestGateway(t, nil)
+
+	res, err := http.Get(ts.URL + "/v1/subjects/ali/events?filter=" + url.QueryEscape("id =="))
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestNamespaceHeaderShouldSelectNamespace(t *testing.T) {
+	namespaces, err := namespace.NewRegistry([]namespace.Namespace{{Name: "team-a"}})
+	assert.Nil(t, err)
+	brokerServer := server.NewServer(broker.NewModule(), metrics.NewEmptyHandler(), store.GetDefaultTimeProvider(),
+		logging.NewNopLogger(), auth.AllowAll(), ratelimit.NoLimit(), server.ValidationConfig{}, nil)
+	ts := httptest.NewServer(NewHandler(brokerServer, nil, namespaces, trace.NewNoopTracerProvider(), logging.NewNopLogger()))
+	t.Cleanup(ts.Close)
+
+	fetch := func(ns string) int {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/subjects/ali/messages/1", nil)
+		req.Header.Set("Namespace", ns)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	code, _ := publish(t, ts, "ali", "hello", 60)
+	assert.Equal(t, http.StatusOK, code)
+
+	assert.Equal(t, http.StatusOK, fetch(""))
+	assert.Equal(t, http.StatusNotFound, fetch("team-a"))
+	assert.Equal(t, http.StatusNotFound, fetch("team-b"))
+}
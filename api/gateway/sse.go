@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+)
+
+const (
+	messageEvent = "message"
+	errorEvent   = "error"
+)
+
+// sseStream adapts an http response to pb.Broker_SubscribeServer,
+// writing every message as a server-sent event
+type sseStream struct {
+	ctx     context.Context
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func (s *sseStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+
+	header := s.w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+}
+
+func (s *sseStream) writeEvent(event string, v any) error {
+	s.start()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+
+	return nil
+}
+
+func (s *sseStream) Send(res *pb.MessageResponse) error {
+	return s.writeEvent(messageEvent, toMessageResponse(res))
+}
+
+func (s *sseStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+// SendHeader starts the event stream, so that clients know the subscription is established
+func (s *sseStream) SendHeader(metadata.MD) error {
+	s.start()
+	return nil
+}
+
+func (s *sseStream) SetTrailer(metadata.MD) {}
+
+func (s *sseStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *sseStream) SendMsg(m any) error {
+	res, ok := m.(*pb.MessageResponse)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", m)
+	}
+	return s.Send(res)
+}
+
+func (s *sseStream) RecvMsg(any) error {
+	return fmt.Errorf("receiving is not supported on server-sent events")
+}
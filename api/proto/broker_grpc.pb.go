@@ -24,15 +24,36 @@ const _ = grpc.SupportPackageIsVersion7
 type BrokerClient interface {
 	// Publish returns an id if the delivery is successful
 	// If broker is closed, should return Unavailable
+	// If the subject, body or expiration is not valid, should return
+	// InvalidArgument with a google.rpc.BadRequest detail; so does a body not
+	// matching the schema of the subject, or an unregistered schemaVersion
 	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error)
 	// Subscribe returns an stream of messages
 	// If broker is closed, should return Unavailable
+	// If the filter does not compile, should return InvalidArgument
+	// with a google.rpc.BadRequest detail
 	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Broker_SubscribeClient, error)
+	// SubscribeWithCredits is Subscribe with flow control: the first request
+	// opens the subscription, and the server only sends as many messages as
+	// the client granted credits for. Once the credits run out, the messages
+	// are held by the broker for a while and then dead-lettered, the same
+	// way they are for a slow Subscribe client
+	// If a request is out of place or grants no credits, should return InvalidArgument
+	SubscribeWithCredits(ctx context.Context, opts ...grpc.CallOption) (Broker_SubscribeWithCreditsClient, error)
 	// Fetch returns the proper message body, if its present
 	// If broker is closed, should return Unavailable
-	// If the provided id is expired or not present,
-	// should return InvalidArgument
+	// If the provided id was never published, should return NotFound
+	// If the provided id is expired, should return FailedPrecondition
+	// If the provided key was never published, should return NotFound
+	// They carry a google.rpc.ErrorInfo detail with reason
+	// INVALID_ID, EXPIRED_ID or KEY_NOT_FOUND respectively
 	Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*MessageResponse, error)
+	// Request publishes a message with a "reply-to" header naming an inbox
+	// subject, and returns the first message published to the inbox
+	// It fails the way Publish does on an invalid message
+	// If no reply is published in time, should return DeadlineExceeded
+	// with a google.rpc.ErrorInfo detail with reason NO_REPLY
+	Request(ctx context.Context, in *RequestRequest, opts ...grpc.CallOption) (*MessageResponse, error)
 }
 
 type brokerClient struct {
@@ -84,6 +105,37 @@ func (x *brokerSubscribeClient) Recv() (*MessageResponse, error) {
 	return m, nil
 }
 
+func (c *brokerClient) SubscribeWithCredits(ctx context.Context, opts ...grpc.CallOption) (Broker_SubscribeWithCreditsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Broker_ServiceDesc.Streams[1], "/broker.Broker/SubscribeWithCredits", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &brokerSubscribeWithCreditsClient{stream}
+	return x, nil
+}
+
+type Broker_SubscribeWithCreditsClient interface {
+	Send(*SubscribeWithCreditsRequest) error
+	Recv() (*MessageResponse, error)
+	grpc.ClientStream
+}
+
+type brokerSubscribeWithCreditsClient struct {
+	grpc.ClientStream
+}
+
+func (x *brokerSubscribeWithCreditsClient) Send(m *SubscribeWithCreditsRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *brokerSubscribeWithCreditsClient) Recv() (*MessageResponse, error) {
+	m := new(MessageResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *brokerClient) Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*MessageResponse, error) {
 	out := new(MessageResponse)
 	err := c.cc.Invoke(ctx, "/broker.Broker/Fetch", in, out, opts...)
@@ -93,21 +145,51 @@ func (c *brokerClient) Fetch(ctx context.Context, in *FetchRequest, opts ...grpc
 	return out, nil
 }
 
+func (c *brokerClient) Request(ctx context.Context, in *RequestRequest, opts ...grpc.CallOption) (*MessageResponse, error) {
+	out := new(MessageResponse)
+	err := c.cc.Invoke(ctx, "/broker.Broker/Request", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // BrokerServer is the server API for Broker service.
 // All implementations must embed UnimplementedBrokerServer
 // for forward compatibility
 type BrokerServer interface {
 	// Publish returns an id if the delivery is successful
 	// If broker is closed, should return Unavailable
+	// If the subject, body or expiration is not valid, should return
+	// InvalidArgument with a google.rpc.BadRequest detail; so does a body not
+	// matching the schema of the subject, or an unregistered schemaVersion
 	Publish(context.Context, *PublishRequest) (*PublishResponse, error)
 	// Subscribe returns an stream of messages
 	// If broker is closed, should return Unavailable
+	// If the filter does not compile, should return InvalidArgument
+	// with a google.rpc.BadRequest detail
 	Subscribe(*SubscribeRequest, Broker_SubscribeServer) error
+	// SubscribeWithCredits is Subscribe with flow control: the first request
+	// opens the subscription, and the server only sends as many messages as
+	// the client granted credits for. Once the credits run out, the messages
+	// are held by the broker for a while and then dead-lettered, the same
+	// way they are for a slow Subscribe client
+	// If a request is out of place or grants no credits, should return InvalidArgument
+	SubscribeWithCredits(Broker_SubscribeWithCreditsServer) error
 	// Fetch returns the proper message body, if its present
 	// If broker is closed, should return Unavailable
-	// If the provided id is expired or not present,
-	// should return InvalidArgument
+	// If the provided id was never published, should return NotFound
+	// If the provided id is expired, should return FailedPrecondition
+	// If the provided key was never published, should return NotFound
+	// They carry a google.rpc.ErrorInfo detail with reason
+	// INVALID_ID, EXPIRED_ID or KEY_NOT_FOUND respectively
 	Fetch(context.Context, *FetchRequest) (*MessageResponse, error)
+	// Request publishes a message with a "reply-to" header naming an inbox
+	// subject, and returns the first message published to the inbox
+	// It fails the way Publish does on an invalid message
+	// If no reply is published in time, should return DeadlineExceeded
+	// with a google.rpc.ErrorInfo detail with reason NO_REPLY
+	Request(context.Context, *RequestRequest) (*MessageResponse, error)
 	mustEmbedUnimplementedBrokerServer()
 }
 
@@ -121,9 +203,15 @@ func (UnimplementedBrokerServer) Publish(context.Context, *PublishRequest) (*Pub
 func (UnimplementedBrokerServer) Subscribe(*SubscribeRequest, Broker_SubscribeServer) error {
 	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
 }
+func (UnimplementedBrokerServer) SubscribeWithCredits(Broker_SubscribeWithCreditsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeWithCredits not implemented")
+}
 func (UnimplementedBrokerServer) Fetch(context.Context, *FetchRequest) (*MessageResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Fetch not implemented")
 }
+func (UnimplementedBrokerServer) Request(context.Context, *RequestRequest) (*MessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Request not implemented")
+}
 func (UnimplementedBrokerServer) mustEmbedUnimplementedBrokerServer() {}
 
 // UnsafeBrokerServer may be embedded to opt out of forward compatibility for this service.
@@ -176,6 +264,32 @@ func (x *brokerSubscribeServer) Send(m *MessageResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Broker_SubscribeWithCredits_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BrokerServer).SubscribeWithCredits(&brokerSubscribeWithCreditsServer{stream})
+}
+
+type Broker_SubscribeWithCreditsServer interface {
+	Send(*MessageResponse) error
+	Recv() (*SubscribeWithCreditsRequest, error)
+	grpc.ServerStream
+}
+
+type brokerSubscribeWithCreditsServer struct {
+	grpc.ServerStream
+}
+
+func (x *brokerSubscribeWithCreditsServer) Send(m *MessageResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *brokerSubscribeWithCreditsServer) Recv() (*SubscribeWithCreditsRequest, error) {
+	m := new(SubscribeWithCreditsRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _Broker_Fetch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(FetchRequest)
 	if err := dec(in); err != nil {
@@ -194,6 +308,24 @@ func _Broker_Fetch_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Broker_Request_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BrokerServer).Request(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/broker.Broker/Request",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BrokerServer).Request(ctx, req.(*RequestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Broker_ServiceDesc is the grpc.ServiceDesc for Broker service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -209,6 +341,10 @@ var Broker_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Fetch",
 			Handler:    _Broker_Fetch_Handler,
 		},
+		{
+			MethodName: "Request",
+			Handler:    _Broker_Request_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -216,6 +352,12 @@ var Broker_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Broker_Subscribe_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "SubscribeWithCredits",
+			Handler:       _Broker_SubscribeWithCredits_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "api/proto/broker.proto",
 }
@@ -0,0 +1,436 @@
+package server
+
+import (
+	"context"
+	"errors"
+	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
+	"github.com/MeysamBavi/go-broker/internal/schema"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+type adminServer struct {
+	pb.UnimplementedAdminServer
+	messages    store.Message
+	subscribers store.Subscriber
+	policies    *policy.Registry
+	schemas     *schema.Registry
+	namespaces  *namespace.Registry
+	purger      NamespacePurger
+	logger      *slog.Logger
+	authorizer  auth.Authorizer
+	// validator only checks the subjects are not empty and can't reach
+	// other namespaces; the configured limits apply to new messages only
+	validator *validator
+}
+
+// NamespacePurger deletes everything kept for the subjects of a deleted namespace
+type NamespacePurger interface {
+	// PurgeNamespace returns the number of deleted messages
+	PurgeNamespace(ctx context.Context, name string) (int, error)
+}
+
+// NewAdminServer returns an Admin server managing the subjects of the
+// namespace of every call; policies are shared by all namespaces, and only
+// managed by the principals bound to none
+func NewAdminServer(messages store.Message, subscribers store.Subscriber, policies *policy.Registry, schemas *schema.Registry, namespaces *namespace.Registry, purger NamespacePurger, logger *slog.Logger, authorizer auth.Authorizer) pb.AdminServer {
+	return &adminServer{
+		messages:    messages,
+		subscribers: subscribers,
+		policies:    policies,
+		schemas:     schemas,
+		namespaces:  namespaces,
+		purger:      purger,
+		logger:      logger,
+		authorizer:  authorizer,
+		validator:   newValidator(ValidationConfig{}, nil),
+	}
+}
+
+func (a *adminServer) authorize(ctx context.Context, subject string) error {
+	if err := invalidArgument(a.validator.subjectViolations(subject)); err != nil {
+		return err
+	}
+
+	principal, _ := auth.PrincipalFromContext(ctx)
+	if a.authorizer.Authorize(principal, auth.Admin, subject) {
+		return nil
+	}
+
+	return status.Errorf(codes.PermissionDenied, "%s on subject %q is not allowed", auth.Admin, subject)
+}
+
+// authorizePolicy is authorize for the policies, which apply to the
+// matching subjects of every namespace; so principals bound to a
+// namespace, which can't use the others, can't manage them
+func (a *adminServer) authorizePolicy(ctx context.Context, pattern string) error {
+	if pattern == "" {
+		return status.Error(codes.InvalidArgument, "subject is required")
+	}
+
+	principal, _ := auth.PrincipalFromContext(ctx)
+	if bound, ok := a.namespaces.Bound(principal); ok {
+		return status.Errorf(codes.PermissionDenied, "policies are shared by all namespaces, and principals bound to namespace %q can not manage them", bound)
+	}
+	if a.authorizer.AuthorizePattern(principal, auth.Admin, pattern) {
+		return nil
+	}
+
+	return status.Errorf(codes.PermissionDenied, "%s on subjects %q is not allowed", auth.Admin, pattern)
+}
+
+// qualify returns the name subject is kept by in the stores
+func qualify(ctx context.Context, subject string) string {
+	return namespace.Qualify(namespace.FromContext(ctx), subject)
+}
+
+// ListSubjects only returns the subjects the caller has admin rights on
+func (a *adminServer) ListSubjects(ctx context.Context, _ *pb.ListSubjectsRequest) (*pb.ListSubjectsResponse, error) {
+	stored, err := a.messages.Subjects(ctx)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "could not list subjects", logging.Error(err))
+		return nil, errInternal
+	}
+
+	principal, _ := auth.PrincipalFromContext(ctx)
+	ns := namespace.FromContext(ctx)
+	seen := make(map[string]bool)
+	subjects := make([]string, 0, len(stored))
+	for _, qualified := range append(stored, a.subscribers.Subjects(ctx)...) {
+		subjectNamespace, subject := namespace.Split(qualified)
+		if subjectNamespace != ns || seen[subject] || !a.authorizer.Authorize(principal, auth.Admin, subject) {
+			continue
+		}
+		seen[subject] = true
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+
+	return &pb.ListSubjectsResponse{
+		Subjects: subjects,
+	}, nil
+}
+
+func (a *adminServer) DescribeSubject(ctx context.Context, request *pb.DescribeSubjectRequest) (*pb.SubjectDescription, error) {
+	if err := a.authorize(ctx, request.GetSubject()); err != nil {
+		return nil, err
+	}
+
+	stats, err := a.messages.Stats(ctx, qualify(ctx, request.GetSubject()))
+	if err != nil {
+		a.logger.ErrorContext(ctx, "could not describe subject", logging.Subject(request.GetSubject()), logging.Error(err))
+		return nil, errInternal
+	}
+
+	return &pb.SubjectDescription{
+		Subject:         request.GetSubject(),
+		MessageCount:    int64(stats.Messages),
+		LatestId:        int32(stats.LatestId),
+		SubscriberCount: int32(len(a.subscribers.Subscribers(ctx, qualify(ctx, request.GetSubject())))),
+	}, nil
+}
+
+func (a *adminServer) ListSubscribers(ctx context.Context, request *pb.ListSubscribersRequest) (*pb.ListSubscribersResponse, error) {
+	if err := a.authorize(ctx, request.GetSubject()); err != nil {
+		return nil, err
+	}
+
+	subscribers := a.subscribers.Subscribers(ctx, qualify(ctx, request.GetSubject()))
+	response := &pb.ListSubscribersResponse{
+		Subscribers: make([]*pb.SubscriberInfo, len(subscribers)),
+	}
+	for i, subscriber := range subscribers {
+		response.Subscribers[i] = &pb.SubscriberInfo{
+			Id:                     subscriber.Id,
+			SubscribedAtUnixMillis: subscriber.Since.UnixMilli(),
+		}
+	}
+
+	return response, nil
+}
+
+func (a *adminServer) PurgeSubject(ctx context.Context, request *pb.PurgeSubjectRequest) (*pb.PurgeSubjectResponse, error) {
+	if err := a.authorize(ctx, request.GetSubject()); err != nil {
+		return nil, err
+	}
+
+	purged, err := a.messages.Purge(ctx, qualify(ctx, request.GetSubject()))
+	if err != nil {
+		a.logger.ErrorContext(ctx, "could not purge subject", logging.Subject(request.GetSubject()), logging.Error(err))
+		return nil, errInternal
+	}
+
+	a.logger.InfoContext(ctx, "subject purged", logging.Subject(request.GetSubject()), slog.Int("count", purged))
+
+	return &pb.PurgeSubjectResponse{
+		PurgedCount: int64(purged),
+	}, nil
+}
+
+// ListPolicies only returns the policies the caller has admin rights on,
+// which are none for the principals bound to a namespace
+func (a *adminServer) ListPolicies(ctx context.Context, _ *pb.ListPoliciesRequest) (*pb.ListPoliciesResponse, error) {
+	principal, _ := auth.PrincipalFromContext(ctx)
+
+	response := &pb.ListPoliciesResponse{}
+	if _, ok := a.namespaces.Bound(principal); ok {
+		return response, nil
+	}
+	for _, p := range a.policies.List() {
+		if a.authorizer.AuthorizePattern(principal, auth.Admin, p.Subject) {
+			response.Policies = append(response.Policies, toPolicyResponse(p))
+		}
+	}
+
+	return response, nil
+}
+
+func (a *adminServer) SetPolicy(ctx context.Context, request *pb.SetPolicyRequest) (*pb.Policy, error) {
+	if err := a.authorizePolicy(ctx, request.GetPolicy().GetSubject()); err != nil {
+		return nil, err
+	}
+
+	p := fromPolicyRequest(request.GetPolicy())
+	err := a.policies.Set(p)
+	if errors.Is(err, policy.ErrBackendChange) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	a.logger.InfoContext(ctx, "policy set", logging.Subject(p.Subject))
+
+	return toPolicyResponse(p), nil
+}
+
+func (a *adminServer) DeletePolicy(ctx context.Context, request *pb.DeletePolicyRequest) (*pb.DeletePolicyResponse, error) {
+	if err := a.authorizePolicy(ctx, request.GetSubject()); err != nil {
+		return nil, err
+	}
+
+	err := a.policies.Delete(request.GetSubject())
+	if errors.Is(err, policy.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "no policy exists for %q", request.GetSubject())
+	}
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	a.logger.InfoContext(ctx, "policy deleted", logging.Subject(request.GetSubject()))
+
+	return &pb.DeletePolicyResponse{}, nil
+}
+
+func (a *adminServer) RegisterSchema(ctx context.Context, request *pb.RegisterSchemaRequest) (*pb.Schema, error) {
+	if err := a.authorize(ctx, request.GetSubject()); err != nil {
+		return nil, err
+	}
+
+	var format string
+	switch request.GetFormat() {
+	case pb.SchemaFormat_JSON_SCHEMA:
+		format = schema.JSONSchema
+	case pb.SchemaFormat_PROTOBUF:
+		format = schema.Protobuf
+	default:
+		return nil, status.Error(codes.InvalidArgument, "schema format is required")
+	}
+
+	registered, err := a.schemas.Register(ctx, schema.Schema{
+		Subject:     qualify(ctx, request.GetSubject()),
+		Format:      format,
+		Definition:  request.GetDefinition(),
+		MessageName: request.GetMessageName(),
+	})
+	if errors.Is(err, schema.ErrInvalidSchema) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		a.logger.ErrorContext(ctx, "could not register schema", logging.Subject(request.GetSubject()), logging.Error(err))
+		return nil, errInternal
+	}
+
+	a.logger.InfoContext(ctx, "schema registered", logging.Subject(registered.Subject), slog.Int("version", registered.Version))
+
+	return toSchemaResponse(request.GetSubject(), registered), nil
+}
+
+func (a *adminServer) ListSchemas(ctx context.Context, request *pb.ListSchemasRequest) (*pb.ListSchemasResponse, error) {
+	if err := a.authorize(ctx, request.GetSubject()); err != nil {
+		return nil, err
+	}
+
+	response := &pb.ListSchemasResponse{}
+	for _, s := range a.schemas.List(qualify(ctx, request.GetSubject())) {
+		response.Schemas = append(response.Schemas, toSchemaResponse(request.GetSubject(), s))
+	}
+
+	return response, nil
+}
+
+// authorizeNamespace checks the caller may manage the namespace of name
+func (a *adminServer) authorizeNamespace(ctx context.Context, name string) error {
+	principal, _ := auth.PrincipalFromContext(ctx)
+	if a.authorizer.Authorize(principal, auth.Namespaces, name) {
+		return nil
+	}
+
+	return status.Errorf(codes.PermissionDenied, "%s on namespace %q is not allowed", auth.Namespaces, name)
+}
+
+// ListNamespaces only returns the namespaces the caller may manage
+func (a *adminServer) ListNamespaces(ctx context.Context, _ *pb.ListNamespacesRequest) (*pb.ListNamespacesResponse, error) {
+	principal, _ := auth.PrincipalFromContext(ctx)
+
+	response := &pb.ListNamespacesResponse{}
+	for _, n := range a.namespaces.List() {
+		if a.authorizer.Authorize(principal, auth.Namespaces, n.Name) {
+			response.Namespaces = append(response.Namespaces, toNamespaceResponse(n))
+		}
+	}
+
+	return response, nil
+}
+
+func (a *adminServer) CreateNamespace(ctx context.Context, request *pb.CreateNamespaceRequest) (*pb.Namespace, error) {
+	n := fromNamespaceRequest(request.GetNamespace())
+	if err := namespace.ValidateName(n.Name); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := a.authorizeNamespace(ctx, n.Name); err != nil {
+		return nil, err
+	}
+
+	err := a.namespaces.Create(n)
+	if errors.Is(err, namespace.ErrExists) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	a.logger.InfoContext(ctx, "namespace created", slog.String("namespace", n.Name))
+
+	return toNamespaceResponse(n), nil
+}
+
+func (a *adminServer) DeleteNamespace(ctx context.Context, request *pb.DeleteNamespaceRequest) (*pb.DeleteNamespaceResponse, error) {
+	name := request.GetName()
+	if err := namespace.ValidateName(name); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := a.authorizeNamespace(ctx, name); err != nil {
+		return nil, err
+	}
+	if name == namespace.Default {
+		return nil, status.Error(codes.FailedPrecondition, "the default namespace can not be deleted")
+	}
+	if _, ok := a.namespaces.Get(name); !ok {
+		return nil, status.Errorf(codes.NotFound, "no namespace exists for %q", name)
+	}
+
+	for _, qualified := range a.subscribers.Subjects(ctx) {
+		if ns, _ := namespace.Split(qualified); ns == name {
+			return nil, status.Errorf(codes.FailedPrecondition, "namespace %q has subscribers", name)
+		}
+	}
+
+	// no new call is made in the namespace once it's removed from the registry
+	if !a.namespaces.Delete(name) {
+		return nil, status.Errorf(codes.NotFound, "no namespace exists for %q", name)
+	}
+
+	purgedCount, err := a.purger.PurgeNamespace(ctx, name)
+	if err != nil {
+		a.logger.ErrorContext(ctx, "could not purge namespace", slog.String("namespace", name), logging.Error(err))
+		return nil, errInternal
+	}
+
+	a.logger.InfoContext(ctx, "namespace deleted", slog.String("namespace", name), slog.Int("count", purgedCount))
+
+	return &pb.DeleteNamespaceResponse{
+		PurgedCount: int64(purgedCount),
+	}, nil
+}
+
+func toNamespaceResponse(n namespace.Namespace) *pb.Namespace {
+	return &pb.Namespace{
+		Name:       n.Name,
+		Principals: n.Principals,
+		Quotas: &pb.Quotas{
+			PublishRate:      n.Quotas.PublishRate,
+			PublishBurst:     int32(n.Quotas.PublishBurst),
+			BytesPerSecond:   n.Quotas.BytesPerSecond,
+			BytesBurst:       int32(n.Quotas.BytesBurst),
+			MaxSubscriptions: int32(n.Quotas.MaxSubscriptions),
+		},
+	}
+}
+
+func fromNamespaceRequest(n *pb.Namespace) namespace.Namespace {
+	return namespace.Namespace{
+		Name:       n.GetName(),
+		Principals: n.GetPrincipals(),
+		Quotas: ratelimit.Limits{
+			PublishRate:      n.GetQuotas().GetPublishRate(),
+			PublishBurst:     int(n.GetQuotas().GetPublishBurst()),
+			BytesPerSecond:   n.GetQuotas().GetBytesPerSecond(),
+			BytesBurst:       int(n.GetQuotas().GetBytesBurst()),
+			MaxSubscriptions: int(n.GetQuotas().GetMaxSubscriptions()),
+		},
+	}
+}
+
+// toSchemaResponse returns s as registered for subject,
+// which is not qualified by its namespace
+func toSchemaResponse(subject string, s schema.Schema) *pb.Schema {
+	format := pb.SchemaFormat_JSON_SCHEMA
+	if s.Format == schema.Protobuf {
+		format = pb.SchemaFormat_PROTOBUF
+	}
+
+	return &pb.Schema{
+		Subject:             subject,
+		Version:             int32(s.Version),
+		Format:              format,
+		Definition:          s.Definition,
+		MessageName:         s.MessageName,
+		CreatedAtUnixMillis: s.CreatedAt.UnixMilli(),
+	}
+}
+
+func toPolicyResponse(p policy.Policy) *pb.Policy {
+	return &pb.Policy{
+		Subject:           p.Subject,
+		DefaultTtlSeconds: int32(p.DefaultTTL.Seconds()),
+		MaxTtlSeconds:     int32(p.MaxTTL.Seconds()),
+		MaxMessages:       int64(p.MaxMessages),
+		MaxMessageSize:    int64(p.MaxMessageSize),
+		Backend:           p.Backend,
+		Compacted:         p.Compacted,
+		Encrypted:         p.Encrypted,
+	}
+}
+
+func fromPolicyRequest(p *pb.Policy) policy.Policy {
+	return policy.Policy{
+		Subject:        p.GetSubject(),
+		DefaultTTL:     time.Duration(p.GetDefaultTtlSeconds()) * time.Second,
+		MaxTTL:         time.Duration(p.GetMaxTtlSeconds()) * time.Second,
+		MaxMessages:    int(p.GetMaxMessages()),
+		MaxMessageSize: int(p.GetMaxMessageSize()),
+		Backend:        p.GetBackend(),
+		Compacted:      p.GetCompacted(),
+		Encrypted:      p.GetEncrypted(),
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"github.com/MeysamBavi/go-broker/internal/schema"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestAdminServer(t *testing.T) {
+	ctx := context.Background()
+	messages := store.NewInMemoryMessage(store.GetDefaultTimeProvider())
+	subscribers := store.NewInMemorySubscriber(logging.NewNopLogger())
+	policies, err := policy.NewRegistry(nil)
+	assert.Nil(t, err)
+	admin := NewAdminServer(messages, subscribers, policies, nil, nil, nil, logging.NewNopLogger(), auth.AllowAll())
+
+	for i := 0; i < 3; i++ {
+		err = messages.SaveMessage(ctx, "orders", &broker.Message{Body: "body", Expiration: time.Minute})
+		assert.Nil(t, err)
+	}
+	subscribeCtx, cancel := context.WithCancel(ctx)
+	subscribers.AddSubscriber(subscribeCtx, "payments", func(context.Context, *broker.Message) error { return nil })
+
+	subjects, err := admin.ListSubjects(ctx, &pb.ListSubjectsRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders", "payments"}, subjects.GetSubjects())
+
+	description, err := admin.DescribeSubject(ctx, &pb.DescribeSubjectRequest{Subject: "orders"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), description.GetMessageCount())
+	assert.Equal(t, int32(3), description.GetLatestId())
+	assert.Equal(t, int32(0), description.GetSubscriberCount())
+
+	listed, err := admin.ListSubscribers(ctx, &pb.ListSubscribersRequest{Subject: "payments"})
+	assert.Nil(t, err)
+	assert.Len(t, listed.GetSubscribers(), 1)
+
+	purged, err := admin.PurgeSubject(ctx, &pb.PurgeSubjectRequest{Subject: "orders"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), purged.GetPurgedCount())
+
+	_, err = messages.GetMessage(ctx, "orders", 2)
+	assert.ErrorIs(t, err, store.ErrExpired)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return len(subscribers.Subscribers(ctx, "payments")) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestAdminServerPermissionDenied(t *testing.T) {
+	acl, err := auth.NewACL([]auth.Rule{{Principal: "ops", Subjects: []string{"orders.>"}, Actions: []auth.Action{auth.Admin}}})
+	assert.Nil(t, err)
+	policies, err := policy.NewRegistry(nil)
+	assert.Nil(t, err)
+	admin := NewAdminServer(store.NewInMemoryMessage(store.GetDefaultTimeProvider()), store.NewInMemorySubscriber(logging.NewNopLogger()), policies, nil, nil, nil, logging.NewNopLogger(), acl)
+
+	ctx := auth.WithPrincipal(context.Background(), "ops")
+	_, err = admin.PurgeSubject(ctx, &pb.PurgeSubjectRequest{Subject: "orders.eu"})
+	assert.Nil(t, err)
+
+	_, err = admin.PurgeSubject(ctx, &pb.PurgeSubjectRequest{Subject: "payments"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAdminServerPolicies(t *testing.T) {
+	policies, err := policy.NewRegistry(nil, store.BackendMemory)
+	assert.Nil(t, err)
+	admin := NewAdminServer(store.NewInMemoryMessage(store.GetDefaultTimeProvider()), store.NewInMemorySubscriber(logging.NewNopLogger()), policies, nil, nil, nil, logging.NewNopLogger(), auth.AllowAll())
+	ctx := context.Background()
+
+	_, err = admin.SetPolicy(ctx, &pb.SetPolicyRequest{Policy: &pb.Policy{Subject: "orders.>", MaxMessages: 10}})
+	assert.Nil(t, err)
+	p, ok := policies.For("orders.eu")
+	assert.True(t, ok)
+	assert.Equal(t, 10, p.MaxMessages)
+
+	_, err = admin.SetPolicy(ctx, &pb.SetPolicyRequest{Policy: &pb.Policy{Subject: "orders.>", MaxMessages: -1}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = admin.SetPolicy(ctx, &pb.SetPolicyRequest{Policy: &pb.Policy{Subject: "orders.>", Backend: store.BackendMemory}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	listed, err := admin.ListPolicies(ctx, &pb.ListPoliciesRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, listed.GetPolicies(), 1)
+
+	_, err = admin.DeletePolicy(ctx, &pb.DeletePolicyRequest{Subject: "orders.>"})
+	assert.Nil(t, err)
+	_, err = admin.DeletePolicy(ctx, &pb.DeletePolicyRequest{Subject: "orders.>"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAdminServerPolicyPatternsShouldBeCovered(t *testing.T) {
+	acl, err := auth.NewACL([]auth.Rule{{Principal: "ops", Subjects: []string{"orders.*"}, Actions: []auth.Action{auth.Admin}}})
+	assert.Nil(t, err)
+	policies, err := policy.NewRegistry(nil)
+	assert.Nil(t, err)
+	admin := NewAdminServer(store.NewInMemoryMessage(store.GetDefaultTimeProvider()), store.NewInMemorySubscriber(logging.NewNopLogger()), policies, nil, nil, nil, logging.NewNopLogger(), acl)
+	ctx := auth.WithPrincipal(context.Background(), "ops")
+
+	_, err = admin.SetPolicy(ctx, &pb.SetPolicyRequest{Policy: &pb.Policy{Subject: "orders.*", MaxMessages: 10}})
+	assert.Nil(t, err)
+
+	_, err = admin.SetPolicy(ctx, &pb.SetPolicyRequest{Policy: &pb.Policy{Subject: "orders.>", MaxMessages: 10}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = admin.DeletePolicy(ctx, &pb.DeletePolicyRequest{Subject: ">"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAdminServerPoliciesShouldNotBeManagedFromNamespaces(t *testing.T) {
+	acl, err := auth.NewACL([]auth.Rule{{Principal: "*", Subjects: []string{"orders.>"}, Actions: []auth.Action{auth.Admin}}})
+	assert.Nil(t, err)
+	policies, err := policy.NewRegistry([]policy.Policy{{Subject: "orders.>", MaxMessages: 10}})
+	assert.Nil(t, err)
+	namespaces, err := namespace.NewRegistry([]namespace.Namespace{{Name: "team-a", Principals: []string{"alice"}}})
+	assert.Nil(t, err)
+	admin := NewAdminServer(store.NewInMemoryMessage(store.GetDefaultTimeProvider()), store.NewInMemorySubscriber(logging.NewNopLogger()), policies, nil, namespaces, nil, logging.NewNopLogger(), acl)
+	alice := auth.WithPrincipal(context.Background(), "alice")
+
+	_, err = admin.SetPolicy(alice, &pb.SetPolicyRequest{Policy: &pb.Policy{Subject: "orders.>", MaxMessages: 1}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = admin.DeletePolicy(alice, &pb.DeletePolicyRequest{Subject: "orders.>"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	listed, err := admin.ListPolicies(alice, &pb.ListPoliciesRequest{})
+	assert.Nil(t, err)
+	assert.Empty(t, listed.GetPolicies())
+
+	ops := auth.WithPrincipal(context.Background(), "ops")
+	listed, err = admin.ListPolicies(ops, &pb.ListPoliciesRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, listed.GetPolicies(), 1)
+	_, err = admin.SetPolicy(ops, &pb.SetPolicyRequest{Policy: &pb.Policy{Subject: "orders.>", MaxMessages: 1}})
+	assert.Nil(t, err)
+}
+
+func TestAdminServerSchemas(t *testing.T) {
+	ctx := context.Background()
+	schemas, err := schema.NewRegistry(ctx, store.NewInMemorySchemaStore())
+	assert.Nil(t, err)
+	admin := NewAdminServer(store.NewInMemoryMessage(store.GetDefaultTimeProvider()), store.NewInMemorySubscriber(logging.NewNopLogger()), nil, schemas, nil, nil, logging.NewNopLogger(), auth.AllowAll())
+
+	_, err = admin.RegisterSchema(ctx, &pb.RegisterSchemaRequest{Subject: "users", Definition: []byte(`{"type": "object"}`)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = admin.RegisterSchema(ctx, &pb.RegisterSchemaRequest{Subject: "users", Format: pb.SchemaFormat_JSON_SCHEMA, Definition: []byte(`{"type": 1}`)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	registered, err := admin.RegisterSchema(ctx, &pb.RegisterSchemaRequest{Subject: "users", Format: pb.SchemaFormat_JSON_SCHEMA, Definition: []byte(`{"type": "object"}`)})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), registered.GetVersion())
+
+	listed, err := admin.ListSchemas(ctx, &pb.ListSchemasRequest{Subject: "users"})
+	assert.Nil(t, err)
+	assert.Len(t, listed.GetSchemas(), 1)
+}
+
+type recordingPurger struct {
+	purged []string
+}
+
+func (r *recordingPurger) PurgeNamespace(_ context.Context, name string) (int, error) {
+	r.purged = append(r.purged, name)
+	return 1, nil
+}
+
+func TestAdminServerNamespaces(t *testing.T) {
+	ctx := context.Background()
+	messages := store.NewInMemoryMessage(store.GetDefaultTimeProvider())
+	subscribers := store.NewInMemorySubscriber(logging.NewNopLogger())
+	namespaces, err := namespace.NewRegistry(nil)
+	assert.Nil(t, err)
+	purger := &recordingPurger{}
+	admin := NewAdminServer(messages, subscribers, nil, nil, namespaces, purger, logging.NewNopLogger(), auth.AllowAll())
+
+	created, err := admin.CreateNamespace(ctx, &pb.CreateNamespaceRequest{Namespace: &pb.Namespace{
+		Name:       "team-a",
+		Principals: []string{"alice"},
+		Quotas:     &pb.Quotas{PublishRate: 10},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "team-a", created.GetName())
+	assert.Equal(t, 10.0, namespaces.Quotas("team-a").PublishRate)
+
+	_, err = admin.CreateNamespace(ctx, &pb.CreateNamespaceRequest{Namespace: &pb.Namespace{Name: "team-a"}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = admin.CreateNamespace(ctx, &pb.CreateNamespaceRequest{Namespace: &pb.Namespace{Name: "team/b"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	listed, err := admin.ListNamespaces(ctx, &pb.ListNamespacesRequest{})
+	assert.Nil(t, err)
+	assert.Len(t, listed.GetNamespaces(), 2)
+
+	teamA := namespace.WithNamespace(ctx, "team-a")
+	for _, subject := range []string{"orders", "team-a/orders"} {
+		assert.Nil(t, messages.SaveMessage(ctx, subject, &broker.Message{Body: "body", Expiration: time.Minute}))
+	}
+	subjects, err := admin.ListSubjects(teamA, &pb.ListSubjectsRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders"}, subjects.GetSubjects())
+
+	subscribeCtx, cancel := context.WithCancel(ctx)
+	subscribers.AddSubscriber(subscribeCtx, "team-a/payments", func(context.Context, *broker.Message) error { return nil })
+	_, err = admin.DeleteNamespace(ctx, &pb.DeleteNamespaceRequest{Name: "team-a"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	cancel()
+	assert.Eventually(t, func() bool {
+		return len(subscribers.Subjects(ctx)) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	deleted, err := admin.DeleteNamespace(ctx, &pb.DeleteNamespaceRequest{Name: "team-a"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deleted.GetPurgedCount())
+	assert.Equal(t, []string{"team-a"}, purger.purged)
+
+	_, err = admin.DeleteNamespace(ctx, &pb.DeleteNamespaceRequest{Name: "team-a"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = admin.DeleteNamespace(ctx, &pb.DeleteNamespaceRequest{Name: namespace.Default})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestAdminServerShouldNotReachOtherNamespaces(t *testing.T) {
+	ctx := context.Background()
+	messages := store.NewInMemoryMessage(store.GetDefaultTimeProvider())
+	namespaces, err := namespace.NewRegistry([]namespace.Namespace{{Name: "team-b", Principals: []string{"bob"}}})
+	assert.Nil(t, err)
+	admin := NewAdminServer(messages, store.NewInMemorySubscriber(logging.NewNopLogger()), nil, nil, namespaces, nil, logging.NewNopLogger(), auth.AllowAll())
+	assert.Nil(t, messages.SaveMessage(ctx, "team-b/orders", &broker.Message{Body: "body", Expiration: time.Minute}))
+
+	alice, err := namespace.Resolve(auth.WithPrincipal(ctx, "alice"), namespaces, "")
+	assert.Nil(t, err)
+	_, err = admin.DescribeSubject(alice, &pb.DescribeSubjectRequest{Subject: "team-b/orders"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = admin.PurgeSubject(alice, &pb.PurgeSubjectRequest{Subject: "team-b/orders"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = messages.GetMessage(ctx, "team-b/orders", 1)
+	assert.Nil(t, err)
+}
@@ -1,5 +1,50 @@
 package server
 
+import "time"
+
 type Config struct {
-	Host string `config:"host"`
+	Host       string           `config:"host"`
+	TLS        TLSConfig        `config:"tls"`
+	Validation ValidationConfig `config:"validation"`
+	Gateway    GatewayConfig    `config:"gateway"`
+	// HealthCheckInterval is how often the store connectivity is checked
+	HealthCheckInterval time.Duration `config:"health_check_interval"`
+	// ShutdownTimeout is how long to wait for the ongoing calls
+	// to finish before forcing a stop
+	ShutdownTimeout time.Duration `config:"shutdown_timeout"`
+}
+
+// GatewayConfig configures the http/json api served alongside grpc
+type GatewayConfig struct {
+	Enabled bool   `config:"enabled"`
+	Host    string `config:"host"`
+}
+
+type TLSConfig struct {
+	Enabled  bool   `config:"enabled"`
+	CertFile string `config:"cert_file"`
+	KeyFile  string `config:"key_file"`
+	// ClientCAFile is a bundle of CA certificates; if provided,
+	// clients must present a certificate signed by one of them
+	ClientCAFile string `config:"client_ca_file"`
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval time.Duration `config:"reload_interval"`
+}
+
+// ValidationConfig bounds the requests; zero values disable the limits
+type ValidationConfig struct {
+	// MaxBodySize is the maximum size of a published body in bytes
+	MaxBodySize      int `config:"max_body_size"`
+	MaxSubjectLength int `config:"max_subject_length"`
+	// SubjectCharset is the set of characters allowed in subjects
+	SubjectCharset string `config:"subject_charset"`
+	// MinTTL and MaxTTL bound the expiration of published messages,
+	// DefaultTTL is used when the expiration is not provided
+	MinTTL     time.Duration `config:"min_ttl"`
+	MaxTTL     time.Duration `config:"max_ttl"`
+	DefaultTTL time.Duration `config:"default_ttl"`
+	// MaxDelay bounds how far in the future a message can be scheduled
+	MaxDelay time.Duration `config:"max_delay"`
+	// MaxRequestTimeout bounds how long a Request waits for its reply
+	MaxRequestTimeout time.Duration `config:"max_request_timeout"`
 }
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"net"
+	"strconv"
+)
+
+const (
+	retryAfterHeader = "retry-after"
+)
+
+// clientIdentity is the authenticated principal if there is one,
+// otherwise the address of the remote host
+func clientIdentity(ctx context.Context) string {
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		return principal
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return p.Addr.String()
+		}
+		return host
+	}
+
+	return ""
+}
+
+// resourceExhausted converts an *ratelimit.ExceededError to a ResourceExhausted
+// status with a retry-after header (in seconds) and a RetryInfo detail
+func resourceExhausted(ctx context.Context, exceeded *ratelimit.ExceededError) error {
+	st := status.New(codes.ResourceExhausted, exceeded.Error())
+	if exceeded.RetryAfter <= 0 {
+		return st.Err()
+	}
+
+	seconds := int(math.Ceil(exceeded.RetryAfter.Seconds()))
+	_ = grpc.SetHeader(ctx, metadata.Pairs(retryAfterHeader, strconv.Itoa(seconds)))
+
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(exceeded.RetryAfter),
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
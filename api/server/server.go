@@ -2,12 +2,24 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
 	"github.com/MeysamBavi/go-broker/internal/store"
 	"github.com/MeysamBavi/go-broker/pkg/broker"
 	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	"strconv"
 	"time"
 )
 
@@ -21,30 +33,50 @@ type server struct {
 	broker         broker.Broker
 	metricsHandler metrics.Handler
 	timeProvider   store.TimeProvider
+	logger         *slog.Logger
+	authorizer     auth.Authorizer
+	limiter        ratelimit.Limiter
+	validator      *validator
 }
 
-func NewServer(bk broker.Broker, metricsHandler metrics.Handler, timeProvider store.TimeProvider) pb.BrokerServer {
+func NewServer(bk broker.Broker, metricsHandler metrics.Handler, timeProvider store.TimeProvider, logger *slog.Logger, authorizer auth.Authorizer, limiter ratelimit.Limiter, validation ValidationConfig, policies *policy.Registry) pb.BrokerServer {
 	return &server{
 		broker:         bk,
 		metricsHandler: metricsHandler,
 		timeProvider:   timeProvider,
+		logger:         logger,
+		authorizer:     authorizer,
+		limiter:        limiter,
+		validator:      newValidator(validation, policies),
 	}
 }
 
+// authorize checks the principal set by the authentication interceptors
+// against the acl, returning a PermissionDenied status if not allowed
+func (s *server) authorize(ctx context.Context, action auth.Action, subject string) error {
+	principal, _ := auth.PrincipalFromContext(ctx)
+	if s.authorizer.Authorize(principal, action, subject) {
+		return nil
+	}
+
+	return status.Errorf(codes.PermissionDenied, "%s on subject %q is not allowed", action, subject)
+}
+
 func (s *server) Publish(ctx context.Context, request *pb.PublishRequest) (*pb.PublishResponse, error) {
 	success := false
 	callTime := s.timeProvider.GetCurrentTime()
+	ns := namespace.FromContext(ctx)
 	defer func() {
 		latency := s.timeProvider.GetCurrentTime().Sub(callTime)
-		s.metricsHandler.ReportPublishLatency(latency)
-		s.metricsHandler.IncPublishCallCount(success)
+		s.metricsHandler.ReportPublishLatency(ns, latency)
+		s.metricsHandler.IncPublishCallCount(ns, success)
 	}()
 
-	body := string(request.GetBody())
-	id, err := s.broker.Publish(ctx, request.GetSubject(), broker.Message{
-		Body:       body,
-		Expiration: time.Duration(request.GetExpirationSeconds()) * time.Second,
-	})
+	msg, err := s.publishable(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.broker.Publish(ctx, request.GetSubject(), msg)
 
 	if err == nil {
 		success = true
@@ -53,90 +85,414 @@ func (s *server) Publish(ctx context.Context, request *pb.PublishRequest) (*pb.P
 		}, nil
 	}
 
+	return nil, s.publishError(ctx, request, err)
+}
+
+// publishable validates, authorizes and rate limits request,
+// and returns the message it publishes
+func (s *server) publishable(ctx context.Context, request *pb.PublishRequest) (broker.Message, error) {
+	expiration, err := s.validator.validatePublish(request)
+	if err != nil {
+		return broker.Message{}, err
+	}
+
+	if err := s.authorize(ctx, auth.Publish, request.GetSubject()); err != nil {
+		return broker.Message{}, err
+	}
+
+	ns := namespace.FromContext(ctx)
+	if err := s.limiter.AllowPublish(ns, clientIdentity(ctx), namespace.Qualify(ns, request.GetSubject()), len(request.GetBody())); err != nil {
+		var exceeded *ratelimit.ExceededError
+		if !errors.As(err, &exceeded) {
+			s.logger.ErrorContext(ctx, "could not apply rate limits",
+				logging.Subject(request.GetSubject()), logging.Error(err))
+			return broker.Message{}, errInternal
+		}
+		s.metricsHandler.IncPublishRateLimitedCount(ns, exceeded.Scope, exceeded.Limit)
+		return broker.Message{}, resourceExhausted(ctx, exceeded)
+	}
+
+	body := string(request.GetBody())
+	msg := broker.Message{
+		Body:          body,
+		Expiration:    expiration,
+		Headers:       request.GetHeaders(),
+		Key:           request.GetKey(),
+		SchemaVersion: int(request.GetSchemaVersion()),
+		Priority:      int(request.GetPriority()),
+	}
+	if deliverAt := request.GetDeliverAtUnixMillis(); deliverAt > 0 {
+		msg.DeliverAt = time.UnixMilli(deliverAt)
+	}
+
+	return msg, nil
+}
+
+// publishError maps the error of publishing request to a status
+func (s *server) publishError(ctx context.Context, request *pb.PublishRequest, err error) error {
 	if err == broker.ErrUnavailable {
-		return nil, errUnavailable
+		return errUnavailable
 	}
 
-	//TODO: log error
-	return nil, errInternal
+	if err == broker.ErrUnknownSchemaVersion {
+		return invalidArgument([]*errdetails.BadRequest_FieldViolation{
+			violation(schemaField, "version %d is not registered for the subject", request.GetSchemaVersion()),
+		})
+	}
+
+	if errors.Is(err, broker.ErrSchemaViolation) {
+		return invalidArgument([]*errdetails.BadRequest_FieldViolation{
+			violation(bodyField, "%v", err),
+		})
+	}
+
+	s.logger.ErrorContext(ctx, "could not publish message",
+		logging.Subject(request.GetSubject()), logging.Error(err))
+	return errInternal
+}
+
+func (s *server) Request(ctx context.Context, request *pb.RequestRequest) (*pb.MessageResponse, error) {
+	success := false
+	callTime := s.timeProvider.GetCurrentTime()
+	ns := namespace.FromContext(ctx)
+	defer func() {
+		latency := s.timeProvider.GetCurrentTime().Sub(callTime)
+		s.metricsHandler.ReportRequestLatency(ns, latency)
+		s.metricsHandler.IncRequestCallCount(ns, success)
+	}()
+
+	timeout, err := s.validator.validateRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := s.publishable(ctx, request.GetMessage())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	reply, err := s.broker.Request(ctx, request.GetMessage().GetSubject(), msg)
+	if err == nil {
+		success = true
+		return toMessageResponse(reply), nil
+	}
+
+	if err == broker.ErrNoReply {
+		st := status.New(codes.DeadlineExceeded, fmt.Sprintf("no reply is received in %s", timeout))
+		detailed, detailErr := st.WithDetails(&errdetails.ErrorInfo{
+			Reason: broker.ReasonNoReply,
+			Domain: broker.ErrorDomain,
+			Metadata: map[string]string{
+				"subject": request.GetMessage().GetSubject(),
+			},
+		})
+		if detailErr != nil {
+			return nil, st.Err()
+		}
+		return nil, detailed.Err()
+	}
+
+	if err == context.Canceled {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	return nil, s.publishError(ctx, request.GetMessage(), err)
 }
 
 func (s *server) Subscribe(request *pb.SubscribeRequest, subscribeServer pb.Broker_SubscribeServer) error {
 	// call count is incremented when the first response is generated;
 	// if first response is a published message, or the context expires before first message, the call is successful
 	// otherwise it is a failure
+	ctx := subscribeServer.Context()
 	success := false
 	alreadyReported := false
 	report := func() {
 		if !alreadyReported {
 			alreadyReported = true
-			s.metricsHandler.IncSubscribeCallCount(success)
+			s.metricsHandler.IncSubscribeCallCount(namespace.FromContext(ctx), success)
 		}
 	}
 	defer report()
 
-	sub, err := s.broker.Subscribe(subscribeServer.Context(), request.GetSubject())
-
+	sub, release, err := s.subscribe(ctx, request, subscribeServer)
 	if err != nil {
-		if err == broker.ErrUnavailable {
-			return errUnavailable
-		}
-		//TODO: log error
-		return errInternal
+		return err
 	}
-
-	s.metricsHandler.IncActiveSubscribers()
-	defer s.metricsHandler.DecActiveSubscribers()
+	defer release()
 
 	for {
 		select {
-		case <-subscribeServer.Context().Done():
+		case <-ctx.Done():
 			success = true
 			return nil
 		case message, ok := <-sub:
-			if !ok {
-				//TODO: log error
-				return status.Errorf(codes.Internal, "channel closed unexpectedly")
+			if err := s.send(ctx, request, subscribeServer, message, ok); err != nil {
+				return err
 			}
-			err := subscribeServer.Send(&pb.MessageResponse{
-				Body: []byte(message.Body),
-			})
+			success = true
+			report()
+		}
+	}
+}
+
+// SubscribeWithCredits reports its calls the same way Subscribe does
+func (s *server) SubscribeWithCredits(stream pb.Broker_SubscribeWithCreditsServer) error {
+	ctx := stream.Context()
+	success := false
+	alreadyReported := false
+	report := func() {
+		if !alreadyReported {
+			alreadyReported = true
+			s.metricsHandler.IncSubscribeCallCount(namespace.FromContext(ctx), success)
+		}
+	}
+	defer report()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	request := first.GetSubscribe()
+	if request == nil {
+		return status.Error(codes.InvalidArgument, "the first request must open the subscription")
+	}
+
+	sub, release, err := s.subscribe(ctx, request, stream)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	requests := make(chan *pb.SubscribeWithCreditsRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
 			if err != nil {
-				//TODO: log error
-				return status.Errorf(codes.Internal, "could not send message: %v", err)
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var credits int64
+	for {
+		// messages are only taken from the subscription while there are credits
+		var messages <-chan broker.Message
+		if credits > 0 {
+			messages = sub
+		}
+
+		select {
+		case <-ctx.Done():
+			success = true
+			return nil
+		case err := <-recvErr:
+			if err != io.EOF {
+				return err
+			}
+			// the client granted its last credits
+			recvErr = nil
+		case req := <-requests:
+			if err := s.validator.validateCredits(req); err != nil {
+				return err
+			}
+			credits += int64(req.GetCredits())
+		case message, ok := <-messages:
+			if err := s.send(ctx, request, stream, message, ok); err != nil {
+				return err
 			}
+			credits--
 			success = true
 			report()
 		}
 	}
 }
 
+// subscribe validates, authorizes and rate limits request, subscribes to
+// its subject and sends the headers of stream, so that the client knows
+// the subscription is established; release must be called once it's over
+func (s *server) subscribe(ctx context.Context, request *pb.SubscribeRequest, stream grpc.ServerStream) (<-chan broker.Message, func(), error) {
+	if err := s.validator.validateSubscribe(request); err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.authorize(ctx, auth.Subscribe, request.GetSubject()); err != nil {
+		return nil, nil, err
+	}
+
+	ns := namespace.FromContext(ctx)
+	releaseSubscription, err := s.limiter.AcquireSubscription(ns, clientIdentity(ctx), namespace.Qualify(ns, request.GetSubject()))
+	if err != nil {
+		var exceeded *ratelimit.ExceededError
+		if !errors.As(err, &exceeded) {
+			s.logger.ErrorContext(ctx, "could not apply rate limits",
+				logging.Subject(request.GetSubject()), logging.Error(err))
+			return nil, nil, errInternal
+		}
+		s.metricsHandler.IncSubscribeRateLimitedCount(ns, exceeded.Scope, exceeded.Limit)
+		return nil, nil, resourceExhausted(ctx, exceeded)
+	}
+
+	sub, err := s.broker.SubscribeWithFilter(ctx, request.GetSubject(), toBrokerFilter(request.GetFilter()))
+
+	if err != nil {
+		releaseSubscription()
+		if err == broker.ErrUnavailable {
+			return nil, nil, errUnavailable
+		}
+		if errors.Is(err, broker.ErrInvalidFilter) {
+			return nil, nil, invalidArgument([]*errdetails.BadRequest_FieldViolation{
+				violation(filterField, "%v", err),
+			})
+		}
+		s.logger.ErrorContext(ctx, "could not subscribe",
+			logging.Subject(request.GetSubject()), logging.Error(err))
+		return nil, nil, errInternal
+	}
+
+	if err := stream.SendHeader(nil); err != nil {
+		releaseSubscription()
+		return nil, nil, err
+	}
+
+	s.metricsHandler.IncActiveSubscribers(ns)
+	release := func() {
+		s.metricsHandler.DecActiveSubscribers(ns)
+		releaseSubscription()
+	}
+
+	return sub, release, nil
+}
+
+// send sends a message taken from the subscription of request to stream;
+// ok is false if the subscription channel was closed instead
+func (s *server) send(ctx context.Context, request *pb.SubscribeRequest, stream pb.Broker_SubscribeServer, message broker.Message, ok bool) error {
+	if !ok {
+		s.logger.ErrorContext(ctx, "subscription channel closed unexpectedly",
+			logging.Subject(request.GetSubject()))
+		return status.Errorf(codes.Internal, "channel closed unexpectedly")
+	}
+
+	if err := stream.Send(toMessageResponse(message)); err != nil {
+		s.logger.WarnContext(ctx, "could not send message to subscriber",
+			logging.Subject(request.GetSubject()), logging.MessageId(message.Id), logging.Error(err))
+		return status.Errorf(codes.Internal, "could not send message: %v", err)
+	}
+
+	return nil
+}
+
 func (s *server) Fetch(ctx context.Context, request *pb.FetchRequest) (*pb.MessageResponse, error) {
 	success := false
 	callTime := s.timeProvider.GetCurrentTime()
+	ns := namespace.FromContext(ctx)
 	defer func() {
 		latency := s.timeProvider.GetCurrentTime().Sub(callTime)
-		s.metricsHandler.ReportFetchLatency(latency)
-		s.metricsHandler.IncFetchCallCount(success)
+		s.metricsHandler.ReportFetchLatency(ns, latency)
+		s.metricsHandler.IncFetchCallCount(ns, success)
 	}()
 
+	if err := s.validator.validateFetch(request); err != nil {
+		return nil, err
+	}
+
+	if err := s.authorize(ctx, auth.Fetch, request.GetSubject()); err != nil {
+		return nil, err
+	}
+
 	id := int(request.GetId())
-	message, err := s.broker.Fetch(ctx, request.GetSubject(), id)
+	key := request.GetKey()
+	var message broker.Message
+	var err error
+	if key != "" {
+		message, err = s.broker.FetchByKey(ctx, request.GetSubject(), key)
+	} else {
+		message, err = s.broker.Fetch(ctx, request.GetSubject(), id)
+	}
 	if err == nil {
 		success = true
-		return &pb.MessageResponse{
-			Body: []byte(message.Body),
-		}, nil
+		return toMessageResponse(message), nil
 	}
 
 	if err == broker.ErrUnavailable {
 		return nil, errUnavailable
 	}
 
-	if err == broker.ErrExpiredID || err == broker.ErrInvalidID {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid argument for id=%d; message expired or not found", id)
+	if err == broker.ErrInvalidID {
+		return nil, withErrorInfo(
+			status.New(codes.NotFound, fmt.Sprintf("message with id=%d was never published", id)),
+			broker.ReasonInvalidID, request,
+		)
+	}
+
+	if err == broker.ErrKeyNotFound {
+		return nil, withErrorInfo(
+			status.New(codes.NotFound, fmt.Sprintf("no message with key=%q was published", key)),
+			broker.ReasonKeyNotFound, request,
+		)
 	}
 
-	//TODO: log error
+	if err == broker.ErrExpiredID && key != "" {
+		return nil, withErrorInfo(
+			status.New(codes.FailedPrecondition, fmt.Sprintf("latest message with key=%q is expired", key)),
+			broker.ReasonExpiredID, request,
+		)
+	}
+
+	if err == broker.ErrExpiredID {
+		return nil, withErrorInfo(
+			status.New(codes.FailedPrecondition, fmt.Sprintf("message with id=%d is expired", id)),
+			broker.ReasonExpiredID, request,
+		)
+	}
+
+	s.logger.ErrorContext(ctx, "could not fetch message",
+		logging.Subject(request.GetSubject()), logging.MessageId(id), logging.Error(err))
 	return nil, errInternal
 }
+
+func toBrokerFilter(filter *pb.Filter) broker.Filter {
+	return broker.Filter{
+		Headers:    filter.GetHeaders(),
+		BodyPrefix: string(filter.GetBodyPrefix()),
+		Expression: filter.GetExpression(),
+	}
+}
+
+func toMessageResponse(message broker.Message) *pb.MessageResponse {
+	return &pb.MessageResponse{
+		Body:          []byte(message.Body),
+		Headers:       message.Headers,
+		Id:            int32(message.Id),
+		Key:           message.Key,
+		SchemaVersion: int32(message.SchemaVersion),
+	}
+}
+
+func withErrorInfo(st *status.Status, reason string, request *pb.FetchRequest) error {
+	metadata := map[string]string{
+		"subject": request.GetSubject(),
+		"id":      strconv.Itoa(int(request.GetId())),
+	}
+	if key := request.GetKey(); key != "" {
+		metadata["key"] = key
+	}
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   broker.ErrorDomain,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"github.com/MeysamBavi/go-broker/internal/broker"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	pkgBroker "github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestBrokerClient(t *testing.T, module pkgBroker.Broker) pb.BrokerClient {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	pb.RegisterBrokerServer(s, NewServer(module, metrics.NewEmptyHandler(), store.GetDefaultTimeProvider(), logging.NewNopLogger(), auth.AllowAll(), ratelimit.NoLimit(), ValidationConfig{}, nil))
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return pb.NewBrokerClient(conn)
+}
+
+func credits(n int32) *pb.SubscribeWithCreditsRequest {
+	return &pb.SubscribeWithCreditsRequest{Request: &pb.SubscribeWithCreditsRequest_Credits{Credits: n}}
+}
+
+func TestSubscribeWithCreditsShouldOnlySendGrantedMessages(t *testing.T) {
+	module := broker.NewModule()
+	bc := newTestBrokerClient(t, module)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := bc.SubscribeWithCredits(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(&pb.SubscribeWithCreditsRequest{
+		Request: &pb.SubscribeWithCreditsRequest_Subscribe{Subscribe: &pb.SubscribeRequest{Subject: "ali"}},
+	}))
+	assert.Nil(t, stream.Send(credits(2)))
+	_, err = stream.Header()
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err := module.Publish(ctx, "ali", pkgBroker.Message{Body: "hello"})
+		assert.Nil(t, err)
+	}
+
+	received := make(chan int32, 5)
+	go func() {
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			received <- res.GetId()
+		}
+	}()
+
+	assert.Equal(t, int32(1), <-received)
+	assert.Equal(t, int32(2), <-received)
+	select {
+	case id := <-received:
+		assert.Fail(t, "message sent without credits", "id %d", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Nil(t, stream.Send(credits(3)))
+	for id := int32(3); id <= 5; id++ {
+		assert.Equal(t, id, <-received)
+	}
+}
+
+func TestSubscribeWithCreditsShouldRequireSubscribeFirst(t *testing.T) {
+	bc := newTestBrokerClient(t, broker.NewModule())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := bc.SubscribeWithCredits(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, stream.Send(credits(2)))
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// failingLimiter fails every call with an error that is not a limit being exceeded
+type failingLimiter struct{}
+
+func (failingLimiter) AllowPublish(string, string, string, int) error {
+	return errors.New("limiter is broken")
+}
+
+func (failingLimiter) AcquireSubscription(string, string, string) (func(), error) {
+	return nil, errors.New("limiter is broken")
+}
+
+func TestLimiterErrorsShouldBeInternal(t *testing.T) {
+	s := NewServer(broker.NewModule(), metrics.NewEmptyHandler(), store.GetDefaultTimeProvider(), logging.NewNopLogger(), auth.AllowAll(), failingLimiter{}, ValidationConfig{}, nil)
+
+	_, err := s.Publish(context.Background(), &pb.PublishRequest{Subject: "ali", Body: []byte("hello")})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"google.golang.org/grpc/credentials"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewTLSCredentials returns server credentials that pick up changes of
+// the certificate, key and client CA files without a restart, until ctx is done
+func NewTLSCredentials(ctx context.Context, config TLSConfig, logger *slog.Logger) (credentials.TransportCredentials, error) {
+	tlsConfig, err := NewTLSConfig(ctx, config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// NewTLSConfig is NewTLSCredentials for servers other than grpc,
+// such as the http gateway
+func NewTLSConfig(ctx context.Context, config TLSConfig, logger *slog.Logger) (*tls.Config, error) {
+	r := &certReloader{
+		config: config,
+		logger: logger,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	if config.ReloadInterval > 0 {
+		go r.watch(ctx)
+	}
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: r.getConfigForClient,
+	}, nil
+}
+
+type certReloader struct {
+	config  TLSConfig
+	logger  *slog.Logger
+	lock    sync.RWMutex
+	current *tls.Config
+	modTime time.Time
+}
+
+func (r *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.current, nil
+}
+
+func (r *certReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(r.config.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !r.changed() {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			r.logger.Error("could not reload tls certificates, keeping the previous ones", logging.Error(err))
+			continue
+		}
+		r.logger.Info("tls certificates reloaded")
+	}
+}
+
+// changed reports whether any of the files is modified after the last reload
+func (r *certReloader) changed() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return latestModTime(r.config.CertFile, r.config.KeyFile, r.config.ClientCAFile).After(r.modTime)
+}
+
+func (r *certReloader) reload() error {
+	modTime := latestModTime(r.config.CertFile, r.config.KeyFile, r.config.ClientCAFile)
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load key pair: %w", err)
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"},
+	}
+
+	if r.config.ClientCAFile != "" {
+		pool, err := loadCertPool(r.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.current = cfg
+	r.modTime = modTime
+
+	return nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ca file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid certificate found in %s", file)
+	}
+
+	return pool, nil
+}
+
+func latestModTime(files ...string) time.Time {
+	var latest time.Time
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeSelfSignedCert(t *testing.T, dir string, commonName string, modTime time.Time) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+func servedCommonName(t *testing.T, r *certReloader) string {
+	cfg, err := r.getConfigForClient(nil)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	assert.Nil(t, err)
+	return cert.Subject.CommonName
+}
+
+func TestCertReloaderShouldPickUpChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Minute)
+	certFile, keyFile := writeSelfSignedCert(t, dir, "first", start)
+
+	r := &certReloader{
+		config: TLSConfig{
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+		logger: logging.NewNopLogger(),
+	}
+	assert.Nil(t, r.reload())
+	assert.Equal(t, "first", servedCommonName(t, r))
+	assert.False(t, r.changed())
+
+	writeSelfSignedCert(t, dir, "second", start.Add(time.Second))
+	assert.True(t, r.changed())
+	assert.Nil(t, r.reload())
+	assert.Equal(t, "second", servedCommonName(t, r))
+}
+
+func TestCertReloaderShouldRequireClientCertWithCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "server", time.Now())
+
+	r := &certReloader{
+		config: TLSConfig{
+			CertFile:     certFile,
+			KeyFile:      keyFile,
+			ClientCAFile: certFile,
+		},
+		logger: logging.NewNopLogger(),
+	}
+	assert.Nil(t, r.reload())
+
+	cfg, _ := r.getConfigForClient(nil)
+	assert.NotNil(t, cfg.ClientCAs)
+	assert.Equal(t, "RequireAndVerifyClientCert", cfg.ClientAuth.String())
+}
+
+func TestCertReloaderShouldStopWatchingWhenContextIsDone(t *testing.T) {
+	r := &certReloader{
+		config: TLSConfig{ReloadInterval: time.Millisecond},
+		logger: logging.NewNopLogger(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		r.watch(ctx)
+		close(stopped)
+	}()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("watch did not stop")
+	}
+}
+
+func TestTLSConfigShouldServeHTTP(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t, t.TempDir(), "gateway", time.Now())
+	cfg, err := NewTLSConfig(context.Background(), TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}, logging.NewNopLogger())
+	assert.Nil(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	res, err := client.Get(ts.URL)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "gateway", res.TLS.PeerCertificates[0].Subject.CommonName)
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"fmt"
+	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+const (
+	subjectField    = "subject"
+	bodyField       = "body"
+	expirationField = "expirationSeconds"
+	idField         = "id"
+	deliverAtField  = "deliverAtUnixMillis"
+	headersField    = "headers"
+	schemaField     = "schemaVersion"
+	timeoutField    = "timeoutMillis"
+	creditsField    = "credits"
+	filterField     = "filter"
+	priorityField   = "priority"
+)
+
+// maxPriority is the highest priority a message can be published with
+const maxPriority = 9
+
+// defaultRequestTimeout is how long a Request waits for its reply,
+// if no timeout is provided
+const defaultRequestTimeout = 5 * time.Second
+
+type validator struct {
+	config   ValidationConfig
+	policies *policy.Registry
+	now      func() time.Time
+}
+
+// newValidator returns a validator applying config to all subjects,
+// and the limits of policies, which may be nil, to the matching ones
+func newValidator(config ValidationConfig, policies *policy.Registry) *validator {
+	return &validator{
+		config:   config,
+		policies: policies,
+		now:      time.Now,
+	}
+}
+
+// validatePublish returns the expiration to be used for the message,
+// or an InvalidArgument status with the violations of the request
+func (v *validator) validatePublish(request *pb.PublishRequest) (time.Duration, error) {
+	violations := v.subjectViolations(request.GetSubject())
+	p, _ := v.policies.For(request.GetSubject())
+
+	for _, max := range []int{v.config.MaxBodySize, p.MaxMessageSize} {
+		if max > 0 && len(request.GetBody()) > max {
+			violations = append(violations, violation(bodyField, "body size %d exceeds the maximum of %d bytes", len(request.GetBody()), max))
+			break
+		}
+	}
+
+	ttl := time.Duration(request.GetExpirationSeconds()) * time.Second
+	switch {
+	case ttl < 0:
+		violations = append(violations, violation(expirationField, "must not be negative"))
+	case ttl == 0 && p.DefaultTTL > 0:
+		ttl = p.DefaultTTL
+	case ttl == 0:
+		ttl = v.config.DefaultTTL
+	}
+	if ttl >= 0 {
+		if ttl < v.config.MinTTL {
+			violations = append(violations, violation(expirationField, "must be at least %s", v.config.MinTTL))
+		}
+		for _, max := range []time.Duration{v.config.MaxTTL, p.MaxTTL} {
+			if max > 0 && ttl > max {
+				violations = append(violations, violation(expirationField, "must be at most %s", max))
+				break
+			}
+		}
+	}
+
+	if _, ok := request.GetHeaders()[""]; ok {
+		violations = append(violations, violation(headersField, "keys must not be empty"))
+	}
+
+	if priority := request.GetPriority(); priority < 0 || priority > maxPriority {
+		violations = append(violations, violation(priorityField, "must be between 0 and %d", maxPriority))
+	}
+
+	if request.GetSchemaVersion() < 0 {
+		violations = append(violations, violation(schemaField, "must not be negative"))
+	}
+
+	switch deliverAt := request.GetDeliverAtUnixMillis(); {
+	case deliverAt < 0:
+		violations = append(violations, violation(deliverAtField, "must not be negative"))
+	case deliverAt > 0 && v.config.MaxDelay > 0:
+		if delay := time.UnixMilli(deliverAt).Sub(v.now()); delay > v.config.MaxDelay {
+			violations = append(violations, violation(deliverAtField, "delay of %s exceeds the maximum of %s", delay.Round(time.Second), v.config.MaxDelay))
+		}
+	}
+
+	return ttl, invalidArgument(violations)
+}
+
+// validateRequest only validates the timeout of request, and returns it;
+// its message is validated as it's published
+func (v *validator) validateRequest(request *pb.RequestRequest) (time.Duration, error) {
+	timeout := time.Duration(request.GetTimeoutMillis()) * time.Millisecond
+	if timeout < 0 {
+		return 0, invalidArgument([]*errdetails.BadRequest_FieldViolation{violation(timeoutField, "must not be negative")})
+	}
+	if max := v.config.MaxRequestTimeout; max > 0 && timeout > max {
+		return 0, invalidArgument([]*errdetails.BadRequest_FieldViolation{violation(timeoutField, "must be at most %s", max)})
+	}
+
+	if timeout == 0 {
+		timeout = defaultRequestTimeout
+		if max := v.config.MaxRequestTimeout; max > 0 && timeout > max {
+			timeout = max
+		}
+	}
+	return timeout, nil
+}
+
+// validateCredits validates a request following the one opening the subscription
+func (v *validator) validateCredits(request *pb.SubscribeWithCreditsRequest) error {
+	if request.GetSubscribe() != nil {
+		return status.Error(codes.InvalidArgument, "the subscription is already open")
+	}
+	if request.GetCredits() <= 0 {
+		return invalidArgument([]*errdetails.BadRequest_FieldViolation{violation(creditsField, "must be positive")})
+	}
+
+	return nil
+}
+
+func (v *validator) validateSubscribe(request *pb.SubscribeRequest) error {
+	return invalidArgument(v.subjectViolations(request.GetSubject()))
+}
+
+func (v *validator) validateFetch(request *pb.FetchRequest) error {
+	violations := v.subjectViolations(request.GetSubject())
+	if request.GetKey() == "" && request.GetId() <= 0 {
+		violations = append(violations, violation(idField, "must be positive, unless key is provided"))
+	}
+
+	return invalidArgument(violations)
+}
+
+func (v *validator) subjectViolations(subject string) []*errdetails.BadRequest_FieldViolation {
+	if subject == "" {
+		return []*errdetails.BadRequest_FieldViolation{violation(subjectField, "must not be empty")}
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	if max := v.config.MaxSubjectLength; max > 0 && len(subject) > max {
+		violations = append(violations, violation(subjectField, "length %d exceeds the maximum of %d", len(subject), max))
+	}
+
+	// namespaces are told apart by the separator in the stores
+	if strings.Contains(subject, namespace.Separator) {
+		violations = append(violations, violation(subjectField, "%q is not allowed", namespace.Separator))
+	} else if charset := v.config.SubjectCharset; charset != "" {
+		for _, r := range subject {
+			if !strings.ContainsRune(charset, r) {
+				violations = append(violations, violation(subjectField, "character %q is not allowed", r))
+				break
+			}
+		}
+	}
+
+	return violations
+}
+
+func violation(field string, format string, args ...any) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	}
+}
+
+// invalidArgument returns nil if there is no violation
+func invalidArgument(violations []*errdetails.BadRequest_FieldViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, len(violations))
+	for i, v := range violations {
+		descriptions[i] = v.GetField() + ": " + v.GetDescription()
+	}
+
+	st := status.New(codes.InvalidArgument, "invalid request; "+strings.Join(descriptions, ", "))
+	detailed, err := st.WithDetails(&errdetails.BadRequest{
+		FieldViolations: violations,
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testValidation = ValidationConfig{
+	MaxBodySize:      8,
+	MaxSubjectLength: 10,
+	SubjectCharset:   "abcdefghijklmnopqrstuvwxyz0123456789.",
+	MinTTL:           0,
+	MaxTTL:           time.Hour,
+	DefaultTTL:       time.Minute,
+	MaxDelay:         time.Hour,
+}
+
+// violatedFields returns the fields of the BadRequest detail of err
+func violatedFields(t *testing.T, err error) []string {
+	if err == nil {
+		return nil
+	}
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+
+	var fields []string
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range badRequest.GetFieldViolations() {
+				fields = append(fields, v.GetField())
+			}
+		}
+	}
+	return fields
+}
+
+func TestValidatePublish(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     ValidationConfig
+		request    *pb.PublishRequest
+		violations []string
+		ttl        time.Duration
+	}{
+		{
+			name:    "valid",
+			config:  testValidation,
+			request: &pb.PublishRequest{Subject: "orders.new", Body: []byte("body"), ExpirationSeconds: 10},
+			ttl:     10 * time.Second,
+		},
+		{
+			name:    "default ttl",
+			config:  testValidation,
+			request: &pb.PublishRequest{Subject: "orders", Body: []byte("body")},
+			ttl:     time.Minute,
+		},
+		{
+			name:       "empty subject",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: "", ExpirationSeconds: 10},
+			violations: []string{subjectField},
+			ttl:        10 * time.Second,
+		},
+		{
+			name:       "long subject",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: strings.Repeat("a", 11), ExpirationSeconds: 10},
+			violations: []string{subjectField},
+			ttl:        10 * time.Second,
+		},
+		{
+			name:       "disallowed character",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: "orders.*", ExpirationSeconds: 10},
+			violations: []string{subjectField},
+			ttl:        10 * time.Second,
+		},
+		{
+			name:       "large body",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: "orders", Body: []byte("123456789"), ExpirationSeconds: 10},
+			violations: []string{bodyField},
+			ttl:        10 * time.Second,
+		},
+		{
+			name:       "negative ttl",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: "orders", ExpirationSeconds: -1},
+			violations: []string{expirationField},
+			ttl:        -time.Second,
+		},
+		{
+			name:       "ttl above maximum",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: "orders", ExpirationSeconds: 3601},
+			violations: []string{expirationField},
+			ttl:        3601 * time.Second,
+		},
+		{
+			name:       "ttl below minimum",
+			config:     ValidationConfig{MinTTL: time.Minute},
+			request:    &pb.PublishRequest{Subject: "orders", ExpirationSeconds: 10},
+			violations: []string{expirationField},
+			ttl:        10 * time.Second,
+		},
+		{
+			name:       "multiple violations",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: "Orders", Body: []byte("123456789"), ExpirationSeconds: -5},
+			violations: []string{subjectField, bodyField, expirationField},
+			ttl:        -5 * time.Second,
+		},
+		{
+			name:    "scheduled",
+			config:  testValidation,
+			request: &pb.PublishRequest{Subject: "orders", ExpirationSeconds: 10, DeliverAtUnixMillis: time.Now().Add(time.Minute).UnixMilli()},
+			ttl:     10 * time.Second,
+		},
+		{
+			name:       "delay above maximum",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: "orders", ExpirationSeconds: 10, DeliverAtUnixMillis: time.Now().Add(2 * time.Hour).UnixMilli()},
+			violations: []string{deliverAtField},
+			ttl:        10 * time.Second,
+		},
+		{
+			name:       "negative delivery time",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: "orders", ExpirationSeconds: 10, DeliverAtUnixMillis: -1},
+			violations: []string{deliverAtField},
+			ttl:        10 * time.Second,
+		},
+		{
+			name:       "priority above maximum",
+			config:     testValidation,
+			request:    &pb.PublishRequest{Subject: "orders", ExpirationSeconds: 10, Priority: 10},
+			violations: []string{priorityField},
+			ttl:        10 * time.Second,
+		},
+		{
+			name:    "no limits",
+			config:  ValidationConfig{},
+			request: &pb.PublishRequest{Subject: "Any Subject!", Body: []byte(strings.Repeat("a", 1000))},
+			ttl:     0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ttl, err := newValidator(test.config, nil).validatePublish(test.request)
+			assert.Equal(t, test.violations, violatedFields(t, err))
+			assert.Equal(t, test.ttl, ttl)
+		})
+	}
+}
+
+func TestValidatePublishWithPolicy(t *testing.T) {
+	policies, err := policy.NewRegistry([]policy.Policy{
+		{Subject: "orders.>", DefaultTTL: 5 * time.Minute, MaxTTL: 10 * time.Minute, MaxMessageSize: 4},
+	})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name       string
+		request    *pb.PublishRequest
+		violations []string
+		ttl        time.Duration
+	}{
+		{
+			name:    "policy default ttl",
+			request: &pb.PublishRequest{Subject: "orders.new", Body: []byte("body")},
+			ttl:     5 * time.Minute,
+		},
+		{
+			name:       "above policy max ttl",
+			request:    &pb.PublishRequest{Subject: "orders.new", ExpirationSeconds: 1200},
+			violations: []string{expirationField},
+			ttl:        20 * time.Minute,
+		},
+		{
+			name:       "above policy max size",
+			request:    &pb.PublishRequest{Subject: "orders.new", Body: []byte("12345"), ExpirationSeconds: 10},
+			violations: []string{bodyField},
+			ttl:        10 * time.Second,
+		},
+		{
+			name:    "not matching the policy",
+			request: &pb.PublishRequest{Subject: "payments", Body: []byte("12345")},
+			ttl:     time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ttl, err := newValidator(testValidation, policies).validatePublish(test.request)
+			assert.Equal(t, test.violations, violatedFields(t, err))
+			assert.Equal(t, test.ttl, ttl)
+		})
+	}
+}
+
+func TestValidateFetch(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    *pb.FetchRequest
+		violations []string
+	}{
+		{"valid", &pb.FetchRequest{Subject: "orders", Id: 1}, nil},
+		{"zero id", &pb.FetchRequest{Subject: "orders", Id: 0}, []string{idField}},
+		{"negative id", &pb.FetchRequest{Subject: "orders", Id: -3}, []string{idField}},
+		{"empty subject", &pb.FetchRequest{Subject: "", Id: 1}, []string{subjectField}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := newValidator(testValidation, nil).validateFetch(test.request)
+			assert.Equal(t, test.violations, violatedFields(t, err))
+		})
+	}
+}
+
+func TestValidateSubscribe(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    *pb.SubscribeRequest
+		violations []string
+	}{
+		{"valid", &pb.SubscribeRequest{Subject: "orders.new"}, nil},
+		{"empty subject", &pb.SubscribeRequest{Subject: ""}, []string{subjectField}},
+		{"disallowed character", &pb.SubscribeRequest{Subject: "orders/new"}, []string{subjectField}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := newValidator(testValidation, nil).validateSubscribe(test.request)
+			assert.Equal(t, test.violations, violatedFields(t, err))
+		})
+	}
+}
+
+func TestNamespaceSeparatorShouldNotBeAllowedInSubjects(t *testing.T) {
+	// even if no charset is configured
+	err := newValidator(ValidationConfig{}, nil).validateSubscribe(&pb.SubscribeRequest{Subject: "team-a/orders"})
+	assert.Equal(t, []string{subjectField}, violatedFields(t, err))
+}
+
+func TestValidateCredits(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    *pb.SubscribeWithCreditsRequest
+		violations []string
+	}{
+		{"valid", &pb.SubscribeWithCreditsRequest{Request: &pb.SubscribeWithCreditsRequest_Credits{Credits: 10}}, nil},
+		{"zero credits", &pb.SubscribeWithCreditsRequest{Request: &pb.SubscribeWithCreditsRequest_Credits{Credits: 0}}, []string{creditsField}},
+		{"negative credits", &pb.SubscribeWithCreditsRequest{Request: &pb.SubscribeWithCreditsRequest_Credits{Credits: -1}}, []string{creditsField}},
+		{"no request", &pb.SubscribeWithCreditsRequest{}, []string{creditsField}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := newValidator(testValidation, nil).validateCredits(test.request)
+			assert.Equal(t, test.violations, violatedFields(t, err))
+		})
+	}
+
+	err := newValidator(testValidation, nil).validateCredits(&pb.SubscribeWithCreditsRequest{
+		Request: &pb.SubscribeWithCreditsRequest_Subscribe{Subscribe: &pb.SubscribeRequest{Subject: "orders"}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
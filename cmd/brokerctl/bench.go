@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func bench(args []string) error {
+	fs, conn := newFlagSet("bench")
+	messages := fs.Int("messages", 10000, "number of messages to publish")
+	concurrency := fs.Int("concurrency", 16, "number of concurrent publishers")
+	size := fs.Int("size", 128, "body size in bytes")
+	expiration := fs.Duration("expiration", 0, "expiration of the published messages")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: brokerctl bench [flags] <subject>")
+	}
+	if *messages <= 0 || *concurrency <= 0 {
+		return errors.New("messages and concurrency must be positive")
+	}
+	// the timeout bounds the whole run
+	conn.timeout = 0
+
+	c, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := conn.callContext()
+	defer cancel()
+
+	msg := broker.Message{
+		Body:       strings.Repeat("x", *size),
+		Expiration: *expiration,
+	}
+
+	var (
+		remaining atomic.Int64
+		failed    atomic.Int64
+		errOnce   sync.Once
+		firstErr  error
+		wg        sync.WaitGroup
+	)
+	remaining.Store(int64(*messages))
+	latencies := make([][]time.Duration, *concurrency)
+
+	start := time.Now()
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for remaining.Add(-1) >= 0 && ctx.Err() == nil {
+				callTime := time.Now()
+				if _, err := c.Publish(ctx, fs.Arg(0), msg); err != nil {
+					failed.Add(1)
+					errOnce.Do(func() {
+						firstErr = err
+					})
+					continue
+				}
+				latencies[w] = append(latencies[w], time.Since(callTime))
+			}
+		}(w)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i] < all[j]
+	})
+
+	fmt.Printf("published: %d, failed: %d, elapsed: %s\n", len(all), failed.Load(), elapsed.Round(time.Millisecond))
+	fmt.Printf("throughput: %.1f msg/s, %.2f MB/s\n",
+		float64(len(all))/elapsed.Seconds(),
+		float64(len(all)*(*size))/elapsed.Seconds()/(1<<20))
+	fmt.Printf("latency: p50 %s, p90 %s, p99 %s, max %s\n",
+		percentile(all, 0.5), percentile(all, 0.9), percentile(all, 0.99), percentile(all, 1))
+
+	if firstErr != nil {
+		return fmt.Errorf("%d publishes failed, first error: %w", failed.Load(), firstErr)
+	}
+	return nil
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
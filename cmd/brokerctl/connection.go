@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/MeysamBavi/go-broker/pkg/client"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const envPrefix = "BROKERCTL_"
+
+// connectionFlags are shared by every command
+type connectionFlags struct {
+	config  client.Config
+	timeout time.Duration
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *connectionFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	c := &connectionFlags{
+		config: client.DefaultConfig(),
+	}
+
+	fs.StringVar(&c.config.Host, "host", env("HOST", c.config.Host), "address of the broker")
+	fs.StringVar(&c.config.Token, "token", env("TOKEN", ""), "bearer token sent with every call")
+	fs.StringVar(&c.config.Namespace, "namespace", env("NAMESPACE", ""), "namespace of every call, instead of the one bound to the token")
+	fs.IntVar(&c.config.Connections, "connections", envInt("CONNECTIONS", c.config.Connections), "number of grpc connections")
+	fs.BoolVar(&c.config.TLS.Enabled, "tls", envBool("TLS", false), "connect using tls")
+	fs.StringVar(&c.config.TLS.CAFile, "ca-file", env("CA_FILE", ""), "ca used to verify the server, instead of the system roots")
+	fs.StringVar(&c.config.TLS.CertFile, "cert-file", env("CERT_FILE", ""), "client certificate for mutual tls")
+	fs.StringVar(&c.config.TLS.KeyFile, "key-file", env("KEY_FILE", ""), "client key for mutual tls")
+	fs.StringVar(&c.config.TLS.ServerName, "server-name", env("SERVER_NAME", ""), "overrides the server name verified by tls")
+	fs.StringVar(&c.config.Compression, "compression", env("COMPRESSION", ""), "compress calls with gzip, snappy or zstd")
+	fs.DurationVar(&c.timeout, "timeout", envDuration("TIMEOUT", 10*time.Second), "timeout of each call, 0 for none")
+
+	return fs, c
+}
+
+func (c *connectionFlags) connect() (client.Client, error) {
+	return client.New(c.config)
+}
+
+// callContext returns a context cancelled by the call timeout or an interrupt
+func (c *connectionFlags) callContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if c.timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+func env(name, fallback string) string {
+	if value, ok := os.LookupEnv(envPrefix + name); ok {
+		return value
+	}
+	return fallback
+}
+
+func envInt(name string, fallback int) int {
+	if value, err := strconv.Atoi(env(name, "")); err == nil {
+		return value
+	}
+	return fallback
+}
+
+func envBool(name string, fallback bool) bool {
+	if value, err := strconv.ParseBool(env(name, "")); err == nil {
+		return value
+	}
+	return fallback
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(env(name, "")); err == nil {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,58 @@
+// Command brokerctl publishes, fetches and tails the messages of a
+// go-broker server from a shell.
+//
+// Connection settings are read from flags, falling back to the
+// BROKERCTL_* environment variables:
+//
+//	brokerctl publish -host broker:50043 orders.created '{"id": 1}'
+//	BROKERCTL_TOKEN=secret brokerctl subscribe orders.created
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: brokerctl <command> [flags] [arguments]
+
+commands:
+  publish <subject> [body]   publish body, or stdin if omitted, and print its id
+  fetch <subject> <id>       print a stored message
+  subscribe <subject>        print incoming messages as json lines until interrupted
+  request <subject> [body]   publish body, or stdin if omitted, and print the first reply
+  subjects                   list the subjects known by the server
+  replay <subject> <id>      publish a dead-lettered message again to its original subject
+  bench <subject>            publish messages concurrently and report throughput and latency
+
+run 'brokerctl <command> -h' for the flags of a command
+`
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"publish":   publish,
+	"fetch":     fetch,
+	"subscribe": subscribe,
+	"request":   request,
+	"subjects":  subjects,
+	"replay":    replay,
+	"bench":     bench,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "brokerctl:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// messageLine is the json line printed for every message
+type messageLine struct {
+	Subject       string            `json:"subject"`
+	Id            int               `json:"id,omitempty"`
+	Key           string            `json:"key,omitempty"`
+	Body          string            `json:"body"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	SchemaVersion int               `json:"schema_version,omitempty"`
+}
+
+func printJSON(v any) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+// headerFlags collects repeated -header key=value flags
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	return fmt.Sprint(map[string]string(h))
+}
+
+func (h headerFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("header %q is not in the key=value form", value)
+	}
+	h[key] = val
+	return nil
+}
+
+func publish(args []string) error {
+	fs, conn := newFlagSet("publish")
+	expiration := fs.Duration("expiration", 0, "how long the message can be fetched, 0 for fire & forget")
+	delay := fs.Duration("delay", 0, "delay the delivery to subscribers")
+	headers := headerFlags{}
+	fs.Var(headers, "header", "key=value header of the message, can be repeated")
+	key := fs.String("key", "", "key of the message, fetchable with fetch -key")
+	schemaVersion := fs.Int("schema-version", 0, "schema version the body is validated against, 0 for the latest")
+	priority := fs.Int("priority", 0, "priority of the message, from 0 to 9, for the subscribers lagging behind")
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New("usage: brokerctl publish [flags] <subject> [body]")
+	}
+
+	var body string
+	if fs.NArg() == 2 {
+		body = fs.Arg(1)
+	} else {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("could not read body: %w", err)
+		}
+		body = string(b)
+	}
+
+	c, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := conn.callContext()
+	defer cancel()
+
+	msg := broker.Message{Body: body, Expiration: *expiration, Headers: headers, Key: *key, SchemaVersion: *schemaVersion, Priority: *priority}
+	if *delay > 0 {
+		msg.DeliverAt = time.Now().Add(*delay)
+	}
+	id, err := c.Publish(ctx, fs.Arg(0), msg)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(struct {
+		Subject string `json:"subject"`
+		Id      int    `json:"id"`
+	}{fs.Arg(0), id})
+}
+
+func fetch(args []string) error {
+	fs, conn := newFlagSet("fetch")
+	key := fs.String("key", "", "fetch the latest message of this key instead of an id")
+	_ = fs.Parse(args)
+	if *key == "" && fs.NArg() != 2 || *key != "" && fs.NArg() != 1 {
+		return errors.New("usage: brokerctl fetch [flags] <subject> <id>\n       brokerctl fetch [flags] -key <key> <subject>")
+	}
+	var id int
+	if *key == "" {
+		var err error
+		id, err = strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			return fmt.Errorf("invalid id %q", fs.Arg(1))
+		}
+	}
+
+	c, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := conn.callContext()
+	defer cancel()
+
+	var msg broker.Message
+	if *key != "" {
+		msg, err = c.FetchByKey(ctx, fs.Arg(0), *key)
+	} else {
+		msg, err = c.Fetch(ctx, fs.Arg(0), id)
+	}
+	if err != nil {
+		return err
+	}
+
+	return printJSON(messageLine{Subject: fs.Arg(0), Id: msg.Id, Key: msg.Key, Body: msg.Body, Headers: msg.Headers, SchemaVersion: msg.SchemaVersion})
+}
+
+func subscribe(args []string) error {
+	fs, conn := newFlagSet("subscribe")
+	count := fs.Int("count", 0, "exit after receiving this many messages, 0 for no limit")
+	window := fs.Int("window", 0, "receive at most this many messages ahead of printing them, 0 for no flow control")
+	headers := headerFlags{}
+	fs.Var(headers, "header", "key=value header the messages must have, can be repeated")
+	bodyPrefix := fs.String("body-prefix", "", "prefix the bodies of the messages must have")
+	expression := fs.String("filter", "", `expression the messages must satisfy, e.g. 'headers["type"] == "created"'`)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: brokerctl subscribe [flags] <subject>")
+	}
+	// the subscription lasts until interrupted, not for a single call
+	conn.timeout = 0
+
+	c, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := conn.callContext()
+	defer cancel()
+
+	filter := broker.Filter{Headers: headers, BodyPrefix: *bodyPrefix, Expression: *expression}
+	var ch <-chan broker.Message
+	if *window > 0 {
+		ch, err = c.SubscribeWithCredits(ctx, fs.Arg(0), filter, *window)
+	} else {
+		ch, err = c.SubscribeWithFilter(ctx, fs.Arg(0), filter)
+	}
+	if err != nil {
+		return err
+	}
+
+	received := 0
+	for msg := range ch {
+		if err := printJSON(messageLine{Subject: fs.Arg(0), Id: msg.Id, Key: msg.Key, Body: msg.Body, Headers: msg.Headers, SchemaVersion: msg.SchemaVersion}); err != nil {
+			return err
+		}
+		received++
+		if *count > 0 && received >= *count {
+			return nil
+		}
+	}
+
+	if ctx.Err() == nil {
+		return errors.New("subscription closed by the server")
+	}
+	return nil
+}
+
+// request waits for the reply as long as the -timeout flag allows
+func request(args []string) error {
+	fs, conn := newFlagSet("request")
+	headers := headerFlags{}
+	fs.Var(headers, "header", "key=value header of the message, can be repeated")
+	schemaVersion := fs.Int("schema-version", 0, "schema version the body is validated against, 0 for the latest")
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New("usage: brokerctl request [flags] <subject> [body]")
+	}
+
+	var body string
+	if fs.NArg() == 2 {
+		body = fs.Arg(1)
+	} else {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("could not read body: %w", err)
+		}
+		body = string(b)
+	}
+
+	c, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := conn.callContext()
+	defer cancel()
+
+	reply, err := c.Request(ctx, fs.Arg(0), broker.Message{Body: body, Headers: headers, SchemaVersion: *schemaVersion})
+	if err != nil {
+		return err
+	}
+
+	return printJSON(messageLine{Subject: fs.Arg(0), Id: reply.Id, Key: reply.Key, Body: reply.Body, Headers: reply.Headers, SchemaVersion: reply.SchemaVersion})
+}
+
+func subjects(args []string) error {
+	fs, conn := newFlagSet("subjects")
+	_ = fs.Parse(args)
+
+	c, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := conn.callContext()
+	defer cancel()
+
+	list, err := c.Subjects(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(list)
+
+	for _, subject := range list {
+		fmt.Println(subject)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"strconv"
+)
+
+// replay publishes a dead-lettered message again to its original subject
+func replay(args []string) error {
+	fs, conn := newFlagSet("replay")
+	expiration := fs.Duration("expiration", 0, "how long the replayed message can be fetched, 0 for fire & forget")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("usage: brokerctl replay [flags] <dead-letter subject> <id>")
+	}
+	id, err := strconv.Atoi(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid id %q", fs.Arg(1))
+	}
+
+	c, err := conn.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := conn.callContext()
+	defer cancel()
+
+	msg, err := c.Fetch(ctx, fs.Arg(0), id)
+	if err != nil {
+		return err
+	}
+	subject, ok := msg.Headers[broker.HeaderOriginalSubject]
+	if !ok {
+		return fmt.Errorf("message %d of %s has no %s header", id, fs.Arg(0), broker.HeaderOriginalSubject)
+	}
+
+	headers := make(map[string]string, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	delete(headers, broker.HeaderDeadLetterReason)
+	delete(headers, broker.HeaderOriginalSubject)
+	delete(headers, broker.HeaderOriginalId)
+	delete(headers, broker.HeaderDeadLetteredAt)
+
+	newId, err := c.Publish(ctx, subject, broker.Message{
+		Body:       msg.Body,
+		Expiration: *expiration,
+		Headers:    headers,
+		Key:        msg.Key,
+	})
+	if err != nil {
+		return err
+	}
+
+	return printJSON(messageLine{Subject: subject, Id: newId, Key: msg.Key, Body: msg.Body, Headers: headers})
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/MeysamBavi/go-broker/internal/subject"
+)
+
+type Action string
+
+const (
+	Publish   Action = "publish"
+	Subscribe Action = "subscribe"
+	Fetch     Action = "fetch"
+	Admin     Action = "admin"
+	// Namespaces allows managing the namespaces whose
+	// names match the subject patterns of a rule
+	Namespaces Action = "namespaces"
+
+	anyPrincipal = "*"
+)
+
+type Authorizer interface {
+	// Authorize reports whether principal is allowed to do action on subject
+	Authorize(principal string, action Action, subject string) bool
+	// AuthorizePattern reports whether principal is allowed to do
+	// action on every subject matched by the subject pattern
+	AuthorizePattern(principal string, action Action, pattern string) bool
+}
+
+type acl struct {
+	rules []Rule
+}
+
+func NewACL(rules []Rule) (Authorizer, error) {
+	for i, rule := range rules {
+		if rule.Principal == "" {
+			return nil, fmt.Errorf("acl rule %d has no principal", i)
+		}
+		for _, action := range rule.Actions {
+			switch action {
+			case Publish, Subscribe, Fetch, Admin, Namespaces:
+			default:
+				return nil, fmt.Errorf("acl rule %d has unknown action %q", i, action)
+			}
+		}
+	}
+
+	return &acl{
+		rules: rules,
+	}, nil
+}
+
+func (a *acl) Authorize(principal string, action Action, subjectName string) bool {
+	for _, rule := range a.rules {
+		if rule.Principal != principal && rule.Principal != anyPrincipal {
+			continue
+		}
+		if !hasAction(rule.Actions, action) {
+			continue
+		}
+		if subject.MatchAny(rule.Subjects, subjectName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *acl) AuthorizePattern(principal string, action Action, pattern string) bool {
+	for _, rule := range a.rules {
+		if rule.Principal != principal && rule.Principal != anyPrincipal {
+			continue
+		}
+		if !hasAction(rule.Actions, action) {
+			continue
+		}
+		for _, ruleSubject := range rule.Subjects {
+			if subject.Covers(ruleSubject, pattern) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hasAction(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+type allowAll struct{}
+
+// AllowAll returns an Authorizer used when authentication is disabled
+func AllowAll() Authorizer {
+	return allowAll{}
+}
+
+func (allowAll) Authorize(string, Action, string) bool {
+	return true
+}
+
+func (allowAll) AuthorizePattern(string, Action, string) bool {
+	return true
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestACL(t *testing.T) {
+	authorizer, err := NewACL([]Rule{
+		{Principal: "orders-service", Subjects: []string{"orders.>"}, Actions: []Action{Publish, Fetch}},
+		{Principal: "*", Subjects: []string{"public.*"}, Actions: []Action{Subscribe}},
+	})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		principal string
+		action    Action
+		subject   string
+		allowed   bool
+	}{
+		{"orders-service", Publish, "orders.new", true},
+		{"orders-service", Fetch, "orders.new.eu", true},
+		{"orders-service", Subscribe, "orders.new", false},
+		{"orders-service", Publish, "orders", false},
+		{"orders-service", Publish, "payments.new", false},
+		{"someone", Subscribe, "public.news", true},
+		{"someone", Subscribe, "public.news.sport", false},
+		{"someone", Publish, "public.news", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.allowed, authorizer.Authorize(test.principal, test.action, test.subject),
+			"%s %s %s", test.principal, test.action, test.subject)
+	}
+}
+
+func TestACLAuthorizePattern(t *testing.T) {
+	authorizer, err := NewACL([]Rule{
+		{Principal: "ops", Subjects: []string{"orders.>", "payments.*"}, Actions: []Action{Admin}},
+	})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		pattern string
+		allowed bool
+	}{
+		{"orders.eu", true},
+		{"orders.*", true},
+		{"orders.>", true},
+		{"orders.*.>", true},
+		{"orders", false},
+		{">", false},
+		{"*.eu", false},
+		{"payments.new", true},
+		{"payments.*", true},
+		{"payments.>", false},
+		{"payments.*.eu", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.allowed, authorizer.AuthorizePattern("ops", Admin, test.pattern), test.pattern)
+	}
+}
+
+func TestNewACLShouldRejectUnknownActions(t *testing.T) {
+	_, err := NewACL([]Rule{{Principal: "p", Subjects: []string{">"}, Actions: []Action{"delete"}}})
+	assert.NotNil(t, err)
+}
+
+func TestStaticTokens(t *testing.T) {
+	authenticator, err := NewAuthenticator(Config{
+		Tokens: []TokenConfig{{Token: "secret", Principal: "alice"}},
+	})
+	assert.Nil(t, err)
+
+	principal, err := authenticator.Authenticate("secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", principal)
+
+	_, err = authenticator.Authenticate("wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = authenticator.Authenticate("")
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestJWT(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	jwks, _ := json.Marshal(map[string]any{
+		"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}},
+	})
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, os.WriteFile(jwksFile, jwks, 0600))
+
+	authenticator, err := NewAuthenticator(Config{
+		JWT: JWTConfig{Enabled: true, JWKSFile: jwksFile, Issuer: "issuer", Audience: "go-broker"},
+	})
+	assert.Nil(t, err)
+
+	sign := func(claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(input))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		assert.Nil(t, err)
+		signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+
+	valid := map[string]any{"sub": "bob", "iss": "issuer", "aud": "go-broker", "exp": time.Now().Add(time.Hour).Unix()}
+	principal, err := authenticator.Authenticate(sign(valid))
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", principal)
+
+	expired := map[string]any{"sub": "bob", "iss": "issuer", "aud": "go-broker", "exp": time.Now().Add(-time.Hour).Unix()}
+	_, err = authenticator.Authenticate(sign(expired))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	withoutExpiration := map[string]any{"sub": "bob", "iss": "issuer", "aud": "go-broker"}
+	_, err = authenticator.Authenticate(sign(withoutExpiration))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	notYetValid := map[string]any{"sub": "bob", "iss": "issuer", "aud": "go-broker",
+		"exp": time.Now().Add(2 * time.Hour).Unix(), "nbf": time.Now().Add(time.Hour).Unix()}
+	_, err = authenticator.Authenticate(sign(notYetValid))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	otherAudience := map[string]any{"sub": "bob", "iss": "issuer", "aud": []string{"other"}, "exp": time.Now().Add(time.Hour).Unix()}
+	_, err = authenticator.Authenticate(sign(otherAudience))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	tampered := sign(valid)
+	_, err = authenticator.Authenticate(tampered[:len(tampered)-4] + "AAAA")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestInterceptorShouldSkipPublicServices(t *testing.T) {
+	authenticator, err := NewAuthenticator(Config{
+		Tokens: []TokenConfig{{Token: "secret", Principal: "alice"}},
+	})
+	assert.Nil(t, err)
+	interceptor := UnaryServerInterceptor(authenticator, "grpc.health.v1.Health")
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+
+	res, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", res)
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/broker.Broker/Publish"}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials provided")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+type Authenticator interface {
+	// Authenticate returns the principal the bearer token belongs to
+	Authenticate(token string) (string, error)
+}
+
+// NewAuthenticator returns an Authenticator trying the static tokens
+// and then the jwt validation, whichever is configured
+func NewAuthenticator(config Config) (Authenticator, error) {
+	var chain authenticatorChain
+
+	if len(config.Tokens) > 0 {
+		chain = append(chain, newStaticTokens(config.Tokens))
+	}
+
+	if config.JWT.Enabled {
+		j, err := newJWTAuthenticator(config.JWT)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, j)
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("authentication is enabled but neither tokens nor jwt is configured")
+	}
+
+	return chain, nil
+}
+
+type authenticatorChain []Authenticator
+
+func (c authenticatorChain) Authenticate(token string) (string, error) {
+	if token == "" {
+		return "", ErrNoCredentials
+	}
+
+	err := ErrInvalidCredentials
+	for _, authenticator := range c {
+		var principal string
+		principal, err = authenticator.Authenticate(token)
+		if err == nil {
+			return principal, nil
+		}
+	}
+
+	return "", err
+}
+
+type staticTokens struct {
+	tokens []TokenConfig
+}
+
+func newStaticTokens(tokens []TokenConfig) Authenticator {
+	return &staticTokens{
+		tokens: tokens,
+	}
+}
+
+func (s *staticTokens) Authenticate(token string) (string, error) {
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return t.Principal, nil
+		}
+	}
+
+	return "", ErrInvalidCredentials
+}
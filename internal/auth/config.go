@@ -0,0 +1,33 @@
+package auth
+
+type Config struct {
+	Enabled bool          `config:"enabled"`
+	Tokens  []TokenConfig `config:"tokens"`
+	JWT     JWTConfig     `config:"jwt"`
+	ACL     []Rule        `config:"acl"`
+}
+
+// TokenConfig maps a static api token to the principal it authenticates
+type TokenConfig struct {
+	Token     string `config:"token"`
+	Principal string `config:"principal"`
+}
+
+type JWTConfig struct {
+	Enabled bool `config:"enabled"`
+	// JWKSFile is a local json web key set used to verify token signatures
+	JWKSFile string `config:"jwks_file"`
+	// Issuer and Audience are checked only if they are not empty
+	Issuer   string `config:"issuer"`
+	Audience string `config:"audience"`
+	// PrincipalClaim is the claim used as the principal name
+	PrincipalClaim string `config:"principal_claim"`
+}
+
+// Rule grants Actions on the subjects matching any of Subjects patterns
+// to Principal; "*" as the principal applies to every authenticated client
+type Rule struct {
+	Principal string   `config:"principal"`
+	Subjects  []string `config:"subjects"`
+	Actions   []Action `config:"actions"`
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+const (
+	authorizationHeader = "authorization"
+	bearerPrefix        = "bearer "
+)
+
+// UnaryServerInterceptor authenticates every call, except the calls to
+// publicServices, such as health checks and reflection, named like
+// "grpc.health.v1.Health"
+func UnaryServerInterceptor(authenticator Authenticator, publicServices ...string) grpc.UnaryServerInterceptor {
+	public := toSet(publicServices)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if public[serviceOf(info.FullMethod)] {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticate(ctx, authenticator)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor(authenticator Authenticator, publicServices ...string) grpc.StreamServerInterceptor {
+	public := toSet(publicServices)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if public[serviceOf(info.FullMethod)] {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticate(ss.Context(), authenticator)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// serviceOf returns the service of a method named like "/package.Service/Method"
+func serviceOf(fullMethod string) string {
+	service := strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(service, "/"); i >= 0 {
+		return service[:i]
+	}
+	return service
+}
+
+// BearerToken extracts the token from an "authorization: Bearer <token>" value
+func BearerToken(value string) string {
+	if len(value) < len(bearerPrefix) || !strings.EqualFold(value[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(value[len(bearerPrefix):])
+}
+
+func authenticate(ctx context.Context, authenticator Authenticator) (context.Context, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(authorizationHeader); len(values) > 0 {
+			token = BearerToken(values[0])
+		}
+	}
+
+	principal, err := authenticator.Authenticate(token)
+	if err != nil {
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return WithPrincipal(ctx, principal), nil
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *authenticatedStream) Context() context.Context {
+	return a.ctx
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPrincipalClaim = "sub"
+
+	rs256 = "RS256"
+	es256 = "ES256"
+)
+
+type jwtAuthenticator struct {
+	config JWTConfig
+	keys   map[string]crypto.PublicKey
+	now    func() time.Time
+}
+
+func newJWTAuthenticator(config JWTConfig) (*jwtAuthenticator, error) {
+	if config.PrincipalClaim == "" {
+		config.PrincipalClaim = defaultPrincipalClaim
+	}
+
+	content, err := os.ReadFile(config.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read jwks file: %w", err)
+	}
+
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwtAuthenticator{
+		config: config,
+		keys:   keys,
+		now:    time.Now,
+	}, nil
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func parseJWKS(content []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("could not parse jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in jwks: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (j *jwtAuthenticator) Authenticate(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidCredentials
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", ErrInvalidCredentials
+	}
+
+	key, ok := j.keys[header.Kid]
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidCredentials
+	}
+	if !verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature) {
+		return "", ErrInvalidCredentials
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", ErrInvalidCredentials
+	}
+
+	return j.validateClaims(claims)
+}
+
+func (j *jwtAuthenticator) validateClaims(claims map[string]any) (string, error) {
+	now := j.now()
+	// a token without an expiration would be valid forever
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", fmt.Errorf("%w: no exp claim", ErrInvalidCredentials)
+	}
+	if now.After(time.Unix(int64(exp), 0)) {
+		return "", fmt.Errorf("%w: token is expired", ErrInvalidCredentials)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		return "", fmt.Errorf("%w: token is not valid yet", ErrInvalidCredentials)
+	}
+
+	if j.config.Issuer != "" && claims["iss"] != j.config.Issuer {
+		return "", fmt.Errorf("%w: unexpected issuer", ErrInvalidCredentials)
+	}
+	if j.config.Audience != "" && !hasAudience(claims["aud"], j.config.Audience) {
+		return "", fmt.Errorf("%w: unexpected audience", ErrInvalidCredentials)
+	}
+
+	principal, ok := claims[j.config.PrincipalClaim].(string)
+	if !ok || principal == "" {
+		return "", fmt.Errorf("%w: no %s claim", ErrInvalidCredentials, j.config.PrincipalClaim)
+	}
+
+	return principal, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case rs256:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) == nil
+	case es256:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(ecKey, digest[:], r, s)
+	}
+
+	return false
+}
+
+func hasAudience(aud any, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []any:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
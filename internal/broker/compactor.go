@@ -0,0 +1,50 @@
+package broker
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"log/slog"
+	"time"
+)
+
+// compact periodically removes the superseded messages of the
+// compacted subjects, until stop is closed
+func (m *Module) compact(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.compactAll(context.Background())
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (m *Module) compactAll(ctx context.Context) {
+	subjects, err := m.msgStore.Subjects(ctx)
+	if err != nil {
+		m.logger.ErrorContext(ctx, "could not list subjects to compact", logging.Error(err))
+		return
+	}
+
+	for _, subject := range subjects {
+		// policies apply to the subjects of every namespace
+		_, name := namespace.Split(subject)
+		if p, ok := m.policies.For(name); !ok || !p.Compacted {
+			continue
+		}
+
+		removed, err := m.msgStore.Compact(ctx, subject)
+		if err != nil {
+			m.logger.ErrorContext(ctx, "could not compact subject", logging.Subject(subject), logging.Error(err))
+			continue
+		}
+		if removed > 0 {
+			m.logger.DebugContext(ctx, "subject compacted", logging.Subject(subject), slog.Int("removed", removed))
+		}
+	}
+}
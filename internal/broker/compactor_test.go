@@ -0,0 +1,71 @@
+package broker
+
+import (
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFetchByKeyShouldReturnLatestMessage(t *testing.T) {
+	service = NewModule()
+	first := createMessageWithExpire(time.Minute)
+	first.Key = "user-1"
+	latest := createMessageWithExpire(time.Minute)
+	latest.Key = "user-1"
+
+	_, err := service.Publish(mainCtx, "ali", first)
+	assert.Nil(t, err)
+	id, err := service.Publish(mainCtx, "ali", latest)
+	assert.Nil(t, err)
+
+	msg, err := service.FetchByKey(mainCtx, "ali", "user-1")
+	assert.Nil(t, err)
+	assert.Equal(t, id, msg.Id)
+	assertMessagesEqual(t, latest, msg)
+
+	_, err = service.FetchByKey(mainCtx, "ali", "user-2")
+	assert.Equal(t, broker.ErrKeyNotFound, err)
+}
+
+func TestCompactedSubjectShouldOnlyRetainLatestMessageOfKeys(t *testing.T) {
+	logger := logging.NewNopLogger()
+	policies, err := policy.NewRegistry([]policy.Policy{
+		{Subject: "users.>", Compacted: true},
+	})
+	assert.Nil(t, err)
+	module, err := NewModuleWithStores(Config{CompactionInterval: 10 * time.Millisecond}, policies, nil,
+		store.NewInMemoryMessage(store.GetDefaultTimeProvider()), store.NewInMemorySubscriber(logger), store.NewInMemorySchedule(), metrics.NewEmptyHandler(), logger)
+	assert.Nil(t, err)
+	defer module.Close()
+
+	publish := func(subject, key string) int {
+		msg := createMessageWithExpire(time.Minute)
+		msg.Key = key
+		id, err := module.Publish(mainCtx, subject, msg)
+		assert.Nil(t, err)
+		return id
+	}
+	superseded := publish("users.profile", "1")
+	keyless := publish("users.profile", "")
+	latest := publish("users.profile", "1")
+	other := publish("users.profile", "2")
+	notCompacted := publish("profiles", "1")
+	publish("profiles", "1")
+
+	assert.Eventually(t, func() bool {
+		_, err := module.Fetch(mainCtx, "users.profile", superseded)
+		return err == broker.ErrExpiredID
+	}, time.Second, 10*time.Millisecond)
+
+	for _, id := range []int{keyless, latest, other} {
+		_, err := module.Fetch(mainCtx, "users.profile", id)
+		assert.Nil(t, err)
+	}
+	_, err = module.Fetch(mainCtx, "profiles", notCompacted)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,21 @@
+package broker
+
+import "time"
+
+type Config struct {
+	DeadLetter DeadLetterConfig `config:"dead_letter"`
+	// CompactionInterval is how often compacted subjects are compacted,
+	// 0 disables compaction
+	CompactionInterval time.Duration `config:"compaction_interval"`
+}
+
+// DeadLetterConfig configures where the messages that a subscriber
+// could not take in time are kept for inspection and replay
+type DeadLetterConfig struct {
+	Enabled bool `config:"enabled"`
+	// Suffix is appended to a subject to get its dead-letter subject;
+	// drops on dead-letter subjects themselves are only logged
+	Suffix string `config:"suffix"`
+	// Expiration is how long dead-lettered messages can be fetched
+	Expiration time.Duration `config:"expiration"`
+}
@@ -2,9 +2,23 @@ package broker
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/MeysamBavi/go-broker/internal/filter"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"github.com/MeysamBavi/go-broker/internal/schema"
 	"github.com/MeysamBavi/go-broker/internal/store"
 	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -12,67 +26,268 @@ const (
 )
 
 type Module struct {
+	config      Config
+	policies    *policy.Registry
+	schemas     *schema.Registry
 	msgStore    store.Message
 	subscribers store.Subscriber
-	closed      bool
+	schedule    store.Schedule
+	scheduler   *scheduler
+	clock       clock
+	sequencer   *sequencer
+	compaction  chan struct{}
+	metrics     metrics.Handler
+	logger      *slog.Logger
+	closed      atomic.Bool
 }
 
 func NewModule() broker.Broker {
-	return &Module{
+	return newModule(realClock{})
+}
+
+func newModule(c clock) *Module {
+	logger := logging.NewNopLogger()
+	m := &Module{
+		config: Config{
+			DeadLetter: DeadLetterConfig{
+				Enabled:    true,
+				Suffix:     ".dlq",
+				Expiration: time.Hour,
+			},
+		},
 		msgStore:    store.NewInMemoryMessage(store.GetDefaultTimeProvider()),
-		subscribers: store.NewInMemorySubscriber(),
-		closed:      false,
+		subscribers: store.NewInMemorySubscriber(logger),
+		schedule:    store.NewInMemorySchedule(),
+		metrics:     metrics.NewEmptyHandler(),
+		logger:      logger,
+		clock:       c,
 	}
+	m.scheduler = newScheduler(c, m.deliver)
+	m.sequencer = newSequencer(m.subscribers.Publish)
+
+	return m
 }
 
-func NewModuleWithStores(message store.Message, subscriber store.Subscriber) broker.Broker {
-	return &Module{
+// NewModuleWithStores returns a Module that resumes
+// the scheduled messages still pending in schedule
+func NewModuleWithStores(config Config, policies *policy.Registry, schemas *schema.Registry, message store.Message, subscriber store.Subscriber, schedule store.Schedule, metricsHandler metrics.Handler, logger *slog.Logger) (*Module, error) {
+	m := &Module{
+		config:      config,
+		policies:    policies,
+		schemas:     schemas,
 		msgStore:    message,
 		subscribers: subscriber,
-		closed:      false,
+		schedule:    schedule,
+		metrics:     metricsHandler,
+		logger:      logger,
+		clock:       realClock{},
+	}
+
+	pending, err := schedule.Pending(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("could not load scheduled messages: %w", err)
 	}
+	m.scheduler = newScheduler(m.clock, m.deliver)
+	m.sequencer = newSequencer(m.subscribers.Publish)
+	for _, scheduled := range pending {
+		m.scheduler.add(scheduled)
+	}
+	logger.Info("scheduled messages loaded", slog.Int("count", len(pending)))
+
+	if config.CompactionInterval > 0 {
+		m.compaction = make(chan struct{})
+		go m.compact(config.CompactionInterval, m.compaction)
+	}
+
+	return m, nil
 }
 
 func (m *Module) Close() error {
-	m.closed = true
+	if m.closed.Swap(true) {
+		return nil
+	}
+	m.scheduler.close()
+	if m.compaction != nil {
+		close(m.compaction)
+	}
+	m.logger.Info("broker module closed")
 	return nil
 }
 
+// Publish keeps the messages, sequences and schemas of every namespace
+// apart by qualifying subject, while policies apply to all namespaces
 func (m *Module) Publish(ctx context.Context, subject string, msg broker.Message) (int, error) {
-	if m.closed {
+	if m.closed.Load() {
 		return 0, broker.ErrUnavailable
 	}
 
-	err := m.msgStore.SaveMessage(ctx, subject, &msg)
+	qualified := namespace.Qualify(namespace.FromContext(ctx), subject)
+	version, err := m.schemas.Validate(qualified, msg.SchemaVersion, []byte(msg.Body))
+	if errors.Is(err, schema.ErrUnknownVersion) {
+		return 0, broker.ErrUnknownSchemaVersion
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", broker.ErrSchemaViolation, err)
+	}
+	msg.SchemaVersion = version
+
+	// replies only matter to the request waiting on the inbox, so they are
+	// neither stored nor sequenced, and are dropped once it's done
+	if strings.HasPrefix(subject, broker.InboxPrefix) {
+		m.subscribers.Publish(ctx, qualified, &msg)
+		return 0, nil
+	}
+
+	p, hasPolicy := m.policies.For(subject)
+	if hasPolicy && msg.Expiration == 0 {
+		msg.Expiration = p.DefaultTTL
+	}
+
+	ticket := m.sequencer.begin(qualified)
+	err = m.msgStore.SaveMessage(ctx, qualified, &msg)
 	if err != nil {
+		m.sequencer.skip(qualified, ticket)
 		return 0, fmt.Errorf("unexpected error while saving message: %w", err)
 	}
-	m.subscribers.Publish(ctx, subject, &msg)
+
+	if hasPolicy && p.MaxMessages > 0 && msg.Id > p.MaxMessages {
+		if err := m.msgStore.Trim(ctx, qualified, msg.Id-p.MaxMessages+1); err != nil {
+			m.logger.ErrorContext(ctx, "could not remove messages beyond retention",
+				logging.Subject(qualified), logging.MessageId(msg.Id), logging.Error(err))
+		}
+	}
+
+	// scheduled messages are delivered in the order of their delivery time instead
+	if m.clock.now().Before(msg.DeliverAt) {
+		m.sequencer.skip(qualified, ticket)
+		// the message is already stored, so it's still delivered, but it's lost on restart
+		if err := m.schedule.Add(ctx, qualified, &msg); err != nil {
+			m.logger.ErrorContext(ctx, "could not persist scheduled message",
+				logging.Subject(qualified), logging.MessageId(msg.Id), logging.Error(err))
+		}
+		m.scheduler.add(store.ScheduledMessage{Subject: qualified, Message: msg})
+		m.logger.DebugContext(ctx, "message scheduled", logging.Subject(qualified), logging.MessageId(msg.Id))
+
+		return msg.Id, nil
+	}
+	m.sequencer.publish(ctx, qualified, ticket, &msg)
 
 	return msg.Id, nil
 }
 
+// deliver publishes a scheduled message to the subscribers once it's due
+func (m *Module) deliver(scheduled store.ScheduledMessage) {
+	ctx := context.Background()
+	m.subscribers.Publish(ctx, scheduled.Subject, &scheduled.Message)
+
+	if err := m.schedule.Remove(ctx, scheduled.Subject, scheduled.Message.Id); err != nil {
+		m.logger.ErrorContext(ctx, "could not remove delivered message from schedule",
+			logging.Subject(scheduled.Subject), logging.MessageId(scheduled.Message.Id), logging.Error(err))
+	}
+}
+
+// PurgeNamespace deletes the messages, scheduled messages and schemas of
+// every subject of the namespace of name, and resets their ids, so that a
+// namespace created later with the same name starts empty; it returns
+// the number of deleted messages
+func (m *Module) PurgeNamespace(ctx context.Context, name string) (int, error) {
+	inNamespace := func(qualified string) bool {
+		ns, _ := namespace.Split(qualified)
+		return ns == name
+	}
+
+	m.scheduler.remove(func(scheduled store.ScheduledMessage) bool {
+		return inNamespace(scheduled.Subject)
+	})
+	pending, err := m.schedule.Pending(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not list scheduled messages: %w", err)
+	}
+	for _, scheduled := range pending {
+		if !inNamespace(scheduled.Subject) {
+			continue
+		}
+		if err := m.schedule.Remove(ctx, scheduled.Subject, scheduled.Message.Id); err != nil {
+			return 0, fmt.Errorf("could not remove scheduled message: %w", err)
+		}
+	}
+
+	for _, qualified := range m.schemas.Subjects() {
+		if !inNamespace(qualified) {
+			continue
+		}
+		if err := m.schemas.Delete(ctx, qualified); err != nil {
+			return 0, err
+		}
+	}
+
+	subjects, err := m.msgStore.Subjects(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not list subjects: %w", err)
+	}
+	deleted := 0
+	for _, qualified := range subjects {
+		if !inNamespace(qualified) {
+			continue
+		}
+		count, err := m.msgStore.Delete(ctx, qualified)
+		if err != nil {
+			return deleted, fmt.Errorf("could not delete messages of %s: %w", qualified, err)
+		}
+		deleted += count
+	}
+
+	return deleted, nil
+}
+
 func (m *Module) Subscribe(ctx context.Context, subject string) (<-chan broker.Message, error) {
-	if m.closed {
+	return m.SubscribeWithFilter(ctx, subject, broker.Filter{})
+}
+
+// SubscribeWithFilter evaluates f as the messages are fanned out, so that
+// the messages not selected take no room in the buffer of the subscriber
+func (m *Module) SubscribeWithFilter(ctx context.Context, subject string, f broker.Filter) (<-chan broker.Message, error) {
+	if m.closed.Load() {
 		return nil, broker.ErrUnavailable
 	}
 
-	ch := make(chan broker.Message, subscribeChannelBuffer)
-	callback := func(msg *broker.Message) {
-		ch <- *msg
+	matcher, err := filter.Compile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", broker.ErrInvalidFilter, err)
+	}
+
+	qualified := namespace.Qualify(namespace.FromContext(ctx), subject)
+	queue := newSubscriberQueue(ctx, subscribeChannelBuffer)
+	callback := func(publishCtx context.Context, msg *broker.Message) error {
+		if matcher != nil {
+			start := time.Now()
+			matched := matcher.Match(msg)
+			m.metrics.ReportFilterEvaluation(time.Since(start), matched)
+			if !matched {
+				return nil
+			}
+		}
+
+		// once the subscriber is gone, there is nobody to miss the message
+		if err := queue.push(ctx, publishCtx, *msg); err != nil {
+			go m.deadLetter(qualified, msg, broker.ReasonSubscriberTimeout)
+			return err
+		}
+		return nil
 	}
-	m.subscribers.AddSubscriber(ctx, subject, callback)
+	m.subscribers.AddSubscriber(ctx, qualified, callback)
+	m.logger.DebugContext(ctx, "subscriber added", logging.Subject(qualified))
 
-	return ch, nil
+	return queue.out, nil
 }
 
 func (m *Module) Fetch(ctx context.Context, subject string, id int) (broker.Message, error) {
 	var emptyResult broker.Message
-	if m.closed {
+	if m.closed.Load() {
 		return emptyResult, broker.ErrUnavailable
 	}
 
-	msg, err := m.msgStore.GetMessage(ctx, subject, id)
+	msg, err := m.msgStore.GetMessage(ctx, namespace.Qualify(namespace.FromContext(ctx), subject), id)
 
 	if err == store.ErrInvalidId {
 		return emptyResult, broker.ErrInvalidID
@@ -88,3 +303,117 @@ func (m *Module) Fetch(ctx context.Context, subject string, id int) (broker.Mess
 
 	return *msg, nil
 }
+
+func (m *Module) FetchByKey(ctx context.Context, subject string, key string) (broker.Message, error) {
+	var emptyResult broker.Message
+	if m.closed.Load() {
+		return emptyResult, broker.ErrUnavailable
+	}
+
+	msg, err := m.msgStore.GetMessageByKey(ctx, namespace.Qualify(namespace.FromContext(ctx), subject), key)
+
+	if err == store.ErrKeyNotFound {
+		return emptyResult, broker.ErrKeyNotFound
+	}
+
+	if err == store.ErrExpired {
+		return emptyResult, broker.ErrExpiredID
+	}
+
+	if err != nil {
+		return emptyResult, fmt.Errorf("unexpected error while getting message by key: %w", err)
+	}
+
+	return *msg, nil
+}
+
+func (m *Module) Request(ctx context.Context, subject string, msg broker.Message) (broker.Message, error) {
+	var emptyResult broker.Message
+	if m.closed.Load() {
+		return emptyResult, broker.ErrUnavailable
+	}
+
+	inbox, err := newInbox()
+	if err != nil {
+		return emptyResult, fmt.Errorf("unexpected error while creating inbox: %w", err)
+	}
+
+	// the inbox is subscribed before publishing, so that a quick reply is not missed
+	subscribeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	replies, err := m.Subscribe(subscribeCtx, inbox)
+	if err != nil {
+		return emptyResult, err
+	}
+
+	headers := make(map[string]string, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[broker.HeaderReplyTo] = inbox
+	msg.Headers = headers
+
+	if _, err := m.Publish(ctx, subject, msg); err != nil {
+		return emptyResult, err
+	}
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return emptyResult, broker.ErrNoReply
+		}
+		return emptyResult, ctx.Err()
+	}
+}
+
+// newInbox returns a random subject a single request waits for its reply on
+func newInbox() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return broker.InboxPrefix + hex.EncodeToString(b), nil
+}
+
+// deadLetter publishes a message that could not be delivered to the
+// dead-letter subject of qualified in the same namespace, unless
+// qualified is a dead-letter subject
+func (m *Module) deadLetter(qualified string, msg *broker.Message, reason string) {
+	ns, subject := namespace.Split(qualified)
+	ctx := namespace.WithNamespace(context.Background(), ns)
+	cfg := m.config.DeadLetter
+	if !cfg.Enabled || strings.HasSuffix(subject, cfg.Suffix) {
+		return
+	}
+
+	headers := make(map[string]string, len(msg.Headers)+4)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[broker.HeaderDeadLetterReason] = reason
+	headers[broker.HeaderOriginalSubject] = subject
+	headers[broker.HeaderOriginalId] = strconv.Itoa(msg.Id)
+	headers[broker.HeaderDeadLetteredAt] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	deadLetterSubject := subject + cfg.Suffix
+	id, err := m.Publish(ctx, deadLetterSubject, broker.Message{
+		Body:       msg.Body,
+		Expiration: cfg.Expiration,
+		Headers:    headers,
+		Key:        msg.Key,
+		Priority:   msg.Priority,
+	})
+	if err != nil {
+		m.logger.ErrorContext(ctx, "could not dead-letter message",
+			logging.Subject(qualified), logging.MessageId(msg.Id), logging.Error(err))
+		return
+	}
+
+	m.logger.WarnContext(ctx, "message dead-lettered",
+		logging.Subject(qualified), logging.MessageId(msg.Id),
+		slog.String("dead_letter_subject", deadLetterSubject), slog.Int("dead_letter_id", id),
+		slog.String("reason", reason))
+}
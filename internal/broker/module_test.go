@@ -2,9 +2,15 @@ package broker
 
 import (
 	"context"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"github.com/MeysamBavi/go-broker/internal/schema"
+	"github.com/MeysamBavi/go-broker/internal/store"
 	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/metrics"
 	"github.com/stretchr/testify/assert"
 	"math/rand"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -112,6 +118,46 @@ func TestPublishShouldPreserveOrder(t *testing.T) {
 	}
 }
 
+func TestConcurrentPublishShouldDeliverInOrderOfIds(t *testing.T) {
+	service = NewModule()
+	publishers, perPublisher, subscribers := 16, 200, 8
+	ctx, cancel := context.WithCancel(mainCtx)
+	defer cancel()
+
+	var received sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		sub, err := service.Subscribe(ctx, "ali")
+		assert.Nil(t, err)
+		received.Add(1)
+		go func() {
+			defer received.Done()
+			last, outOfOrder := 0, 0
+			for n := 0; n < publishers*perPublisher; n++ {
+				msg := <-sub
+				if msg.Id < last {
+					outOfOrder++
+				}
+				last = msg.Id
+			}
+			assert.Zero(t, outOfOrder, "out of order deliveries")
+		}()
+	}
+
+	var published sync.WaitGroup
+	for i := 0; i < publishers; i++ {
+		published.Add(1)
+		go func() {
+			defer published.Done()
+			for n := 0; n < perPublisher; n++ {
+				_, err := service.Publish(mainCtx, "ali", createMessage())
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	published.Wait()
+	received.Wait()
+}
+
 func TestPublishShouldNotSendToOtherSubscriptions(t *testing.T) {
 	service = NewModule()
 	msg := createMessage()
@@ -322,6 +368,32 @@ func TestDataRace(t *testing.T) {
 	wg.Wait()
 }
 
+func TestDroppedMessageShouldBeDeadLettered(t *testing.T) {
+	service = NewModule()
+	_, _ = service.Subscribe(mainCtx, "ali")
+	deadLetters, _ := service.Subscribe(mainCtx, "ali.dlq")
+
+	for i := 0; i < subscribeChannelBuffer; i++ {
+		_, err := service.Publish(mainCtx, "ali", createMessage())
+		assert.Nil(t, err)
+	}
+	msg := createMessage()
+	msg.Headers = map[string]string{"trace": "abc"}
+	id, err := service.Publish(mainCtx, "ali", msg)
+	assert.Nil(t, err)
+
+	select {
+	case in := <-deadLetters:
+		assert.Equal(t, msg.Body, in.Body)
+		assert.Equal(t, "abc", in.Headers["trace"])
+		assert.Equal(t, broker.ReasonSubscriberTimeout, in.Headers[broker.HeaderDeadLetterReason])
+		assert.Equal(t, "ali", in.Headers[broker.HeaderOriginalSubject])
+		assert.Equal(t, strconv.Itoa(id), in.Headers[broker.HeaderOriginalId])
+	case <-time.After(5 * time.Second):
+		t.Fatal("dropped message was not dead-lettered")
+	}
+}
+
 func BenchmarkPublish(b *testing.B) {
 	service = NewModule()
 	b.ResetTimer()
@@ -372,3 +444,114 @@ func assertMessagesEqual(t *testing.T, want broker.Message, got broker.Message)
 	assert.Equal(t, want.Body, got.Body)
 	assert.Equal(t, want.Expiration, got.Expiration)
 }
+
+func TestSubscribeWithFilterShouldOnlySendSelectedMessages(t *testing.T) {
+	service = NewModule()
+	ctx, cancel := context.WithCancel(mainCtx)
+	defer cancel()
+
+	sub, err := service.SubscribeWithFilter(ctx, "ali", broker.Filter{
+		Headers:    map[string]string{"type": "created"},
+		Expression: `id > 1`,
+	})
+	assert.Nil(t, err)
+
+	_, _ = service.Publish(mainCtx, "ali", broker.Message{Body: "first", Headers: map[string]string{"type": "created"}})
+	_, _ = service.Publish(mainCtx, "ali", broker.Message{Body: "second", Headers: map[string]string{"type": "deleted"}})
+	_, _ = service.Publish(mainCtx, "ali", broker.Message{Body: "third", Headers: map[string]string{"type": "created"}})
+
+	msg := <-sub
+	assert.Equal(t, "third", msg.Body)
+	select {
+	case msg := <-sub:
+		assert.Fail(t, "unexpected message", msg.Body)
+	default:
+	}
+}
+
+func TestSubscribeWithInvalidFilterShouldFail(t *testing.T) {
+	service = NewModule()
+
+	_, err := service.SubscribeWithFilter(mainCtx, "ali", broker.Filter{Expression: "body =="})
+	assert.ErrorIs(t, err, broker.ErrInvalidFilter)
+}
+
+func TestNamespacesShouldBeIsolated(t *testing.T) {
+	service = NewModule()
+	teamA := namespace.WithNamespace(mainCtx, "team-a")
+	teamB := namespace.WithNamespace(mainCtx, "team-b")
+
+	subA, _ := service.Subscribe(teamA, "ali")
+	subB, _ := service.Subscribe(teamB, "ali")
+
+	msgA := createMessageWithExpire(time.Minute)
+	idA, err := service.Publish(teamA, "ali", msgA)
+	assert.Nil(t, err)
+	msgB := createMessageWithExpire(time.Minute)
+	idB, err := service.Publish(teamB, "ali", msgB)
+	assert.Nil(t, err)
+
+	// every namespace has its own sequence
+	assert.Equal(t, 1, idA)
+	assert.Equal(t, 1, idB)
+
+	assertMessagesEqual(t, msgA, <-subA)
+	assertMessagesEqual(t, msgB, <-subB)
+	select {
+	case <-subA:
+		assert.Fail(t, "message of another namespace received")
+	case <-subB:
+		assert.Fail(t, "message of another namespace received")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fetched, err := service.Fetch(teamA, "ali", 1)
+	assert.Nil(t, err)
+	assertMessagesEqual(t, msgA, fetched)
+
+	_, err = service.Fetch(mainCtx, "ali", 1)
+	assert.Equal(t, broker.ErrInvalidID, err)
+}
+
+func TestPurgeNamespaceShouldDeleteEverythingOfNamespace(t *testing.T) {
+	logger := logging.NewNopLogger()
+	schemas, err := schema.NewRegistry(mainCtx, store.NewInMemorySchemaStore())
+	assert.Nil(t, err)
+	for _, subject := range []string{"team-a/users", "users"} {
+		_, err = schemas.Register(mainCtx, schema.Schema{Subject: subject, Format: schema.JSONSchema, Definition: []byte(`{"type": "object"}`)})
+		assert.Nil(t, err)
+	}
+	schedule := store.NewInMemorySchedule()
+	module, err := NewModuleWithStores(Config{}, nil, schemas, store.NewInMemoryMessage(store.GetDefaultTimeProvider()),
+		store.NewInMemorySubscriber(logger), schedule, metrics.NewEmptyHandler(), logger)
+	assert.Nil(t, err)
+	defer module.Close()
+
+	teamA := namespace.WithNamespace(mainCtx, "team-a")
+	_, err = module.Publish(teamA, "users", broker.Message{Body: `{}`, Expiration: time.Minute})
+	assert.Nil(t, err)
+	_, err = module.Publish(teamA, "orders", broker.Message{Body: "later", Expiration: time.Minute, DeliverAt: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	_, err = module.Publish(mainCtx, "users", broker.Message{Body: `{}`, Expiration: time.Minute})
+	assert.Nil(t, err)
+
+	purged, err := module.PurgeNamespace(mainCtx, "team-a")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, purged)
+
+	pending, err := schedule.Pending(mainCtx)
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+	module.scheduler.lock.Lock()
+	assert.Empty(t, module.scheduler.queue)
+	module.scheduler.lock.Unlock()
+	assert.Equal(t, []string{"users"}, schemas.Subjects())
+
+	// ids start over, and bodies are not validated anymore
+	id, err := module.Publish(teamA, "users", broker.Message{Body: "not json", Expiration: time.Minute})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, id)
+
+	_, err = module.Fetch(mainCtx, "users", 1)
+	assert.Nil(t, err)
+}
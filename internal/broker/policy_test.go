@@ -0,0 +1,44 @@
+package broker
+
+import (
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPolicyShouldApplyDefaultTTLAndRetention(t *testing.T) {
+	logger := logging.NewNopLogger()
+	policies, err := policy.NewRegistry([]policy.Policy{
+		{Subject: "orders.>", DefaultTTL: time.Minute, MaxMessages: 2},
+	})
+	assert.Nil(t, err)
+	module, err := NewModuleWithStores(Config{}, policies, nil, store.NewInMemoryMessage(store.GetDefaultTimeProvider()),
+		store.NewInMemorySubscriber(logger), store.NewInMemorySchedule(), metrics.NewEmptyHandler(), logger)
+	assert.Nil(t, err)
+	defer module.Close()
+
+	var ids []int
+	for i := 0; i < 5; i++ {
+		id, err := module.Publish(mainCtx, "orders.new", createMessage())
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids[:3] {
+		_, err := module.Fetch(mainCtx, "orders.new", id)
+		assert.Equal(t, broker.ErrExpiredID, err)
+	}
+	for _, id := range ids[3:] {
+		msg, err := module.Fetch(mainCtx, "orders.new", id)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Minute, msg.Expiration)
+	}
+
+	_, err = module.Fetch(mainCtx, "payments", 1)
+	assert.Equal(t, broker.ErrInvalidID, err)
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"container/heap"
+	"context"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"sync"
+)
+
+type queuedMessage struct {
+	message broker.Message
+	// seq keeps the messages of the same priority in the order they were pushed
+	seq uint64
+}
+
+// priorityQueue is a max-heap of messages ordered by their priority
+type priorityQueue []queuedMessage
+
+func (q priorityQueue) Len() int {
+	return len(q)
+}
+
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].message.Priority != q[j].message.Priority {
+		return q[i].message.Priority > q[j].message.Priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q priorityQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *priorityQueue) Push(x any) {
+	*q = append(*q, x.(queuedMessage))
+}
+
+func (q *priorityQueue) Pop() any {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[:n-1]
+	return x
+}
+
+// subscriberQueue buffers up to capacity messages of a subscriber, and
+// hands them to out highest priority first, in the order they were
+// pushed otherwise; priorities only matter once the subscriber lags behind
+type subscriberQueue struct {
+	lock  sync.Mutex
+	queue priorityQueue
+	seq   uint64
+	// slots holds a token for every buffered message, including
+	// the one waiting to be taken from out
+	slots chan struct{}
+	ready chan struct{}
+	out   chan broker.Message
+}
+
+// newSubscriberQueue returns a queue handing messages
+// to its out channel until ctx is done
+func newSubscriberQueue(ctx context.Context, capacity int) *subscriberQueue {
+	q := &subscriberQueue{
+		slots: make(chan struct{}, capacity),
+		ready: make(chan struct{}, 1),
+		out:   make(chan broker.Message),
+	}
+	go q.run(ctx)
+
+	return q
+}
+
+// push waits for room in the queue, and returns the error of
+// publishCtx if it's done first; messages pushed after the
+// subscriber is gone are discarded
+func (q *subscriberQueue) push(ctx context.Context, publishCtx context.Context, message broker.Message) error {
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil
+	case <-publishCtx.Done():
+		return publishCtx.Err()
+	}
+
+	q.lock.Lock()
+	heap.Push(&q.queue, queuedMessage{message: message, seq: q.seq})
+	q.seq++
+	q.lock.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (q *subscriberQueue) run(ctx context.Context) {
+	for {
+		q.lock.Lock()
+		if q.queue.Len() == 0 {
+			q.lock.Unlock()
+			select {
+			case <-q.ready:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		next := heap.Pop(&q.queue).(queuedMessage)
+		q.lock.Unlock()
+
+		select {
+		case q.out <- next.message:
+			<-q.slots
+		case <-ctx.Done():
+			return
+		}
+	}
+}
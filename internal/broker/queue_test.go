@@ -0,0 +1,51 @@
+package broker
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSubscriberQueueShouldHandOutHigherPrioritiesFirst(t *testing.T) {
+	ctx, cancel := context.WithCancel(mainCtx)
+	defer cancel()
+	queue := newSubscriberQueue(ctx, 10)
+
+	// the first message is already waiting to be taken when the others are pushed
+	assert.Nil(t, queue.push(ctx, mainCtx, broker.Message{Id: 1}))
+	assert.Eventually(t, func() bool {
+		queue.lock.Lock()
+		defer queue.lock.Unlock()
+		return queue.queue.Len() == 0
+	}, time.Second, time.Millisecond)
+
+	for id, priority := range []int{0, 5, 1, 5, 0} {
+		err := queue.push(ctx, mainCtx, broker.Message{Id: id + 2, Priority: priority})
+		assert.Nil(t, err)
+	}
+
+	var ids []int
+	for i := 0; i < 6; i++ {
+		ids = append(ids, (<-queue.out).Id)
+	}
+	assert.Equal(t, []int{1, 3, 5, 4, 2, 6}, ids)
+}
+
+func TestSubscriberQueuePushShouldFailWhenFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(mainCtx)
+	defer cancel()
+	queue := newSubscriberQueue(ctx, 2)
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, queue.push(ctx, mainCtx, broker.Message{}))
+	}
+
+	publishCtx, cancelPublish := context.WithTimeout(mainCtx, 10*time.Millisecond)
+	defer cancelPublish()
+	assert.Equal(t, context.DeadlineExceeded, queue.push(ctx, publishCtx, broker.Message{}))
+
+	cancel()
+	assert.Nil(t, queue.push(ctx, mainCtx, broker.Message{}))
+}
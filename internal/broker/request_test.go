@@ -0,0 +1,84 @@
+package broker
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestShouldReturnReply(t *testing.T) {
+	service := NewModule()
+	ctx, cancel := context.WithTimeout(mainCtx, time.Second)
+	defer cancel()
+
+	requests, err := service.Subscribe(ctx, "echo")
+	assert.Nil(t, err)
+	go func() {
+		request := <-requests
+		_, _ = service.Publish(ctx, request.Headers[broker.HeaderReplyTo], broker.Message{Body: "re: " + request.Body})
+	}()
+
+	reply, err := service.Request(ctx, "echo", broker.Message{Body: "hello", Headers: map[string]string{"trace": "1"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "re: hello", reply.Body)
+}
+
+func TestRequestShouldSetReplyToHeader(t *testing.T) {
+	service := NewModule()
+	ctx, cancel := context.WithTimeout(mainCtx, 50*time.Millisecond)
+	defer cancel()
+
+	requests, err := service.Subscribe(ctx, "echo")
+	assert.Nil(t, err)
+	received := make(chan broker.Message, 1)
+	go func() {
+		received <- <-requests
+	}()
+
+	headers := map[string]string{"trace": "1"}
+	_, err = service.Request(ctx, "echo", broker.Message{Body: "hello", Headers: headers})
+	assert.Equal(t, broker.ErrNoReply, err)
+
+	request := <-received
+	assert.True(t, strings.HasPrefix(request.Headers[broker.HeaderReplyTo], broker.InboxPrefix))
+	assert.Equal(t, "1", request.Headers["trace"])
+	assert.NotContains(t, headers, broker.HeaderReplyTo)
+}
+
+func TestRequestShouldReturnContextErrorOnCancel(t *testing.T) {
+	service := NewModule()
+	ctx, cancel := context.WithCancel(mainCtx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := service.Request(ctx, "echo", broker.Message{Body: "hello"})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestRequestShouldNotLeaveInboxBehind(t *testing.T) {
+	service := newModule(realClock{})
+	ctx, cancel := context.WithTimeout(mainCtx, time.Second)
+	defer cancel()
+
+	responderCtx, stopResponder := context.WithCancel(ctx)
+	requests, err := service.Subscribe(responderCtx, "echo")
+	assert.Nil(t, err)
+	go func() {
+		request := <-requests
+		_, _ = service.Publish(ctx, request.Headers[broker.HeaderReplyTo], broker.Message{Body: "re: " + request.Body})
+	}()
+
+	reply, err := service.Request(ctx, "echo", broker.Message{Body: "hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, reply.Id)
+	stopResponder()
+
+	subjects, err := service.msgStore.Subjects(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"echo"}, subjects)
+	assert.Eventually(t, func() bool {
+		return len(service.subscribers.Subjects(ctx)) == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,156 @@
+package broker
+
+import (
+	"container/heap"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	"sync"
+	"time"
+)
+
+// clock tells the scheduler the time, so that tests do not have to wait for it
+type clock interface {
+	now() time.Time
+	// at returns a channel receiving once t comes, and a function stopping it
+	at(t time.Time) (<-chan time.Time, func())
+}
+
+type realClock struct{}
+
+func (realClock) now() time.Time {
+	return time.Now()
+}
+
+func (realClock) at(t time.Time) (<-chan time.Time, func()) {
+	timer := time.NewTimer(time.Until(t))
+	return timer.C, func() { timer.Stop() }
+}
+
+// scheduledQueue is a min-heap of messages ordered by their delivery time
+type scheduledQueue []store.ScheduledMessage
+
+func (q scheduledQueue) Len() int {
+	return len(q)
+}
+
+func (q scheduledQueue) Less(i, j int) bool {
+	return q[i].Message.DeliverAt.Before(q[j].Message.DeliverAt)
+}
+
+func (q scheduledQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *scheduledQueue) Push(x any) {
+	*q = append(*q, x.(store.ScheduledMessage))
+}
+
+func (q *scheduledQueue) Pop() any {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[:n-1]
+	return x
+}
+
+// scheduler holds the messages until their delivery time
+// and hands them to deliver, one at a time, in that order
+type scheduler struct {
+	lock    sync.Mutex
+	queue   scheduledQueue
+	clock   clock
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	deliver func(store.ScheduledMessage)
+}
+
+func newScheduler(c clock, deliver func(store.ScheduledMessage)) *scheduler {
+	s := &scheduler{
+		clock:   c,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		deliver: deliver,
+	}
+	go s.run()
+
+	return s
+}
+
+func (s *scheduler) add(scheduled store.ScheduledMessage) {
+	s.lock.Lock()
+	heap.Push(&s.queue, scheduled)
+	s.lock.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove drops the messages selected by f, which are never delivered
+func (s *scheduler) remove(f func(store.ScheduledMessage) bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	kept := s.queue[:0]
+	for _, scheduled := range s.queue {
+		if !f(scheduled) {
+			kept = append(kept, scheduled)
+		}
+	}
+	clear(s.queue[len(kept):])
+	s.queue = kept
+	heap.Init(&s.queue)
+}
+
+// next returns the first message if it's due, or its delivery time
+func (s *scheduler) next() (store.ScheduledMessage, time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.queue) == 0 {
+		return store.ScheduledMessage{}, time.Time{}, false
+	}
+
+	deliverAt := s.queue[0].Message.DeliverAt
+	if deliverAt.After(s.clock.now()) {
+		return store.ScheduledMessage{}, deliverAt, false
+	}
+
+	return heap.Pop(&s.queue).(store.ScheduledMessage), time.Time{}, true
+}
+
+func (s *scheduler) run() {
+	defer close(s.done)
+
+	for {
+		scheduled, deliverAt, due := s.next()
+		if due {
+			s.deliver(scheduled)
+			continue
+		}
+
+		var fire <-chan time.Time
+		stopTimer := func() {}
+		if !deliverAt.IsZero() {
+			fire, stopTimer = s.clock.at(deliverAt)
+		}
+
+		select {
+		case <-fire:
+		case <-s.wake:
+		case <-s.stop:
+			stopTimer()
+			return
+		}
+		stopTimer()
+	}
+}
+
+// close stops the scheduler; the undelivered messages are kept
+// by the Schedule store and are loaded again on the next start
+func (s *scheduler) close() {
+	close(s.stop)
+	<-s.done
+}
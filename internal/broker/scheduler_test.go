@@ -0,0 +1,168 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when it's advanced
+type fakeClock struct {
+	lock    sync.Mutex
+	current time.Time
+	timers  []fakeTimer
+}
+
+type fakeTimer struct {
+	at   time.Time
+	fire chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{current: time.Now()}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.current
+}
+
+func (c *fakeClock) at(t time.Time) (<-chan time.Time, func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fire := make(chan time.Time, 1)
+	if !t.After(c.current) {
+		fire <- c.current
+		return fire, func() {}
+	}
+	c.timers = append(c.timers, fakeTimer{at: t, fire: fire})
+	return fire, func() {}
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.current = c.current.Add(d)
+	waiting := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.current) {
+			waiting = append(waiting, timer)
+			continue
+		}
+		timer.fire <- c.current
+	}
+	c.timers = waiting
+}
+
+func TestScheduledMessagesShouldBeDeliveredInTime(t *testing.T) {
+	clock := newFakeClock()
+	module := newModule(clock)
+	defer module.Close()
+	sub, _ := module.Subscribe(mainCtx, "ali")
+
+	late := createMessage()
+	late.DeliverAt = clock.now().Add(200 * time.Millisecond)
+	early := createMessage()
+	early.DeliverAt = clock.now().Add(100 * time.Millisecond)
+	now := createMessage()
+
+	_, err := module.Publish(mainCtx, "ali", late)
+	assert.Nil(t, err)
+	_, err = module.Publish(mainCtx, "ali", early)
+	assert.Nil(t, err)
+	_, err = module.Publish(mainCtx, "ali", now)
+	assert.Nil(t, err)
+
+	assertMessagesEqual(t, now, <-sub)
+	select {
+	case <-sub:
+		t.Fatal("scheduled message was delivered before its time")
+	default:
+	}
+
+	clock.advance(100 * time.Millisecond)
+	assertMessagesEqual(t, early, <-sub)
+	clock.advance(100 * time.Millisecond)
+	assertMessagesEqual(t, late, <-sub)
+}
+
+func TestScheduledMessageShouldBeFetchableBeforeDelivery(t *testing.T) {
+	service = NewModule()
+	msg := createMessageWithExpire(time.Minute)
+	msg.DeliverAt = time.Now().Add(time.Hour)
+
+	id, err := service.Publish(mainCtx, "ali", msg)
+	assert.Nil(t, err)
+
+	fetched, err := service.Fetch(mainCtx, "ali", id)
+	assert.Nil(t, err)
+	assertMessagesEqual(t, msg, fetched)
+}
+
+func TestPendingScheduledMessagesShouldBeResumed(t *testing.T) {
+	logger := logging.NewNopLogger()
+	schedule := store.NewInMemorySchedule()
+	msg := createMessage()
+	msg.Id = 1
+	msg.DeliverAt = time.Now().Add(-time.Millisecond)
+	assert.Nil(t, schedule.Add(mainCtx, "ali", &msg))
+
+	module, err := NewModuleWithStores(Config{}, nil, nil, store.NewInMemoryMessage(store.GetDefaultTimeProvider()), store.NewInMemorySubscriber(logger), schedule, metrics.NewEmptyHandler(), logger)
+	assert.Nil(t, err)
+	defer module.Close()
+
+	sub, _ := module.Subscribe(mainCtx, "ali")
+	select {
+	case in := <-sub:
+		assertMessagesEqual(t, msg, in)
+	case <-time.After(time.Second):
+		t.Fatal("scheduled message was not delivered")
+	}
+
+	assert.Eventually(t, func() bool {
+		pending, _ := schedule.Pending(mainCtx)
+		return len(pending) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+type failingSchedule struct {
+	store.Schedule
+}
+
+func (failingSchedule) Add(context.Context, string, *broker.Message) error {
+	return errors.New("schedule is down")
+}
+
+func TestScheduledMessageShouldBeDeliveredIfNotPersisted(t *testing.T) {
+	logger := logging.NewNopLogger()
+	module, err := NewModuleWithStores(Config{}, nil, nil, store.NewInMemoryMessage(store.GetDefaultTimeProvider()), store.NewInMemorySubscriber(logger), failingSchedule{store.NewInMemorySchedule()}, metrics.NewEmptyHandler(), logger)
+	assert.Nil(t, err)
+	defer module.Close()
+
+	sub, _ := module.Subscribe(mainCtx, "ali")
+	msg := createMessageWithExpire(time.Minute)
+	msg.DeliverAt = time.Now().Add(10 * time.Millisecond)
+	id, err := module.Publish(mainCtx, "ali", msg)
+	assert.Nil(t, err)
+
+	fetched, err := module.Fetch(mainCtx, "ali", id)
+	assert.Nil(t, err)
+	assertMessagesEqual(t, msg, fetched)
+	select {
+	case in := <-sub:
+		assertMessagesEqual(t, msg, in)
+	case <-time.After(time.Second):
+		t.Fatal("scheduled message was not delivered")
+	}
+}
@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"errors"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/schema"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPublishShouldValidateBodiesAgainstSchema(t *testing.T) {
+	logger := logging.NewNopLogger()
+	schemas, err := schema.NewRegistry(mainCtx, store.NewInMemorySchemaStore())
+	assert.Nil(t, err)
+	_, err = schemas.Register(mainCtx, schema.Schema{Subject: "users", Format: schema.JSONSchema, Definition: []byte(`{"type": "object", "required": ["name"]}`)})
+	assert.Nil(t, err)
+	module, err := NewModuleWithStores(Config{}, nil, schemas, store.NewInMemoryMessage(store.GetDefaultTimeProvider()),
+		store.NewInMemorySubscriber(logger), store.NewInMemorySchedule(), metrics.NewEmptyHandler(), logger)
+	assert.Nil(t, err)
+	defer module.Close()
+
+	id, err := module.Publish(mainCtx, "users", broker.Message{Body: `{"name": "ali"}`, Expiration: time.Minute})
+	assert.Nil(t, err)
+	msg, err := module.Fetch(mainCtx, "users", id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, msg.SchemaVersion)
+
+	_, err = module.Publish(mainCtx, "users", broker.Message{Body: `{"age": 30}`})
+	assert.True(t, errors.Is(err, broker.ErrSchemaViolation))
+
+	_, err = module.Publish(mainCtx, "users", broker.Message{Body: `{"name": "ali"}`, SchemaVersion: 2})
+	assert.Equal(t, broker.ErrUnknownSchemaVersion, err)
+
+	_, err = module.Publish(mainCtx, "payments", broker.Message{Body: "no schema"})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,155 @@
+package broker
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"sort"
+	"sync"
+)
+
+// sequencer hands the published messages of every subject to deliver
+// in the order of their ids, even though the concurrent publishes of a
+// subject may get their ids from the store in one order and finish
+// saving the messages in another.
+//
+// Every publish takes a ticket before saving its message. A message
+// saved with id X is held until every publish that took its ticket
+// before X was saved is finished, as only those can still get an id
+// lower than X; the held messages are then delivered in the order of
+// their ids, one subject at a time, so deliver has to hand them over
+// without waiting for the subscribers to take them.
+type sequencer struct {
+	lock      sync.Mutex
+	sequences map[string]*sequence
+	deliver   func(ctx context.Context, subject string, msg *broker.Message)
+}
+
+type sequence struct {
+	// issued is the number of tickets taken so far
+	issued uint64
+	// low is the lowest ticket not finished yet
+	low uint64
+	// finished holds the finished tickets beyond low
+	finished map[uint64]bool
+	// held is sorted by the id of the messages
+	held       []*heldMessage
+	delivering bool
+}
+
+type heldMessage struct {
+	ctx context.Context
+	msg *broker.Message
+	// barrier is the number of tickets taken before msg was saved
+	barrier   uint64
+	delivered chan struct{}
+}
+
+func newSequencer(deliver func(ctx context.Context, subject string, msg *broker.Message)) *sequencer {
+	return &sequencer{
+		sequences: make(map[string]*sequence),
+		deliver:   deliver,
+	}
+}
+
+// begin returns the ticket of a publish on subject,
+// which must be finished by either skip or publish
+func (s *sequencer) begin(subject string) uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	seq, ok := s.sequences[subject]
+	if !ok {
+		seq = &sequence{finished: make(map[uint64]bool)}
+		s.sequences[subject] = seq
+	}
+	ticket := seq.issued
+	seq.issued++
+
+	return ticket
+}
+
+// skip finishes ticket without delivering a message,
+// e.g. when the message could not be saved or is scheduled
+func (s *sequencer) skip(subject string, ticket uint64) {
+	s.lock.Lock()
+	s.sequences[subject].finish(ticket)
+	s.lock.Unlock()
+
+	s.drain(subject)
+}
+
+// publish finishes ticket, and returns once msg is delivered
+func (s *sequencer) publish(ctx context.Context, subject string, ticket uint64, msg *broker.Message) {
+	held := &heldMessage{
+		ctx:       ctx,
+		msg:       msg,
+		delivered: make(chan struct{}),
+	}
+
+	s.lock.Lock()
+	seq := s.sequences[subject]
+	held.barrier = seq.issued
+	i := sort.Search(len(seq.held), func(i int) bool {
+		return seq.held[i].msg.Id > msg.Id
+	})
+	seq.held = append(seq.held, nil)
+	copy(seq.held[i+1:], seq.held[i:])
+	seq.held[i] = held
+	seq.finish(ticket)
+	s.lock.Unlock()
+
+	s.drain(subject)
+	<-held.delivered
+}
+
+func (seq *sequence) finish(ticket uint64) {
+	seq.finished[ticket] = true
+	for seq.finished[seq.low] {
+		delete(seq.finished, seq.low)
+		seq.low++
+	}
+}
+
+// ready removes and returns the held messages that can be delivered
+func (seq *sequence) ready() []*heldMessage {
+	n := 0
+	for n < len(seq.held) && seq.held[n].barrier <= seq.low {
+		n++
+	}
+	ready := seq.held[:n:n]
+	seq.held = seq.held[n:]
+
+	return ready
+}
+
+// drain delivers the messages of subject that are ready, unless another
+// call is already delivering them; that call picks up the new ones too
+func (s *sequencer) drain(subject string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	seq, ok := s.sequences[subject]
+	if !ok {
+		// another call delivered everything, and removed the idle sequence
+		return
+	}
+	for !seq.delivering {
+		ready := seq.ready()
+		if len(ready) == 0 {
+			break
+		}
+
+		seq.delivering = true
+		s.lock.Unlock()
+		for _, held := range ready {
+			s.deliver(held.ctx, subject, held.msg)
+			close(held.delivered)
+		}
+		s.lock.Lock()
+		seq.delivering = false
+	}
+
+	if !seq.delivering && seq.low == seq.issued && len(seq.held) == 0 {
+		delete(s.sequences, subject)
+	}
+}
@@ -0,0 +1,89 @@
+package broker
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRecordingSequencer() (*sequencer, func() []int) {
+	var lock sync.Mutex
+	var delivered []int
+	s := newSequencer(func(_ context.Context, _ string, msg *broker.Message) {
+		lock.Lock()
+		defer lock.Unlock()
+		delivered = append(delivered, msg.Id)
+	})
+
+	return s, func() []int {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]int(nil), delivered...)
+	}
+}
+
+// waitHeld waits until subject has n messages held by s
+func waitHeld(t *testing.T, s *sequencer, subject string, n int) {
+	assert.Eventually(t, func() bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		seq, ok := s.sequences[subject]
+		return ok && len(seq.held) == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestSequencerShouldHoldMessageUntilEarlierPublishesFinish(t *testing.T) {
+	s, delivered := newRecordingSequencer()
+	first := s.begin("ali")
+	second := s.begin("ali")
+
+	done := make(chan struct{})
+	go func() {
+		s.publish(mainCtx, "ali", second, &broker.Message{Id: 2})
+		close(done)
+	}()
+	waitHeld(t, s, "ali", 1)
+	assert.Empty(t, delivered())
+
+	s.publish(mainCtx, "ali", first, &broker.Message{Id: 1})
+	<-done
+	assert.Equal(t, []int{1, 2}, delivered())
+	assert.Empty(t, s.sequences)
+}
+
+func TestSequencerShouldNotWaitForSkippedPublishes(t *testing.T) {
+	s, delivered := newRecordingSequencer()
+	failed := s.begin("ali")
+	published := s.begin("ali")
+
+	done := make(chan struct{})
+	go func() {
+		s.publish(mainCtx, "ali", published, &broker.Message{Id: 1})
+		close(done)
+	}()
+	s.skip("ali", failed)
+	<-done
+	assert.Equal(t, []int{1}, delivered())
+}
+
+func TestSequencerShouldDeliverInOrderOfIds(t *testing.T) {
+	s, delivered := newRecordingSequencer()
+	first := s.begin("ali")
+	second := s.begin("ali")
+
+	// the second publish gets the lower id, but the first one saves its message sooner
+	done := make(chan struct{})
+	go func() {
+		s.publish(mainCtx, "ali", first, &broker.Message{Id: 2})
+		close(done)
+	}()
+	waitHeld(t, s, "ali", 1)
+	assert.Empty(t, delivered())
+
+	s.publish(mainCtx, "ali", second, &broker.Message{Id: 1})
+	<-done
+	assert.Equal(t, []int{1, 2}, delivered())
+}
@@ -57,6 +57,19 @@ func (w *withTracing) Subscribe(ctx context.Context, subject string) (<-chan bro
 	return ch, err
 }
 
+func (w *withTracing) SubscribeWithFilter(ctx context.Context, subject string, filter broker.Filter) (<-chan broker.Message, error) {
+	ctx, span := w.tracer().Start(ctx, "SubscribeWithFilter")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+
+	ch, err := w.core.SubscribeWithFilter(ctx, subject, filter)
+
+	tracing.SetStatusAndError(span, err)
+
+	return ch, err
+}
+
 func (w *withTracing) Fetch(ctx context.Context, subject string, id int) (broker.Message, error) {
 	ctx, span := w.tracer().Start(ctx, "Fetch")
 	defer span.End()
@@ -70,3 +83,30 @@ func (w *withTracing) Fetch(ctx context.Context, subject string, id int) (broker
 
 	return msg, err
 }
+
+func (w *withTracing) FetchByKey(ctx context.Context, subject string, key string) (broker.Message, error) {
+	ctx, span := w.tracer().Start(ctx, "FetchByKey")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+	span.SetAttributes(tracing.MessageKey(key))
+
+	msg, err := w.core.FetchByKey(ctx, subject, key)
+
+	tracing.SetStatusAndError(span, err)
+
+	return msg, err
+}
+
+func (w *withTracing) Request(ctx context.Context, subject string, msg broker.Message) (broker.Message, error) {
+	ctx, span := w.tracer().Start(ctx, "Request")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+
+	reply, err := w.core.Request(ctx, subject, msg)
+
+	tracing.SetStatusAndError(span, err)
+
+	return reply, err
+}
@@ -1,38 +1,55 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
+	"github.com/MeysamBavi/go-broker/api/gateway"
 	pb "github.com/MeysamBavi/go-broker/api/proto"
 	"github.com/MeysamBavi/go-broker/api/server"
+	"github.com/MeysamBavi/go-broker/internal/auth"
 	"github.com/MeysamBavi/go-broker/internal/broker"
 	"github.com/MeysamBavi/go-broker/internal/config"
+	"github.com/MeysamBavi/go-broker/internal/health"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
+	"github.com/MeysamBavi/go-broker/internal/schema"
 	"github.com/MeysamBavi/go-broker/internal/store"
 	"github.com/MeysamBavi/go-broker/internal/store/batch"
 	"github.com/MeysamBavi/go-broker/internal/tracing"
+	_ "github.com/MeysamBavi/go-broker/pkg/compression"
 	"github.com/MeysamBavi/go-broker/pkg/metrics"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"log"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func Execute() {
 	cfg := config.Load()
-	{
-		cfgJson, err := json.MarshalIndent(cfg, "", "  ")
-		if err != nil {
-			log.Fatal(err)
-		}
-		log.Printf("config: %s\n", cfgJson)
+
+	logger := logging.NewLogger(cfg.Logging)
+	logger.Info("config loaded", slog.Any("config", cfg))
+
+	fatal := func(msg string, err error) {
+		logger.Error(msg, logging.Error(err))
+		os.Exit(1)
 	}
 
-	//TODO: configure and use logger
 	lis, err := net.Listen("tcp", cfg.Server.Host)
 	if err != nil {
-		log.Fatal("could not listen: ", err)
+		fatal("could not listen", err)
 	}
 
-	tracerProvider, shutdown := tracing.NewTracerProvider(cfg.Tracing)
+	tracerProvider, shutdown := tracing.NewTracerProvider(cfg.Tracing, logger)
 	defer shutdown()
 
 	var sequenceStore store.Sequence
@@ -41,47 +58,241 @@ func Execute() {
 
 	var batchHandlerProvider func(writer batch.Writer) batch.Handler
 	batchHandlerProvider = func(writer batch.Writer) batch.Handler {
-		return batch.NewHandler(cfg.Store.Batch, writer, tracerProvider)
+		return batch.NewHandler(cfg.Store.Batch, writer, tracerProvider, logger)
+	}
+
+	policies, err := policy.NewRegistry(cfg.Policies, cfg.Store.Backends()...)
+	if err != nil {
+		fatal("invalid subject policies", err)
+	}
+	// the stores keep the subjects qualified by their namespace, while
+	// policies apply to the subjects of every namespace; principals
+	// bound to a namespace can't manage them through the admin server
+	policyOf := func(qualified string) policy.Policy {
+		_, subject := namespace.Split(qualified)
+		p, _ := policies.For(subject)
+		return p
 	}
 
-	var msgStore store.Message
-	switch {
-	case cfg.Store.UseInMemory:
-		msgStore = store.NewInMemoryMessage(store.GetDefaultTimeProvider())
-	case cfg.Store.UseCassandra:
-		msgStore, err = store.NewCassandra(cfg.Store.Cassandra, sequenceStore, batchHandlerProvider, tracerProvider)
+	namespaces, err := namespace.NewRegistry(cfg.Namespaces)
+	if err != nil {
+		fatal("invalid namespaces", err)
+	}
+
+	// every backend gets its own batch handler, and loads
+	// the sequences of its subjects into the shared sequence store
+	backends := make(map[string]store.Message)
+	var persistentBackends []store.Message
+	if cfg.Store.UseInMemory {
+		backends[store.BackendMemory] = store.NewInMemoryMessage(store.GetDefaultTimeProvider())
+	}
+	if cfg.Store.UseCassandra {
+		backends[store.BackendCassandra], err = store.NewCassandra(cfg.Store.Cassandra, sequenceStore, batchHandlerProvider, tracerProvider, logger)
 		if err != nil {
-			log.Fatal("could not connect to cassandra: ", err)
+			fatal("could not connect to cassandra", err)
 		}
-	case cfg.Store.UsePostgres:
-		msgStore, err = store.NewPostgres(cfg.Store.Postgres, sequenceStore, batchHandlerProvider, store.GetDefaultTimeProvider(), tracerProvider)
+		persistentBackends = append(persistentBackends, backends[store.BackendCassandra])
+	}
+	if cfg.Store.UsePostgres {
+		backends[store.BackendPostgres], err = store.NewPostgres(cfg.Store.Postgres, sequenceStore, batchHandlerProvider, store.GetDefaultTimeProvider(), tracerProvider, logger)
 		if err != nil {
-			log.Fatal("could not connect to postgres: ", err)
+			fatal("could not connect to postgres", err)
 		}
+		persistentBackends = append(persistentBackends, backends[store.BackendPostgres])
+	}
+	schemas, err := schema.NewRegistry(context.Background(), store.SchemaStoreOf(persistentBackends...))
+	if err != nil {
+		fatal("could not load schemas", err)
 	}
+
+	// the schedules are found on the persistent backends themselves,
+	// so only the backends in the router are encrypted and compressed;
+	// bodies are compressed before they are encrypted, as ciphertext does not
+	// compress, so the size of an encrypted body tells how well it compressed
+	scheduleStore := store.ScheduleOf(persistentBackends...)
+	if cfg.Store.Encryption.Enabled {
+		keyring, err := store.LoadKeyring(cfg.Store.Encryption.KeyringFile)
+		if err != nil {
+			fatal("could not load encryption keyring", err)
+		}
+		encrypted := func(subject string) bool {
+			return policyOf(subject).Encrypted
+		}
+		for _, name := range []string{store.BackendCassandra, store.BackendPostgres} {
+			if backend, ok := backends[name]; ok {
+				backends[name] = store.MessageWithEncryption(backend, keyring, encrypted)
+			}
+		}
+		scheduleStore = store.ScheduleWithEncryption(scheduleStore, keyring, encrypted)
+	}
+	if cfg.Store.Compression.Algorithm != "" {
+		for _, name := range []string{store.BackendCassandra, store.BackendPostgres} {
+			if backend, ok := backends[name]; ok {
+				backends[name], err = store.MessageWithCompression(backend, cfg.Store.Compression)
+				if err != nil {
+					fatal("invalid store compression", err)
+				}
+			}
+		}
+	}
+
+	msgStore, err := store.NewRouter(backends, cfg.Store.Backend(), func(subject string) string {
+		return policyOf(subject).Backend
+	})
+	if err != nil {
+		fatal("could not create store router", err)
+	}
+	scheduleStore = store.ScheduleWithTracing(scheduleStore, tracerProvider)
 	msgStore = store.MessageWithTracing(msgStore, tracerProvider)
 
-	subsStore := store.NewInMemorySubscriber()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	healthChecker := health.NewChecker(func(ctx context.Context) error {
+		return store.CheckHealth(ctx, msgStore)
+	}, cfg.Server.HealthCheckInterval, logger, pb.Broker_ServiceDesc.ServiceName)
+	go healthChecker.Run(ctx)
+
+	subsStore := store.NewInMemorySubscriber(logger)
 	subsStore = store.SubscriberWithTracing(subsStore, tracerProvider)
 
 	var metricsHandler metrics.Handler
 	if cfg.Metrics.Enabled {
 		metricsHandler = metrics.NewPrometheusHandler()
-		go metrics.RunServer(cfg.Metrics)
+		go metrics.RunServer(cfg.Metrics, logger, healthChecker.Ready)
 	} else {
 		metricsHandler = metrics.NewEmptyHandler()
 	}
 
-	s := grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor(otelgrpc.WithTracerProvider(tracerProvider))),
-		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor(otelgrpc.WithTracerProvider(tracerProvider))),
-	)
-	module := broker.NewModuleWithStores(msgStore, subsStore)
-	module = broker.WithTracing(module, tracerProvider)
-	pb.RegisterBrokerServer(s, server.NewServer(module, metricsHandler, store.GetDefaultTimeProvider()))
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		otelgrpc.UnaryServerInterceptor(otelgrpc.WithTracerProvider(tracerProvider)),
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		otelgrpc.StreamServerInterceptor(otelgrpc.WithTracerProvider(tracerProvider)),
+	}
 
-	log.Printf("server listening at %v\n", lis.Addr())
+	authorizer := auth.AllowAll()
+	var authenticator auth.Authenticator
+	if cfg.Auth.Enabled {
+		authenticator, err = auth.NewAuthenticator(cfg.Auth)
+		if err != nil {
+			fatal("could not create authenticator", err)
+		}
+		authorizer, err = auth.NewACL(cfg.Auth.ACL)
+		if err != nil {
+			fatal("invalid acl", err)
+		}
+		// probes and tools like grpcurl do not carry tokens
+		publicServices := []string{
+			healthpb.Health_ServiceDesc.ServiceName,
+			reflectionpb.ServerReflection_ServiceDesc.ServiceName,
+		}
+		unaryInterceptors = append(unaryInterceptors, auth.UnaryServerInterceptor(authenticator, publicServices...))
+		streamInterceptors = append(streamInterceptors, auth.StreamServerInterceptor(authenticator, publicServices...))
+	}
+	// namespaces are resolved from the authenticated principals
+	unaryInterceptors = append(unaryInterceptors, namespace.UnaryServerInterceptor(namespaces))
+	streamInterceptors = append(streamInterceptors, namespace.StreamServerInterceptor(namespaces))
+
+	// the quotas of namespaces are enforced even if rate limiting is disabled
+	rateLimit := cfg.RateLimit
+	if !rateLimit.Enabled {
+		rateLimit.PerClient, rateLimit.PerSubject = ratelimit.Limits{}, ratelimit.Limits{}
+	}
+	limiter := ratelimit.NewLimiter(ctx, rateLimit, namespaces.Quotas)
+
+	serverOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	if cfg.Server.TLS.Enabled {
+		creds, err := server.NewTLSCredentials(ctx, cfg.Server.TLS, logger)
+		if err != nil {
+			fatal("could not load tls credentials", err)
+		}
+		serverOptions = append(serverOptions, grpc.Creds(creds))
+	}
+
+	s := grpc.NewServer(serverOptions...)
+	core, err := broker.NewModuleWithStores(cfg.Broker, policies, schemas, msgStore, subsStore, scheduleStore, metricsHandler, logger)
+	if err != nil {
+		fatal("could not create broker module", err)
+	}
+	module := broker.WithTracing(core, tracerProvider)
+	brokerServer := server.NewServer(module, metricsHandler, store.GetDefaultTimeProvider(), logger, authorizer, limiter, cfg.Server.Validation, policies)
+	pb.RegisterBrokerServer(s, brokerServer)
+	pb.RegisterAdminServer(s, server.NewAdminServer(msgStore, subsStore, policies, schemas, namespaces, core, logger, authorizer))
+	healthpb.RegisterHealthServer(s, healthChecker.Server())
+	reflection.Register(s)
+
+	// the gateway carries the same bearer tokens, so it uses the same tls settings
+	var gatewayServer *http.Server
+	if cfg.Server.Gateway.Enabled {
+		gatewayServer = &http.Server{
+			Addr:    cfg.Server.Gateway.Host,
+			Handler: gateway.NewHandler(brokerServer, authenticator, namespaces, tracerProvider, logger),
+		}
+		if cfg.Server.TLS.Enabled {
+			gatewayServer.TLSConfig, err = server.NewTLSConfig(ctx, cfg.Server.TLS, logger)
+			if err != nil {
+				fatal("could not load tls config of http gateway", err)
+			}
+		}
+		go func() {
+			logger.Info("http gateway listening", slog.String("address", cfg.Server.Gateway.Host))
+			var err error
+			if gatewayServer.TLSConfig != nil {
+				err = gatewayServer.ListenAndServeTLS("", "")
+			} else {
+				err = gatewayServer.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				fatal("failed to serve http gateway", err)
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		logger.Info("shutting down")
+		healthChecker.Shutdown()
+		_ = module.Close()
+		if gatewayServer != nil {
+			shutdownGateway(gatewayServer, cfg.Server.ShutdownTimeout)
+		}
+		gracefulStop(s, cfg.Server.ShutdownTimeout)
+	}()
+
+	logger.Info("server listening", slog.String("address", lis.Addr().String()))
 	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+		fatal("failed to serve", err)
+	}
+	logger.Info("server stopped")
+}
+
+// gracefulStop waits for the ongoing calls to finish, at most for timeout.
+// Subscriptions are stopped forcefully, as they never finish on their own.
+func gracefulStop(s *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		s.Stop()
+	}
+}
+
+// shutdownGateway waits for the ongoing http calls to finish, at most
+// for timeout, and closes the ones left, like server-sent event streams
+func shutdownGateway(s *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		_ = s.Close()
 	}
 }
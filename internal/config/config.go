@@ -3,38 +3,88 @@ package config
 import (
 	"fmt"
 	"github.com/MeysamBavi/go-broker/api/server"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"github.com/MeysamBavi/go-broker/internal/broker"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/namespace"
+	"github.com/MeysamBavi/go-broker/internal/policy"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
 	"github.com/MeysamBavi/go-broker/internal/store"
 	"github.com/MeysamBavi/go-broker/internal/store/batch"
 	"github.com/MeysamBavi/go-broker/internal/tracing"
 	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"log/slog"
+	"slices"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	Server  server.Config  `config:"server"`
-	Store   store.Config   `config:"store"`
-	Metrics metrics.Config `config:"metrics"`
-	Tracing tracing.Config `config:"tracing"`
+	Server    server.Config    `config:"server"`
+	Broker    broker.Config    `config:"broker"`
+	Store     store.Config     `config:"store"`
+	Metrics   metrics.Config   `config:"metrics"`
+	Tracing   tracing.Config   `config:"tracing"`
+	Logging   logging.Config   `config:"logging"`
+	Auth      auth.Config      `config:"auth"`
+	RateLimit ratelimit.Config `config:"rate_limit"`
+	// Policies override the global behavior for subject patterns;
+	// the first matching policy applies to a subject
+	Policies []policy.Policy `config:"policies"`
+	// Namespaces are created on startup, in addition to the default one
+	Namespaces []namespace.Namespace `config:"namespaces"`
 }
 
-func (c *Config) Validate() error {
-	stores := map[string]bool{
-		"Store.UseInMemory":  c.Store.UseInMemory,
-		"Store.UseCassandra": c.Store.UseCassandra,
-		"Store.UsePostgres":  c.Store.UsePostgres,
+const redacted = "REDACTED"
+
+// LogValue hides the static tokens and passwords of c, so that it can be logged
+func (c Config) LogValue() slog.Value {
+	tokens := make([]auth.TokenConfig, len(c.Auth.Tokens))
+	for i, token := range c.Auth.Tokens {
+		token.Token = redacted
+		tokens[i] = token
 	}
-	trues := make([]string, 0)
-	for s, use := range stores {
-		if use {
-			trues = append(trues, s)
-		}
+	c.Auth.Tokens = tokens
+	if c.Store.Postgres.Password != "" {
+		c.Store.Postgres.Password = redacted
 	}
-	if len(trues) == 0 {
+
+	return slog.AnyValue(loggedConfig(c))
+}
+
+// loggedConfig has no LogValue method, so that it's logged as it is
+type loggedConfig Config
+
+func (c *Config) Validate() error {
+	backends := c.Store.Backends()
+	if len(backends) == 0 {
 		return fmt.Errorf("no store is selected for use")
 	}
-	if len(trues) > 1 {
-		return fmt.Errorf("multiple stores (%s) are selected for use", strings.Join(trues, ", "))
+	if len(backends) > 1 && c.Store.Default == "" {
+		return fmt.Errorf("multiple stores (%s) are selected for use, but Store.Default is not provided", strings.Join(backends, ", "))
+	}
+	if c.Store.Default != "" && !slices.Contains(backends, c.Store.Default) {
+		return fmt.Errorf("default store %q is not selected for use", c.Store.Default)
+	}
+
+	if c.Server.TLS.Enabled && (c.Server.TLS.CertFile == "" || c.Server.TLS.KeyFile == "") {
+		return fmt.Errorf("tls is enabled but cert_file or key_file is not provided")
+	}
+
+	if c.Store.Encryption.Enabled && c.Store.Encryption.KeyringFile == "" {
+		return fmt.Errorf("store encryption is enabled but keyring_file is not provided")
+	}
+
+	if v := c.Server.Validation; v.MaxTTL > 0 && (v.MinTTL > v.MaxTTL || v.DefaultTTL > v.MaxTTL) {
+		return fmt.Errorf("server validation ttl bounds are inconsistent")
+	}
+
+	if c.Broker.DeadLetter.Enabled && c.Broker.DeadLetter.Suffix == "" {
+		return fmt.Errorf("dead-letter is enabled but no suffix is provided")
+	}
+
+	if err := c.Logging.Validate(); err != nil {
+		return fmt.Errorf("invalid logging config: %w", err)
 	}
 
 	return nil
@@ -44,6 +94,34 @@ func Default() Config {
 	return Config{
 		Server: server.Config{
 			Host: "localhost:50043",
+			TLS: server.TLSConfig{
+				Enabled:        false,
+				ReloadInterval: time.Minute,
+			},
+			Validation: server.ValidationConfig{
+				MaxBodySize:       1 << 20,
+				MaxSubjectLength:  256,
+				SubjectCharset:    "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-",
+				MinTTL:            0,
+				MaxTTL:            0,
+				DefaultTTL:        0,
+				MaxDelay:          0,
+				MaxRequestTimeout: time.Minute,
+			},
+			Gateway: server.GatewayConfig{
+				Enabled: false,
+				Host:    "localhost:8080",
+			},
+			HealthCheckInterval: 5 * time.Second,
+			ShutdownTimeout:     10 * time.Second,
+		},
+		Broker: broker.Config{
+			DeadLetter: broker.DeadLetterConfig{
+				Enabled:    true,
+				Suffix:     ".dlq",
+				Expiration: 24 * time.Hour,
+			},
+			CompactionInterval: time.Minute,
 		},
 		Store: store.Config{
 			UseInMemory:  true,
@@ -65,6 +143,13 @@ func Default() Config {
 				Timeout: 5 * time.Millisecond,
 				Size:    2048,
 			},
+			Compression: store.CompressionConfig{
+				Algorithm: "",
+				MinSize:   1024,
+			},
+			Encryption: store.EncryptionConfig{
+				Enabled: false,
+			},
 		},
 		Metrics: metrics.Config{
 			Enabled:  true,
@@ -78,5 +163,19 @@ func Default() Config {
 			JaegerAgentPort:  "6831",
 			SamplingFraction: 1,
 		},
+		Logging: logging.Config{
+			Level:  "info",
+			Format: "json",
+		},
+		Auth: auth.Config{
+			Enabled: false,
+			JWT: auth.JWTConfig{
+				Enabled:        false,
+				PrincipalClaim: "sub",
+			},
+		},
+		RateLimit: ratelimit.Config{
+			Enabled: false,
+		},
 	}
 }
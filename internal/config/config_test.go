@@ -0,0 +1,23 @@
+package config
+
+import (
+	"bytes"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+)
+
+func TestLoggedConfigShouldHideSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.Tokens = []auth.TokenConfig{{Token: "static-secret", Principal: "ops"}}
+	cfg.Store.Postgres.Password = "postgres-secret"
+
+	var out bytes.Buffer
+	slog.New(slog.NewJSONHandler(&out, nil)).Info("config loaded", slog.Any("config", cfg))
+
+	assert.NotContains(t, out.String(), "static-secret")
+	assert.NotContains(t, out.String(), "postgres-secret")
+	assert.Contains(t, out.String(), "ops")
+	assert.Equal(t, "static-secret", cfg.Auth.Tokens[0].Token)
+}
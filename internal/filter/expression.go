@@ -0,0 +1,455 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxExpressionDepth bounds the nesting of expressions,
+// so that parsing a hostile one can not exhaust the stack
+const maxExpressionDepth = 64
+
+type kind int
+
+const (
+	kindBool kind = iota
+	kindInt
+	kindString
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindBool:
+		return "bool"
+	case kindInt:
+		return "int"
+	default:
+		return "string"
+	}
+}
+
+// node is a typed, compiled part of an expression;
+// only the evaluation function of its kind is set
+type node struct {
+	kind kind
+	b    func(*broker.Message) bool
+	i    func(*broker.Message) int
+	s    func(*broker.Message) string
+}
+
+type expression struct {
+	root node
+}
+
+func (e *expression) eval(msg *broker.Message) bool {
+	return e.root.b(msg)
+}
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdent
+	tokenString
+	tokenInt
+	tokenOperator
+)
+
+type token struct {
+	typ   tokenType
+	text  string
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	if t.typ == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// operators are matched longest first
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", "."}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(source); {
+		c := rune(source[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case c == '"' || c == '\'':
+			end := pos + 1
+			for end < len(source) && source[end] != source[pos] {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at %d", pos)
+			}
+			text := source[pos : end+1]
+			quoted := text
+			if c == '\'' {
+				quoted = `"` + strings.ReplaceAll(strings.ReplaceAll(text[1:len(text)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s at %d", text, pos)
+			}
+			tokens = append(tokens, token{typ: tokenString, text: text, value: value, pos: pos})
+			pos = end + 1
+		case unicode.IsDigit(c):
+			end := pos
+			for end < len(source) && unicode.IsDigit(rune(source[end])) {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokenInt, text: source[pos:end], value: source[pos:end], pos: pos})
+			pos = end
+		case c == '_' || unicode.IsLetter(c):
+			end := pos
+			for end < len(source) && (source[end] == '_' || unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokenIdent, text: source[pos:end], value: source[pos:end], pos: pos})
+			pos = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[pos:], op) {
+					tokens = append(tokens, token{typ: tokenOperator, text: op, value: op, pos: pos})
+					pos += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, pos)
+			}
+		}
+	}
+
+	return append(tokens, token{typ: tokenEOF, pos: len(source)}), nil
+}
+
+// parser is a recursive descent parser of the grammar
+//
+//	or         = and { "||" and }
+//	and        = not { "&&" not }
+//	not        = "!" not | comparison
+//	comparison = member [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) member | "in" "headers" ]
+//	member     = primary { "." method "(" or ")" }
+//	primary    = string | int | "true" | "false" | "(" or ")" | field | "headers" "[" string "]"
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func parse(source string) (*expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.typ != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at %d", next, next.pos)
+	}
+	if root.kind != kindBool {
+		return nil, fmt.Errorf("expression is %s, not bool", root.kind)
+	}
+
+	return &expression{root: root}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator or keyword text
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.typ == tokenOperator || t.typ == tokenIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d, found %s", text, t.pos, t)
+	}
+	return nil
+}
+
+func (p *parser) or() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxExpressionDepth {
+		return node{}, fmt.Errorf("expression is nested too deeply")
+	}
+
+	left, err := p.and()
+	if err != nil {
+		return node{}, err
+	}
+	for p.peek().text == "||" {
+		pos := p.next().pos
+		right, err := p.and()
+		if err != nil {
+			return node{}, err
+		}
+		if left.kind != kindBool || right.kind != kindBool {
+			return node{}, fmt.Errorf("|| at %d needs bool operands", pos)
+		}
+		l, r := left.b, right.b
+		left = node{kind: kindBool, b: func(m *broker.Message) bool { return l(m) || r(m) }}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.not()
+	if err != nil {
+		return node{}, err
+	}
+	for p.peek().text == "&&" {
+		pos := p.next().pos
+		right, err := p.not()
+		if err != nil {
+			return node{}, err
+		}
+		if left.kind != kindBool || right.kind != kindBool {
+			return node{}, fmt.Errorf("&& at %d needs bool operands", pos)
+		}
+		l, r := left.b, right.b
+		left = node{kind: kindBool, b: func(m *broker.Message) bool { return l(m) && r(m) }}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (node, error) {
+	if t := p.peek(); t.typ == tokenOperator && t.text == "!" {
+		p.next()
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxExpressionDepth {
+			return node{}, fmt.Errorf("expression is nested too deeply")
+		}
+
+		operand, err := p.not()
+		if err != nil {
+			return node{}, err
+		}
+		if operand.kind != kindBool {
+			return node{}, fmt.Errorf("! at %d needs a bool operand", t.pos)
+		}
+		b := operand.b
+		return node{kind: kindBool, b: func(m *broker.Message) bool { return !b(m) }}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	left, err := p.member()
+	if err != nil {
+		return node{}, err
+	}
+
+	t := p.peek()
+	if t.typ == tokenIdent && t.text == "in" {
+		p.next()
+		if err := p.expect("headers"); err != nil {
+			return node{}, err
+		}
+		if left.kind != kindString {
+			return node{}, fmt.Errorf("in at %d needs a string header name", t.pos)
+		}
+		name := left.s
+		return node{kind: kindBool, b: func(m *broker.Message) bool {
+			_, ok := m.Headers[name(m)]
+			return ok
+		}}, nil
+	}
+
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		if t.typ != tokenOperator {
+			return left, nil
+		}
+	default:
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.member()
+	if err != nil {
+		return node{}, err
+	}
+	if left.kind != right.kind {
+		return node{}, fmt.Errorf("%s at %d compares %s with %s", t.text, t.pos, left.kind, right.kind)
+	}
+	if left.kind == kindBool && t.text != "==" && t.text != "!=" {
+		return node{}, fmt.Errorf("%s at %d can not compare bools", t.text, t.pos)
+	}
+
+	return compare(t.text, left, right), nil
+}
+
+func compare(op string, left, right node) node {
+	var cmp func(*broker.Message) int
+	switch left.kind {
+	case kindBool:
+		l, r := left.b, right.b
+		equal := func(m *broker.Message) bool { return l(m) == r(m) }
+		if op == "==" {
+			return node{kind: kindBool, b: equal}
+		}
+		return node{kind: kindBool, b: func(m *broker.Message) bool { return !equal(m) }}
+	case kindInt:
+		l, r := left.i, right.i
+		cmp = func(m *broker.Message) int {
+			a, b := l(m), r(m)
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	default:
+		l, r := left.s, right.s
+		cmp = func(m *broker.Message) int { return strings.Compare(l(m), r(m)) }
+	}
+
+	var holds func(int) bool
+	switch op {
+	case "==":
+		holds = func(c int) bool { return c == 0 }
+	case "!=":
+		holds = func(c int) bool { return c != 0 }
+	case "<":
+		holds = func(c int) bool { return c < 0 }
+	case "<=":
+		holds = func(c int) bool { return c <= 0 }
+	case ">":
+		holds = func(c int) bool { return c > 0 }
+	default:
+		holds = func(c int) bool { return c >= 0 }
+	}
+	return node{kind: kindBool, b: func(m *broker.Message) bool { return holds(cmp(m)) }}
+}
+
+// methods are the functions callable on strings
+var methods = map[string]func(s, arg string) bool{
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"contains":   strings.Contains,
+}
+
+func (p *parser) member() (node, error) {
+	target, err := p.primary()
+	if err != nil {
+		return node{}, err
+	}
+
+	for p.accept(".") {
+		name := p.next()
+		method, ok := methods[name.text]
+		if name.typ != tokenIdent || !ok {
+			return node{}, fmt.Errorf("unknown method %s at %d", name, name.pos)
+		}
+		if err := p.expect("("); err != nil {
+			return node{}, err
+		}
+		arg, err := p.or()
+		if err != nil {
+			return node{}, err
+		}
+		if err := p.expect(")"); err != nil {
+			return node{}, err
+		}
+		if target.kind != kindString || arg.kind != kindString {
+			return node{}, fmt.Errorf("%s at %d is called on a string with a string argument", name.text, name.pos)
+		}
+
+		s, a := target.s, arg.s
+		target = node{kind: kindBool, b: func(m *broker.Message) bool { return method(s(m), a(m)) }}
+	}
+
+	return target, nil
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+	switch t.typ {
+	case tokenString:
+		value := t.value
+		return node{kind: kindString, s: func(*broker.Message) string { return value }}, nil
+	case tokenInt:
+		value, err := strconv.Atoi(t.value)
+		if err != nil {
+			return node{}, fmt.Errorf("invalid number %s at %d", t, t.pos)
+		}
+		return node{kind: kindInt, i: func(*broker.Message) int { return value }}, nil
+	case tokenOperator:
+		if t.text != "(" {
+			break
+		}
+		inner, err := p.or()
+		if err != nil {
+			return node{}, err
+		}
+		return inner, p.expect(")")
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			value := t.text == "true"
+			return node{kind: kindBool, b: func(*broker.Message) bool { return value }}, nil
+		case "body":
+			return node{kind: kindString, s: func(m *broker.Message) string { return m.Body }}, nil
+		case "key":
+			return node{kind: kindString, s: func(m *broker.Message) string { return m.Key }}, nil
+		case "id":
+			return node{kind: kindInt, i: func(m *broker.Message) int { return m.Id }}, nil
+		case "schema_version":
+			return node{kind: kindInt, i: func(m *broker.Message) int { return m.SchemaVersion }}, nil
+		case "headers":
+			if err := p.expect("["); err != nil {
+				return node{}, err
+			}
+			name := p.next()
+			if name.typ != tokenString {
+				return node{}, fmt.Errorf("expected a header name at %d, found %s", name.pos, name)
+			}
+			if err := p.expect("]"); err != nil {
+				return node{}, err
+			}
+			header := name.value
+			return node{kind: kindString, s: func(m *broker.Message) string { return m.Headers[header] }}, nil
+		}
+		return node{}, fmt.Errorf("unknown identifier %s at %d", t, t.pos)
+	}
+
+	return node{}, fmt.Errorf("unexpected %s at %d", t, t.pos)
+}
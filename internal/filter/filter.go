@@ -0,0 +1,54 @@
+package filter
+
+import (
+	"fmt"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"strings"
+)
+
+// Matcher is a compiled broker.Filter
+type Matcher struct {
+	headers    map[string]string
+	bodyPrefix string
+	expression *expression
+}
+
+// Compile returns a Matcher for filter, or nil if filter selects every message
+func Compile(filter broker.Filter) (*Matcher, error) {
+	if filter.IsZero() {
+		return nil, nil
+	}
+
+	m := &Matcher{
+		headers:    filter.Headers,
+		bodyPrefix: filter.BodyPrefix,
+	}
+	if filter.Expression != "" {
+		var err error
+		m.expression, err = parse(filter.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// Match reports whether msg is selected; a nil Matcher selects every message
+func (m *Matcher) Match(msg *broker.Message) bool {
+	if m == nil {
+		return true
+	}
+
+	for name, value := range m.headers {
+		if v, ok := msg.Headers[name]; !ok || v != value {
+			return false
+		}
+	}
+
+	if !strings.HasPrefix(msg.Body, m.bodyPrefix) {
+		return false
+	}
+
+	return m.expression == nil || m.expression.eval(msg)
+}
@@ -0,0 +1,87 @@
+package filter
+
+import (
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var testMessage = &broker.Message{
+	Id:            7,
+	Body:          `{"total": 12}`,
+	Headers:       map[string]string{"type": "created", "region": "eu"},
+	Key:           "order-7",
+	SchemaVersion: 2,
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  broker.Filter
+		matched bool
+	}{
+		{"zero filter", broker.Filter{}, true},
+		{"matching headers", broker.Filter{Headers: map[string]string{"type": "created", "region": "eu"}}, true},
+		{"different header", broker.Filter{Headers: map[string]string{"type": "deleted"}}, false},
+		{"missing header", broker.Filter{Headers: map[string]string{"tenant": ""}}, false},
+		{"body prefix", broker.Filter{BodyPrefix: `{"total"`}, true},
+		{"other body prefix", broker.Filter{BodyPrefix: "["}, false},
+		{"header comparison", broker.Filter{Expression: `headers["type"] == "created"`}, true},
+		{"single quotes", broker.Filter{Expression: `headers['region'] != 'us'`}, true},
+		{"missing header is empty", broker.Filter{Expression: `headers["tenant"] == ""`}, true},
+		{"header presence", broker.Filter{Expression: `"region" in headers && !("tenant" in headers)`}, true},
+		{"int comparison", broker.Filter{Expression: `id > 5 && schema_version <= 2`}, true},
+		{"string methods", broker.Filter{Expression: `body.startsWith("{") && key.endsWith("-7") && body.contains("total")`}, true},
+		{"precedence", broker.Filter{Expression: `id == 1 || id == 7 && key == "order-7"`}, true},
+		{"parentheses", broker.Filter{Expression: `(id == 1 || id == 7) && key == "order-1"`}, false},
+		{"bool comparison", broker.Filter{Expression: `key.startsWith("order") == true`}, true},
+		{"all criteria", broker.Filter{Headers: map[string]string{"type": "created"}, BodyPrefix: "{", Expression: `id < 5`}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := Compile(test.filter)
+			assert.Nil(t, err)
+			assert.Equal(t, test.matched, m.Match(testMessage))
+		})
+	}
+}
+
+func TestCompileShouldRejectInvalidExpressions(t *testing.T) {
+	expressions := []string{
+		`id`,
+		`body == 1`,
+		`id > "1"`,
+		`true < false`,
+		`!body`,
+		`id == 1 &&`,
+		`headers.type == "created"`,
+		`headers[type] == "created"`,
+		`"type" in body`,
+		`body.matches(".*")`,
+		`id.startsWith("1")`,
+		`size(body) > 1`,
+		`body == "unterminated`,
+		`id == 1 ; id == 2`,
+		`(id == 1`,
+		strings.Repeat("(", 100) + "true" + strings.Repeat(")", 100),
+		strings.Repeat("!", 100) + "true",
+	}
+
+	for _, expression := range expressions {
+		_, err := Compile(broker.Filter{Expression: expression})
+		assert.NotNil(t, err, expression)
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	m, err := Compile(broker.Filter{Expression: `headers["type"] == "created" && (id > 100 || body.startsWith("{"))`})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		m.Match(testMessage)
+	}
+}
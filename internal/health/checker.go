@@ -0,0 +1,125 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	checkTimeout = 2 * time.Second
+)
+
+var (
+	ErrShuttingDown = errors.New("server is shutting down")
+	ErrNotChecked   = errors.New("health is not checked yet")
+)
+
+// Check returns nil if the dependency is healthy
+type Check func(ctx context.Context) error
+
+// Checker periodically runs the checks and reflects the result in the
+// grpc health service, for the whole server and every service in services
+type Checker struct {
+	server   *health.Server
+	check    Check
+	services []string
+	interval time.Duration
+	logger   *slog.Logger
+
+	lock     sync.RWMutex
+	lastErr  error
+	shutdown bool
+}
+
+func NewChecker(check Check, interval time.Duration, logger *slog.Logger, services ...string) *Checker {
+	c := &Checker{
+		server:   health.NewServer(),
+		check:    check,
+		services: append([]string{""}, services...),
+		interval: interval,
+		logger:   logger,
+		lastErr:  ErrNotChecked,
+	}
+	c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return c
+}
+
+// Server returns the grpc health service to be registered
+func (c *Checker) Server() healthpb.HealthServer {
+	return c.server
+}
+
+// Run checks the health every interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.runCheck(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) runCheck(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	err := c.check(ctx)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.shutdown {
+		return
+	}
+
+	if (err == nil) != (c.lastErr == nil) {
+		if err != nil {
+			c.logger.Warn("health check failed", logging.Error(err))
+		} else {
+			c.logger.Info("health check passed")
+		}
+	}
+	c.lastErr = err
+
+	if err != nil {
+		c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	} else {
+		c.setStatus(healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+// Shutdown marks the server as not serving permanently
+func (c *Checker) Shutdown() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.shutdown = true
+	c.lastErr = ErrShuttingDown
+	c.server.Shutdown()
+}
+
+// Ready returns nil if the last check passed and the server is not shutting down
+func (c *Checker) Ready() error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.lastErr
+}
+
+func (c *Checker) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, status)
+	}
+}
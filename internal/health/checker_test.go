@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func servingStatus(t *testing.T, c *Checker, service string) healthpb.HealthCheckResponse_ServingStatus {
+	res, err := c.Server().Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	assert.Nil(t, err)
+	return res.GetStatus()
+}
+
+func TestChecker(t *testing.T) {
+	var checkErr error
+	c := NewChecker(func(ctx context.Context) error {
+		return checkErr
+	}, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)), "broker.Broker")
+
+	assert.ErrorIs(t, c.Ready(), ErrNotChecked)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, c, ""))
+
+	c.runCheck(context.Background())
+	assert.Nil(t, c.Ready())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, c, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(t, c, "broker.Broker"))
+
+	checkErr = errors.New("store is down")
+	c.runCheck(context.Background())
+	assert.ErrorIs(t, c.Ready(), checkErr)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, c, "broker.Broker"))
+}
+
+func TestCheckerShouldIgnoreChecksAfterShutdown(t *testing.T) {
+	c := NewChecker(func(ctx context.Context) error {
+		return nil
+	}, time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	c.Shutdown()
+	c.runCheck(context.Background())
+	assert.ErrorIs(t, c.Ready(), ErrShuttingDown)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(t, c, ""))
+}
+
+func TestRunShouldCheckUntilCanceled(t *testing.T) {
+	checks := make(chan struct{})
+	c := NewChecker(func(ctx context.Context) error {
+		select {
+		case checks <- struct{}{}:
+		default:
+		}
+		return nil
+	}, time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	<-checks
+	<-checks
+	cancel()
+	<-done
+	assert.Nil(t, c.Ready())
+}
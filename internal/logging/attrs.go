@@ -0,0 +1,21 @@
+package logging
+
+import "log/slog"
+
+const (
+	errorKey   = "error"
+	subjectKey = "subject"
+	idKey      = "id"
+)
+
+func Error(err error) slog.Attr {
+	return slog.Any(errorKey, err)
+}
+
+func Subject(val string) slog.Attr {
+	return slog.String(subjectKey, val)
+}
+
+func MessageId(id int) slog.Attr {
+	return slog.Int(idKey, id)
+}
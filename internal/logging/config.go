@@ -0,0 +1,8 @@
+package logging
+
+type Config struct {
+	// Level is one of debug, info, warn or error
+	Level string `config:"level"`
+	// Format is either json or text
+	Format string `config:"format"`
+}
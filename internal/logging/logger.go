@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	jsonFormat = "json"
+	textFormat = "text"
+
+	traceIdKey = "trace_id"
+	spanIdKey  = "span_id"
+)
+
+func (c Config) Validate() error {
+	if _, err := c.level(); err != nil {
+		return err
+	}
+
+	switch strings.ToLower(c.Format) {
+	case jsonFormat, textFormat:
+		return nil
+	}
+
+	return fmt.Errorf("unknown log format %q", c.Format)
+}
+
+func (c Config) level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return level, fmt.Errorf("unknown log level %q", c.Level)
+	}
+
+	return level, nil
+}
+
+// NewLogger returns a logger writing to stdout based on config.
+// Every record logged with a context carrying a span gets the
+// trace and span ids attached.
+func NewLogger(config Config) *slog.Logger {
+	return newLogger(config, os.Stdout)
+}
+
+func newLogger(config Config, w io.Writer) *slog.Logger {
+	level, err := config.level()
+	if err != nil {
+		level = slog.LevelInfo
+	}
+
+	options := &slog.HandlerOptions{
+		Level: level,
+	}
+
+	var handler slog.Handler
+	if strings.ToLower(config.Format) == textFormat {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+
+	return slog.New(&tracingHandler{Handler: handler})
+}
+
+// NewNopLogger returns a logger that discards everything
+func NewNopLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{
+		Level: slog.LevelError + 1,
+	}))
+}
+
+type tracingHandler struct {
+	slog.Handler
+}
+
+func (t *tracingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(
+			slog.String(traceIdKey, spanContext.TraceID().String()),
+			slog.String(spanIdKey, spanContext.SpanID().String()),
+		)
+	}
+
+	return t.Handler.Handle(ctx, record)
+}
+
+func (t *tracingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &tracingHandler{Handler: t.Handler.WithAttrs(attrs)}
+}
+
+func (t *tracingHandler) WithGroup(name string) slog.Handler {
+	return &tracingHandler{Handler: t.Handler.WithGroup(name)}
+}
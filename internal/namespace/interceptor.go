@@ -0,0 +1,71 @@
+package namespace
+
+import (
+	"context"
+	"errors"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Header is the metadata key a call asks for a namespace with
+const Header = "namespace"
+
+// UnaryServerInterceptor sets the namespace of calls, and has to
+// run after the authentication interceptors, if there are any
+func UnaryServerInterceptor(registry *Registry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := resolve(ctx, registry)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor(registry *Registry) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := resolve(ss.Context(), registry)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &namespacedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func resolve(ctx context.Context, registry *Registry) (context.Context, error) {
+	var requested string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(Header); len(values) > 0 {
+			requested = values[0]
+		}
+	}
+
+	return Resolve(ctx, registry, requested)
+}
+
+// Resolve sets the namespace of a call asking for requested, returning
+// a NotFound or PermissionDenied status if it can not be used
+func Resolve(ctx context.Context, registry *Registry, requested string) (context.Context, error) {
+	principal, _ := auth.PrincipalFromContext(ctx)
+	namespace, err := registry.Resolve(principal, requested)
+	if errors.Is(err, ErrNotFound) {
+		return ctx, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return ctx, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return WithNamespace(ctx, namespace), nil
+}
+
+type namespacedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (n *namespacedStream) Context() context.Context {
+	return n.ctx
+}
@@ -0,0 +1,99 @@
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
+	"strings"
+)
+
+const (
+	// Default is the namespace of the calls that do not ask for any other;
+	// it always exists, and its subjects are stored as they are
+	Default = "default"
+	// Separator joins a namespace and a subject in the stores,
+	// and is never allowed in subjects
+	Separator = "/"
+
+	maxNameLength = 63
+	nameCharset   = "abcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// Namespace isolates the subjects, messages, schemas and subscriptions
+// of a tenant from the other namespaces
+type Namespace struct {
+	Name string `config:"name"`
+	// Principals are the only ones allowed to use the namespace, and can't
+	// use any other; the principals bound to no namespace may use Default
+	// and the namespaces without principals
+	Principals []string `config:"principals"`
+	// Quotas are shared by every call made in the namespace
+	Quotas ratelimit.Limits `config:"quotas"`
+}
+
+func (n Namespace) Validate() error {
+	if err := ValidateName(n.Name); err != nil {
+		return err
+	}
+	for _, principal := range n.Principals {
+		if principal == "" {
+			return fmt.Errorf("namespace %q has an empty principal", n.Name)
+		}
+	}
+	q := n.Quotas
+	if q.PublishRate < 0 || q.PublishBurst < 0 || q.BytesPerSecond < 0 || q.BytesBurst < 0 || q.MaxSubscriptions < 0 {
+		return fmt.Errorf("namespace %q has negative quotas", n.Name)
+	}
+
+	return nil
+}
+
+// ValidateName checks name is made of at most 63 lowercase
+// letters, digits, dashes and underscores
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("namespace has no name")
+	}
+	if len(name) > maxNameLength {
+		return fmt.Errorf("namespace name %q is longer than %d characters", name, maxNameLength)
+	}
+	for _, r := range name {
+		if !strings.ContainsRune(nameCharset, r) {
+			return fmt.Errorf("namespace name %q has the invalid character %q", name, r)
+		}
+	}
+
+	return nil
+}
+
+// Qualify returns the name subject is kept by in the stores, so that
+// every namespace has its own sequences and storage partitions
+func Qualify(namespace, subject string) string {
+	if namespace == Default || namespace == "" {
+		return subject
+	}
+	return namespace + Separator + subject
+}
+
+// Split is the reverse of Qualify
+func Split(qualified string) (namespace, subject string) {
+	if i := strings.Index(qualified, Separator); i >= 0 {
+		return qualified[:i], qualified[i+len(Separator):]
+	}
+	return Default, qualified
+}
+
+type namespaceKey struct{}
+
+// WithNamespace returns a copy of ctx carrying the namespace of a call
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// FromContext returns the namespace set by WithNamespace, or Default
+func FromContext(ctx context.Context) string {
+	if namespace, ok := ctx.Value(namespaceKey{}).(string); ok {
+		return namespace
+	}
+	return Default
+}
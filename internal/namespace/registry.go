@@ -0,0 +1,175 @@
+package namespace
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrNotFound  = errors.New("namespace not found")
+	ErrExists    = errors.New("namespace already exists")
+	ErrForbidden = errors.New("namespace is not allowed")
+)
+
+// Registry holds the namespaces and is safe for concurrent use;
+// changes made at runtime are not persisted
+type Registry struct {
+	lock       sync.RWMutex
+	namespaces map[string]Namespace
+	// principals maps every principal to the namespace it's bound to
+	principals map[string]string
+}
+
+// NewRegistry returns a Registry of namespaces, which always has Default
+func NewRegistry(namespaces []Namespace) (*Registry, error) {
+	r := &Registry{
+		namespaces: map[string]Namespace{
+			Default: {Name: Default},
+		},
+		principals: make(map[string]string),
+	}
+
+	for _, n := range namespaces {
+		if err := r.Create(n); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Create adds n, unless a namespace of the same name exists
+// or one of its principals is bound to another namespace
+func (r *Registry) Create(n Namespace) error {
+	if err := n.Validate(); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.namespaces[n.Name]; ok {
+		return fmt.Errorf("%w: %q", ErrExists, n.Name)
+	}
+	for _, principal := range n.Principals {
+		if bound, ok := r.principals[principal]; ok {
+			return fmt.Errorf("principal %q is already bound to namespace %q", principal, bound)
+		}
+	}
+
+	r.namespaces[n.Name] = n
+	for _, principal := range n.Principals {
+		r.principals[principal] = n.Name
+	}
+	return nil
+}
+
+// Delete removes the namespace of name and reports whether it existed;
+// Default is never removed
+func (r *Registry) Delete(name string) bool {
+	if name == Default {
+		return false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	n, ok := r.namespaces[name]
+	if !ok {
+		return false
+	}
+	delete(r.namespaces, name)
+	for _, principal := range n.Principals {
+		delete(r.principals, principal)
+	}
+	return true
+}
+
+func (r *Registry) Get(name string) (Namespace, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	n, ok := r.namespaces[name]
+	return n, ok
+}
+
+// List returns the namespaces sorted by name
+func (r *Registry) List() []Namespace {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	namespaces := make([]Namespace, 0, len(r.namespaces))
+	for _, n := range r.namespaces {
+		namespaces = append(namespaces, n)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces
+}
+
+// Resolve returns the namespace of a call made by principal asking for
+// requested. Principals bound to a namespace can only use that one, which
+// is also used if nothing is requested; the others use Default by default,
+// and may use any namespace without principals. A nil Registry only has Default.
+func (r *Registry) Resolve(principal, requested string) (string, error) {
+	if r == nil {
+		if requested == "" || requested == Default {
+			return Default, nil
+		}
+		return "", fmt.Errorf("%w: %q", ErrNotFound, requested)
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	bound, isBound := r.principals[principal]
+	if requested == "" {
+		if isBound {
+			return bound, nil
+		}
+		return Default, nil
+	}
+
+	n, ok := r.namespaces[requested]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrNotFound, requested)
+	}
+	if isBound && bound != n.Name {
+		return "", fmt.Errorf("%w: %q", ErrForbidden, requested)
+	}
+	if !isBound && len(n.Principals) > 0 {
+		return "", fmt.Errorf("%w: %q", ErrForbidden, requested)
+	}
+
+	return n.Name, nil
+}
+
+// Bound returns the namespace principal is bound to, if any
+func (r *Registry) Bound(principal string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	name, ok := r.principals[principal]
+	return name, ok
+}
+
+// Quotas returns the quotas of the namespace of name,
+// which are zero if it has none or does not exist
+func (r *Registry) Quotas(name string) ratelimit.Limits {
+	if r == nil {
+		return ratelimit.Limits{}
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.namespaces[name].Quotas
+}
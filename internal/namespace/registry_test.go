@@ -0,0 +1,94 @@
+package namespace
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestQualifyShouldBeReversedBySplit(t *testing.T) {
+	assert.Equal(t, "orders.new", Qualify(Default, "orders.new"))
+	assert.Equal(t, "team-a/orders.new", Qualify("team-a", "orders.new"))
+
+	ns, subject := Split("team-a/orders.new")
+	assert.Equal(t, "team-a", ns)
+	assert.Equal(t, "orders.new", subject)
+
+	ns, subject = Split("orders.new")
+	assert.Equal(t, Default, ns)
+	assert.Equal(t, "orders.new", subject)
+}
+
+func TestFromContextShouldDefault(t *testing.T) {
+	assert.Equal(t, Default, FromContext(context.Background()))
+	assert.Equal(t, "team-a", FromContext(WithNamespace(context.Background(), "team-a")))
+}
+
+func TestResolve(t *testing.T) {
+	r, err := NewRegistry([]Namespace{
+		{Name: "team-a", Principals: []string{"alice"}},
+		{Name: "shared"},
+	})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		principal string
+		requested string
+		expected  string
+		err       error
+	}{
+		{"alice", "", "team-a", nil},
+		{"bob", "", Default, nil},
+		{"", "", Default, nil},
+		{"alice", "team-a", "team-a", nil},
+		{"bob", "team-a", "", ErrForbidden},
+		{"", "team-a", "", ErrForbidden},
+		{"bob", "shared", "shared", nil},
+		{"alice", Default, "", ErrForbidden},
+		{"alice", "shared", "", ErrForbidden},
+		{"bob", "missing", "", ErrNotFound},
+	}
+	for _, test := range tests {
+		ns, err := r.Resolve(test.principal, test.requested)
+		assert.ErrorIs(t, err, test.err, "%s asking for %q", test.principal, test.requested)
+		assert.Equal(t, test.expected, ns, "%s asking for %q", test.principal, test.requested)
+	}
+
+	var nilRegistry *Registry
+	ns, err := nilRegistry.Resolve("alice", "")
+	assert.Nil(t, err)
+	assert.Equal(t, Default, ns)
+	_, err = nilRegistry.Resolve("alice", "team-a")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRegistryShouldCreateAndDeleteNamespaces(t *testing.T) {
+	r, err := NewRegistry(nil)
+	assert.Nil(t, err)
+
+	quotas := ratelimit.Limits{PublishRate: 10}
+	assert.Nil(t, r.Create(Namespace{Name: "team-a", Principals: []string{"alice"}, Quotas: quotas}))
+	assert.ErrorIs(t, r.Create(Namespace{Name: "team-a"}), ErrExists)
+	assert.NotNil(t, r.Create(Namespace{Name: "team-b", Principals: []string{"alice"}}))
+	assert.NotNil(t, r.Create(Namespace{Name: "Team/B"}))
+	assert.Equal(t, quotas, r.Quotas("team-a"))
+
+	names := func() []string {
+		var names []string
+		for _, n := range r.List() {
+			names = append(names, n.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{Default, "team-a"}, names())
+
+	assert.False(t, r.Delete(Default))
+	assert.True(t, r.Delete("team-a"))
+	assert.False(t, r.Delete("team-a"))
+	assert.Equal(t, []string{Default}, names())
+	assert.Equal(t, ratelimit.Limits{}, r.Quotas("team-a"))
+
+	// principals are free to be bound again once their namespace is gone
+	assert.Nil(t, r.Create(Namespace{Name: "team-b", Principals: []string{"alice"}}))
+}
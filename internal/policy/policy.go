@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Policy overrides the global behavior for the subjects matching Subject,
+// which is a pattern like "orders.>"; zero values keep the global behavior
+type Policy struct {
+	Subject string `config:"subject"`
+	// DefaultTTL is used when a published message has no expiration
+	DefaultTTL time.Duration `config:"default_ttl"`
+	MaxTTL     time.Duration `config:"max_ttl"`
+	// MaxMessages is the number of latest messages retained;
+	// older ones are removed from the store as new ones are published
+	MaxMessages int `config:"max_messages"`
+	// MaxMessageSize is the maximum size of a published body in bytes
+	MaxMessageSize int `config:"max_message_size"`
+	// Backend is the name of the store keeping the messages of the subjects
+	Backend string `config:"backend"`
+	// Compacted subjects only retain the latest message of every key;
+	// superseded messages are removed in the background
+	Compacted bool `config:"compacted"`
+	// Encrypted subjects have their bodies encrypted in the persistent
+	// stores, if store encryption is enabled
+	Encrypted bool `config:"encrypted"`
+}
+
+func (p Policy) Validate() error {
+	if p.Subject == "" {
+		return fmt.Errorf("policy has no subject")
+	}
+	if p.DefaultTTL < 0 || p.MaxTTL < 0 || p.MaxMessages < 0 || p.MaxMessageSize < 0 {
+		return fmt.Errorf("policy of %q has negative limits", p.Subject)
+	}
+	if p.MaxTTL > 0 && p.DefaultTTL > p.MaxTTL {
+		return fmt.Errorf("policy of %q has a default ttl above its max ttl", p.Subject)
+	}
+
+	return nil
+}
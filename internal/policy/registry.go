@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/internal/subject"
+	"sync"
+)
+
+var (
+	ErrNotFound = errors.New("policy not found")
+	// ErrBackendChange is returned for the changes that would route subjects,
+	// leaving their stored messages behind, to another backend
+	ErrBackendChange = errors.New("backends of policies can only be changed in the config")
+)
+
+// Registry holds the policies and is safe for concurrent use;
+// changes made at runtime are not persisted
+type Registry struct {
+	lock     sync.RWMutex
+	policies []Policy
+	backends map[string]bool
+}
+
+// NewRegistry returns a Registry of policies, which may only
+// refer to the given storage backends
+func NewRegistry(policies []Policy, backends ...string) (*Registry, error) {
+	r := &Registry{
+		backends: make(map[string]bool, len(backends)),
+	}
+	for _, backend := range backends {
+		r.backends[backend] = true
+	}
+
+	for _, p := range policies {
+		if err := r.set(p, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// For returns the first policy whose pattern matches subjectName,
+// in the order they were added
+func (r *Registry) For(subjectName string) (Policy, bool) {
+	if r == nil {
+		return Policy{}, false
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, p := range r.policies {
+		if subject.Match(p.Subject, subjectName) {
+			return p, true
+		}
+	}
+	return Policy{}, false
+}
+
+// Set adds p, or replaces the policy with the same pattern in place;
+// it fails with ErrBackendChange if the backend of any subject changes
+func (r *Registry) Set(p Policy) error {
+	return r.set(p, false)
+}
+
+func (r *Registry) set(p Policy, backendChangeable bool) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if p.Backend != "" && !r.backends[p.Backend] {
+		return fmt.Errorf("policy of %q refers to unknown backend %q", p.Subject, p.Backend)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.policies {
+		if r.policies[i].Subject == p.Subject {
+			if !backendChangeable && r.policies[i].Backend != p.Backend {
+				return fmt.Errorf("%w: %q", ErrBackendChange, p.Subject)
+			}
+			r.policies[i] = p
+			return nil
+		}
+	}
+	// new policies come last, so they only apply to the
+	// subjects no policy matched, which use the default backend
+	if !backendChangeable && p.Backend != "" {
+		return fmt.Errorf("%w: %q", ErrBackendChange, p.Subject)
+	}
+	r.policies = append(r.policies, p)
+	return nil
+}
+
+// Delete removes the policy of pattern; it fails with ErrBackendChange
+// if the subjects it matches would fall to a policy of another backend
+func (r *Registry) Delete(pattern string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.policies {
+		if r.policies[i].Subject != pattern {
+			continue
+		}
+		if r.policies[i].Backend != "" {
+			return fmt.Errorf("%w: %q", ErrBackendChange, pattern)
+		}
+		for _, next := range r.policies[i+1:] {
+			if next.Backend != "" && subject.Overlaps(pattern, next.Subject) {
+				return fmt.Errorf("%w: %q", ErrBackendChange, pattern)
+			}
+		}
+		r.policies = append(r.policies[:i], r.policies[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrNotFound, pattern)
+}
+
+func (r *Registry) List() []Policy {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	policies := make([]Policy, len(r.policies))
+	copy(policies, r.policies)
+	return policies
+}
@@ -0,0 +1,60 @@
+package policy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRegistryFor(t *testing.T) {
+	r, err := NewRegistry([]Policy{
+		{Subject: "orders.eu", MaxMessages: 10},
+		{Subject: "orders.>", DefaultTTL: time.Minute},
+	})
+	assert.Nil(t, err)
+
+	p, ok := r.For("orders.eu")
+	assert.True(t, ok)
+	assert.Equal(t, 10, p.MaxMessages)
+
+	p, ok = r.For("orders.us.west")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, p.DefaultTTL)
+
+	_, ok = r.For("payments")
+	assert.False(t, ok)
+}
+
+func TestRegistrySetAndDelete(t *testing.T) {
+	r, err := NewRegistry(nil, "memory")
+	assert.Nil(t, err)
+
+	assert.Nil(t, r.Set(Policy{Subject: "orders.>", MaxTTL: time.Hour}))
+	assert.Nil(t, r.Set(Policy{Subject: "orders.>", MaxTTL: time.Minute}))
+	assert.Equal(t, []Policy{{Subject: "orders.>", MaxTTL: time.Minute}}, r.List())
+
+	assert.NotNil(t, r.Set(Policy{Subject: "orders.>", DefaultTTL: time.Hour, MaxTTL: time.Minute}))
+	assert.NotNil(t, r.Set(Policy{MaxMessages: 1}))
+
+	assert.Nil(t, r.Delete("orders.>"))
+	assert.ErrorIs(t, r.Delete("orders.>"), ErrNotFound)
+	assert.Empty(t, r.List())
+}
+
+func TestRegistryShouldRejectBackendChanges(t *testing.T) {
+	r, err := NewRegistry([]Policy{
+		{Subject: "orders.eu", MaxMessages: 10},
+		{Subject: "orders.>", Backend: "memory"},
+		{Subject: "payments.>", MaxMessages: 10},
+	}, "memory", "postgres")
+	assert.Nil(t, err)
+
+	assert.ErrorIs(t, r.Set(Policy{Subject: "orders.>", Backend: "postgres"}), ErrBackendChange)
+	assert.ErrorIs(t, r.Set(Policy{Subject: "orders.>"}), ErrBackendChange)
+	assert.ErrorIs(t, r.Set(Policy{Subject: "audit.>", Backend: "postgres"}), ErrBackendChange)
+	assert.Nil(t, r.Set(Policy{Subject: "orders.>", MaxMessages: 5, Backend: "memory"}))
+
+	assert.ErrorIs(t, r.Delete("orders.>"), ErrBackendChange)
+	assert.ErrorIs(t, r.Delete("orders.eu"), ErrBackendChange)
+	assert.Nil(t, r.Delete("payments.>"))
+}
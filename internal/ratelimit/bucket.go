@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// bucket is a token bucket refilled continuously with rate tokens per second
+// up to burst tokens. It is not thread-safe.
+type bucket struct {
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastTime time.Time
+}
+
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	b := burstOf(rate, burst)
+	return &bucket{
+		rate:     rate,
+		burst:    b,
+		tokens:   b,
+		lastTime: now,
+	}
+}
+
+func burstOf(rate float64, burst int) float64 {
+	if burst < 1 {
+		return math.Max(1, rate)
+	}
+	return float64(burst)
+}
+
+// hasLimits reports whether b was created with rate and burst
+func (b *bucket) hasLimits(rate float64, burst int) bool {
+	return b.rate == rate && b.burst == burstOf(rate, burst)
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.lastTime).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.lastTime = now
+	}
+}
+
+// wait returns how long it takes until n tokens are available, 0 if they already are.
+// A request larger than burst is allowed once the bucket is full, and puts it
+// in debt, so that it's still limited by rate.
+func (b *bucket) wait(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	n = math.Min(n, b.burst)
+	if b.tokens >= n {
+		return 0
+	}
+
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take consumes n tokens, even if there are fewer
+func (b *bucket) take(n float64) {
+	b.tokens -= n
+}
+
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
@@ -0,0 +1,20 @@
+package ratelimit
+
+type Config struct {
+	Enabled    bool   `config:"enabled"`
+	PerClient  Limits `config:"per_client"`
+	PerSubject Limits `config:"per_subject"`
+}
+
+// Limits are applied to every client, subject or namespace separately;
+// a zero value disables the corresponding limit
+type Limits struct {
+	// PublishRate is the number of published messages per second
+	PublishRate  float64 `config:"publish_rate"`
+	PublishBurst int     `config:"publish_burst"`
+	// BytesPerSecond is the total size of published bodies per second
+	BytesPerSecond float64 `config:"bytes_per_second"`
+	BytesBurst     int     `config:"bytes_burst"`
+	// MaxSubscriptions is the number of concurrent subscriptions
+	MaxSubscriptions int `config:"max_subscriptions"`
+}
@@ -0,0 +1,215 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	ScopeClient    = "client"
+	ScopeSubject   = "subject"
+	ScopeNamespace = "namespace"
+
+	LimitPublishRate   = "publish_rate"
+	LimitBytes         = "bytes_per_second"
+	LimitSubscriptions = "max_subscriptions"
+
+	sweepInterval = time.Minute
+)
+
+// ExceededError is returned when a call is rejected by a limit
+type ExceededError struct {
+	Scope string
+	Limit string
+	// RetryAfter is the time after which the same call may succeed;
+	// 0 if it depends on other calls finishing (e.g. subscriptions)
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s limit exceeded for %s", e.Limit, e.Scope)
+}
+
+type Limiter interface {
+	// AllowPublish consumes the quota of publishing a message of size bytes
+	// by client to subject in namespace, or returns an *ExceededError
+	// without consuming anything; subject has to be unique across namespaces
+	AllowPublish(namespace, client, subject string, size int) error
+	// AcquireSubscription reserves a concurrent subscription;
+	// release must be called when the subscription ends
+	AcquireSubscription(namespace, client, subject string) (release func(), err error)
+}
+
+type limiter struct {
+	config Config
+	quotas func(namespace string) Limits
+	now    func() time.Time
+
+	lock          sync.Mutex
+	buckets       map[bucketKey]*bucket
+	subscriptions map[counterKey]int
+}
+
+type bucketKey struct {
+	scope string
+	limit string
+	name  string
+}
+
+type counterKey struct {
+	scope string
+	name  string
+}
+
+// NewLimiter returns a Limiter applying config, and the quotas of every
+// namespace as they are at the time of each call; its buckets are
+// cleaned up in the background until ctx is done
+func NewLimiter(ctx context.Context, config Config, quotas func(namespace string) Limits) Limiter {
+	l := &limiter{
+		config:        config,
+		quotas:        quotas,
+		now:           time.Now,
+		buckets:       make(map[bucketKey]*bucket),
+		subscriptions: make(map[counterKey]int),
+	}
+	go l.sweep(ctx)
+
+	return l
+}
+
+func (l *limiter) namespaceQuotas(namespace string) Limits {
+	if l.quotas == nil {
+		return Limits{}
+	}
+	return l.quotas(namespace)
+}
+
+func (l *limiter) AllowPublish(namespace, client, subject string, size int) error {
+	quotas := l.namespaceQuotas(namespace)
+	type check struct {
+		key   bucketKey
+		rate  float64
+		burst int
+		n     float64
+	}
+	checks := []check{
+		{bucketKey{ScopeClient, LimitPublishRate, client}, l.config.PerClient.PublishRate, l.config.PerClient.PublishBurst, 1},
+		{bucketKey{ScopeClient, LimitBytes, client}, l.config.PerClient.BytesPerSecond, l.config.PerClient.BytesBurst, float64(size)},
+		{bucketKey{ScopeSubject, LimitPublishRate, subject}, l.config.PerSubject.PublishRate, l.config.PerSubject.PublishBurst, 1},
+		{bucketKey{ScopeSubject, LimitBytes, subject}, l.config.PerSubject.BytesPerSecond, l.config.PerSubject.BytesBurst, float64(size)},
+		{bucketKey{ScopeNamespace, LimitPublishRate, namespace}, quotas.PublishRate, quotas.PublishBurst, 1},
+		{bucketKey{ScopeNamespace, LimitBytes, namespace}, quotas.BytesPerSecond, quotas.BytesBurst, float64(size)},
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	buckets := make([]*bucket, len(checks))
+	for i, c := range checks {
+		if c.rate <= 0 {
+			continue
+		}
+		// quotas of namespaces change at runtime
+		b, ok := l.buckets[c.key]
+		if !ok || !b.hasLimits(c.rate, c.burst) {
+			b = newBucket(c.rate, c.burst, now)
+			l.buckets[c.key] = b
+		}
+		if wait := b.wait(c.n, now); wait > 0 {
+			return &ExceededError{Scope: c.key.scope, Limit: c.key.limit, RetryAfter: wait}
+		}
+		buckets[i] = b
+	}
+
+	for i, b := range buckets {
+		if b != nil {
+			b.take(checks[i].n)
+		}
+	}
+
+	return nil
+}
+
+func (l *limiter) AcquireSubscription(namespace, client, subject string) (func(), error) {
+	quotas := l.namespaceQuotas(namespace)
+	clientKey := counterKey{ScopeClient, client}
+	subjectKey := counterKey{ScopeSubject, subject}
+	namespaceKey := counterKey{ScopeNamespace, namespace}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if max := l.config.PerClient.MaxSubscriptions; max > 0 && l.subscriptions[clientKey] >= max {
+		return nil, &ExceededError{Scope: ScopeClient, Limit: LimitSubscriptions}
+	}
+	if max := l.config.PerSubject.MaxSubscriptions; max > 0 && l.subscriptions[subjectKey] >= max {
+		return nil, &ExceededError{Scope: ScopeSubject, Limit: LimitSubscriptions}
+	}
+	if max := quotas.MaxSubscriptions; max > 0 && l.subscriptions[namespaceKey] >= max {
+		return nil, &ExceededError{Scope: ScopeNamespace, Limit: LimitSubscriptions}
+	}
+
+	l.subscriptions[clientKey]++
+	l.subscriptions[subjectKey]++
+	l.subscriptions[namespaceKey]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			l.release(clientKey)
+			l.release(subjectKey)
+			l.release(namespaceKey)
+		})
+	}, nil
+}
+
+func (l *limiter) release(key counterKey) {
+	l.subscriptions[key]--
+	if l.subscriptions[key] <= 0 {
+		delete(l.subscriptions, key)
+	}
+}
+
+// sweep periodically drops the buckets that are full,
+// as they behave exactly the same as newly created ones
+func (l *limiter) sweep(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		l.lock.Lock()
+		now := l.now()
+		for key, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, key)
+			}
+		}
+		l.lock.Unlock()
+	}
+}
+
+type noLimit struct{}
+
+// NoLimit returns a Limiter that allows everything
+func NoLimit() Limiter {
+	return noLimit{}
+}
+
+func (noLimit) AllowPublish(string, string, string, int) error {
+	return nil
+}
+
+func (noLimit) AcquireSubscription(string, string, string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(config Config) (*limiter, *time.Time) {
+	now := time.Unix(1000, 0)
+	return &limiter{
+		config:        config,
+		now:           func() time.Time { return now },
+		buckets:       make(map[bucketKey]*bucket),
+		subscriptions: make(map[counterKey]int),
+	}, &now
+}
+
+func TestPublishRateShouldRefillOverTime(t *testing.T) {
+	l, now := newTestLimiter(Config{
+		PerClient: Limits{PublishRate: 2, PublishBurst: 2},
+	})
+
+	assert.Nil(t, l.AllowPublish("n", "c", "s", 1))
+	assert.Nil(t, l.AllowPublish("n", "c", "s", 1))
+
+	err := l.AllowPublish("n", "c", "s", 1)
+	exceeded, ok := err.(*ExceededError)
+	assert.True(t, ok)
+	assert.Equal(t, ScopeClient, exceeded.Scope)
+	assert.Equal(t, LimitPublishRate, exceeded.Limit)
+	assert.Equal(t, 500*time.Millisecond, exceeded.RetryAfter)
+
+	assert.Nil(t, l.AllowPublish("n", "other", "s", 1))
+
+	*now = now.Add(500 * time.Millisecond)
+	assert.Nil(t, l.AllowPublish("n", "c", "s", 1))
+}
+
+func TestRejectedPublishShouldNotConsumeOtherLimits(t *testing.T) {
+	l, _ := newTestLimiter(Config{
+		PerClient:  Limits{PublishRate: 10, PublishBurst: 10},
+		PerSubject: Limits{BytesPerSecond: 100, BytesBurst: 100},
+	})
+
+	assert.Nil(t, l.AllowPublish("n", "c", "s", 100))
+	err := l.AllowPublish("n", "c", "s", 10)
+	assert.Equal(t, ScopeSubject, err.(*ExceededError).Scope)
+
+	for i := 0; i < 9; i++ {
+		assert.Nil(t, l.AllowPublish("n", "c", "other", 1))
+	}
+}
+
+func TestSubscriptionsShouldBeReleased(t *testing.T) {
+	l, _ := newTestLimiter(Config{
+		PerSubject: Limits{MaxSubscriptions: 1},
+	})
+
+	release, err := l.AcquireSubscription("n", "c1", "s")
+	assert.Nil(t, err)
+
+	_, err = l.AcquireSubscription("n", "c2", "s")
+	assert.Equal(t, LimitSubscriptions, err.(*ExceededError).Limit)
+
+	release()
+	release()
+	_, err = l.AcquireSubscription("n", "c2", "s")
+	assert.Nil(t, err)
+}
+
+func TestNamespaceQuotasShouldBeSharedByItsClients(t *testing.T) {
+	quotas := map[string]Limits{
+		"a": {PublishRate: 1, PublishBurst: 1, MaxSubscriptions: 1},
+	}
+	l, _ := newTestLimiter(Config{})
+	l.quotas = func(namespace string) Limits {
+		return quotas[namespace]
+	}
+
+	assert.Nil(t, l.AllowPublish("a", "c1", "a/s", 1))
+	err := l.AllowPublish("a", "c2", "a/other", 1)
+	assert.Equal(t, ScopeNamespace, err.(*ExceededError).Scope)
+	assert.Nil(t, l.AllowPublish("b", "c2", "s", 1))
+
+	_, err = l.AcquireSubscription("a", "c1", "a/s")
+	assert.Nil(t, err)
+	_, err = l.AcquireSubscription("a", "c2", "a/other")
+	assert.Equal(t, LimitSubscriptions, err.(*ExceededError).Limit)
+
+	quotas["a"] = Limits{PublishRate: 2, PublishBurst: 2}
+	assert.Nil(t, l.AllowPublish("a", "c2", "a/other", 1))
+}
+
+func TestPublishLargerThanBurstShouldBeChargedInFull(t *testing.T) {
+	l, now := newTestLimiter(Config{
+		PerClient: Limits{BytesPerSecond: 100, BytesBurst: 100},
+	})
+
+	assert.Nil(t, l.AllowPublish("n", "c", "s", 1000))
+
+	err := l.AllowPublish("n", "c", "s", 1)
+	assert.Equal(t, LimitBytes, err.(*ExceededError).Limit)
+	assert.Equal(t, 9010*time.Millisecond, err.(*ExceededError).RetryAfter)
+
+	*now = now.Add(9010 * time.Millisecond)
+	assert.Nil(t, l.AllowPublish("n", "c", "s", 1))
+}
+
+func TestSweepShouldStopWhenContextIsDone(t *testing.T) {
+	l, _ := newTestLimiter(Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		l.sweep(ctx)
+		close(stopped)
+	}()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("sweep did not stop")
+	}
+}
@@ -0,0 +1,174 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownVersion = errors.New("schema version is not registered")
+	ErrInvalidSchema  = errors.New("invalid schema")
+)
+
+// Store persists the registered schemas
+type Store interface {
+	SaveSchema(ctx context.Context, s Schema) error
+	// Schemas returns every saved schema
+	Schemas(ctx context.Context) ([]Schema, error)
+	// DeleteSchemas removes every version of the schema of subject
+	DeleteSchemas(ctx context.Context, subject string) error
+}
+
+type compiledSchema struct {
+	Schema
+	validator Validator
+}
+
+// Registry keeps the compiled schemas of every subject, ordered by version.
+// A nil *Registry has no schemas.
+type Registry struct {
+	lock     sync.RWMutex
+	store    Store
+	subjects map[string][]compiledSchema
+}
+
+// NewRegistry loads and compiles the schemas saved in store
+func NewRegistry(ctx context.Context, store Store) (*Registry, error) {
+	saved, err := store.Schemas(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not load schemas: %w", err)
+	}
+	sort.Slice(saved, func(i, j int) bool {
+		return saved[i].Version < saved[j].Version
+	})
+
+	r := &Registry{
+		store:    store,
+		subjects: make(map[string][]compiledSchema),
+	}
+	for _, s := range saved {
+		validator, err := Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile version %d of the schema of %q: %w", s.Version, s.Subject, err)
+		}
+		r.subjects[s.Subject] = append(r.subjects[s.Subject], compiledSchema{Schema: s, validator: validator})
+	}
+
+	return r, nil
+}
+
+// Register saves s as the latest version of the schema of its subject,
+// if it compiles, and returns it with its version
+func (r *Registry) Register(ctx context.Context, s Schema) (Schema, error) {
+	if s.Subject == "" {
+		return Schema{}, fmt.Errorf("%w: no subject", ErrInvalidSchema)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	versions := r.subjects[s.Subject]
+	s.Version = len(versions) + 1
+	s.CreatedAt = time.Now()
+	validator, err := Compile(s)
+	if err != nil {
+		return Schema{}, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+
+	if err := r.store.SaveSchema(ctx, s); err != nil {
+		return Schema{}, fmt.Errorf("could not save schema: %w", err)
+	}
+	r.subjects[s.Subject] = append(versions, compiledSchema{Schema: s, validator: validator})
+
+	return s, nil
+}
+
+// Delete removes every version of the schema of subject,
+// whose bodies are not validated anymore
+func (r *Registry) Delete(ctx context.Context, subject string) error {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.subjects[subject]; !ok {
+		return nil
+	}
+	if err := r.store.DeleteSchemas(ctx, subject); err != nil {
+		return fmt.Errorf("could not delete schemas: %w", err)
+	}
+	delete(r.subjects, subject)
+
+	return nil
+}
+
+// Subjects returns the subjects having a schema
+func (r *Registry) Subjects() []string {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	subjects := make([]string, 0, len(r.subjects))
+	for subject := range r.subjects {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+// List returns the schemas of subject, ordered by version
+func (r *Registry) List(subject string) []Schema {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	versions := r.subjects[subject]
+	schemas := make([]Schema, len(versions))
+	for i, v := range versions {
+		schemas[i] = v.Schema
+	}
+	return schemas
+}
+
+// Validate validates body against the given version of the schema of subject,
+// or its latest version if version is 0, and returns the version used.
+// Bodies of subjects without a schema are valid, with version 0.
+func (r *Registry) Validate(subject string, version int, body []byte) (int, error) {
+	if r == nil {
+		if version != 0 {
+			return 0, fmt.Errorf("%w: %q has no schema", ErrUnknownVersion, subject)
+		}
+		return 0, nil
+	}
+
+	r.lock.RLock()
+	versions := r.subjects[subject]
+	r.lock.RUnlock()
+
+	if version == 0 {
+		if len(versions) == 0 {
+			return 0, nil
+		}
+		version = len(versions)
+	}
+	if version < 0 || version > len(versions) {
+		return 0, fmt.Errorf("%w: %q has no schema version %d", ErrUnknownVersion, subject, version)
+	}
+
+	if err := versions[version-1].validator.Validate(body); err != nil {
+		return version, err
+	}
+	return version, nil
+}
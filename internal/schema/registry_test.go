@@ -0,0 +1,131 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"testing"
+	"time"
+)
+
+type memoryStore struct {
+	schemas []Schema
+}
+
+func (m *memoryStore) SaveSchema(_ context.Context, s Schema) error {
+	m.schemas = append(m.schemas, s)
+	return nil
+}
+
+func (m *memoryStore) Schemas(_ context.Context) ([]Schema, error) {
+	return m.schemas, nil
+}
+
+func (m *memoryStore) DeleteSchemas(_ context.Context, subject string) error {
+	kept := m.schemas[:0]
+	for _, s := range m.schemas {
+		if s.Subject != subject {
+			kept = append(kept, s)
+		}
+	}
+	m.schemas = kept
+	return nil
+}
+
+var ctx = context.Background()
+
+const userSchema = `{
+	"type": "object",
+	"properties": {"name": {"type": "string"}, "age": {"type": "integer"}},
+	"required": ["name"]
+}`
+
+func TestJSONSchemaShouldValidateBodies(t *testing.T) {
+	r, err := NewRegistry(ctx, &memoryStore{})
+	assert.Nil(t, err)
+
+	version, err := r.Validate("users", 0, []byte("anything"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, version)
+
+	registered, err := r.Register(ctx, Schema{Subject: "users", Format: JSONSchema, Definition: []byte(userSchema)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, registered.Version)
+
+	version, err = r.Validate("users", 0, []byte(`{"name": "ali", "age": 30}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+
+	for _, body := range []string{`{"age": 30}`, `{"name": 7}`, `not json`, `{"name": "ali"} {}`} {
+		_, err = r.Validate("users", 0, []byte(body))
+		assert.NotNil(t, err, body)
+	}
+}
+
+func TestJSONSchemaShouldNotLoadOtherDocuments(t *testing.T) {
+	r, err := NewRegistry(ctx, &memoryStore{})
+	assert.Nil(t, err)
+
+	for _, ref := range []string{"http://127.0.0.1:1/user.json", "file:///etc/passwd", "other.json"} {
+		definition := `{"$ref": "` + ref + `"}`
+		_, err = r.Register(ctx, Schema{Subject: "users", Format: JSONSchema, Definition: []byte(definition)})
+		assert.ErrorContains(t, err, "not allowed", ref)
+	}
+
+	local := `{"$schema": "https://json-schema.org/draft/2020-12/schema", "$defs": {"name": {"type": "string"}}, "$ref": "#/$defs/name"}`
+	_, err = r.Register(ctx, Schema{Subject: "users", Format: JSONSchema, Definition: []byte(local)})
+	assert.Nil(t, err)
+}
+
+func TestRegistryShouldKeepVersions(t *testing.T) {
+	store := &memoryStore{}
+	r, err := NewRegistry(ctx, store)
+	assert.Nil(t, err)
+
+	_, err = r.Register(ctx, Schema{Subject: "users", Format: JSONSchema, Definition: []byte(userSchema)})
+	assert.Nil(t, err)
+	v2, err := r.Register(ctx, Schema{Subject: "users", Format: JSONSchema, Definition: []byte(`{"type": "string"}`)})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, v2.Version)
+
+	_, err = r.Register(ctx, Schema{Subject: "users", Format: JSONSchema, Definition: []byte(`{"type": 12}`)})
+	assert.True(t, errors.Is(err, ErrInvalidSchema))
+
+	// a registry loaded from the same store has the same versions
+	r, err = NewRegistry(ctx, store)
+	assert.Nil(t, err)
+	assert.Len(t, r.List("users"), 2)
+
+	version, err := r.Validate("users", 1, []byte(`{"name": "ali"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+	_, err = r.Validate("users", 0, []byte(`{"name": "ali"}`))
+	assert.NotNil(t, err)
+	_, err = r.Validate("users", 3, []byte(`"ali"`))
+	assert.True(t, errors.Is(err, ErrUnknownVersion))
+}
+
+func TestProtobufSchemaShouldValidateBodies(t *testing.T) {
+	definition, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(durationpb.File_google_protobuf_duration_proto)},
+	})
+	assert.Nil(t, err)
+
+	r, err := NewRegistry(ctx, &memoryStore{})
+	assert.Nil(t, err)
+	_, err = r.Register(ctx, Schema{Subject: "timeouts", Format: Protobuf, Definition: definition, MessageName: "google.protobuf.Unknown"})
+	assert.True(t, errors.Is(err, ErrInvalidSchema))
+	_, err = r.Register(ctx, Schema{Subject: "timeouts", Format: Protobuf, Definition: definition, MessageName: "google.protobuf.Duration"})
+	assert.Nil(t, err)
+
+	body, _ := proto.Marshal(durationpb.New(time.Minute))
+	_, err = r.Validate("timeouts", 0, body)
+	assert.Nil(t, err)
+
+	_, err = r.Validate("timeouts", 0, []byte{0xff, 0xff})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,126 @@
+// Package schema keeps the versioned schemas the bodies published
+// to a subject are validated against
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"time"
+)
+
+// Formats of the schema definitions
+const (
+	JSONSchema = "json_schema"
+	Protobuf   = "protobuf"
+)
+
+type Schema struct {
+	Subject string
+	// Version starts from 1 and increases with every schema
+	// registered for Subject
+	Version int
+	Format  string
+	// Definition is a JSON Schema document, or a serialized
+	// google.protobuf.FileDescriptorSet for protobuf schemas
+	Definition []byte
+	// MessageName is the full name of the protobuf message
+	// bodies are decoded as, for protobuf schemas
+	MessageName string
+	CreatedAt   time.Time
+}
+
+// Validator validates bodies against a compiled Schema
+type Validator interface {
+	Validate(body []byte) error
+}
+
+// Compile checks that s is a valid schema and returns its Validator
+func Compile(s Schema) (Validator, error) {
+	switch s.Format {
+	case JSONSchema:
+		return compileJSONSchema(s)
+	case Protobuf:
+		return compileProtobuf(s)
+	default:
+		return nil, fmt.Errorf("unknown schema format %q, expected %s or %s", s.Format, JSONSchema, Protobuf)
+	}
+}
+
+type jsonSchemaValidator struct {
+	schema *jsonschema.Schema
+}
+
+func compileJSONSchema(s Schema) (Validator, error) {
+	url := fmt.Sprintf("%s.v%d.json", s.Subject, s.Version)
+	compiler := jsonschema.NewCompiler()
+	// schemas are registered by clients, so they may only refer to themselves
+	// and the standard meta schemas, and never make the broker read files or
+	// call other servers
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("loading %q is not allowed, schemas can't refer to other documents", url)
+	}
+	if err := compiler.AddResource(url, bytes.NewReader(s.Definition)); err != nil {
+		return nil, fmt.Errorf("invalid json schema: %w", err)
+	}
+	compiled, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json schema: %w", err)
+	}
+
+	return jsonSchemaValidator{schema: compiled}, nil
+}
+
+func (v jsonSchemaValidator) Validate(body []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("body is not valid json: %w", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("body is not valid json: unexpected data after the top-level value")
+	}
+
+	return v.schema.Validate(value)
+}
+
+type protobufValidator struct {
+	descriptor protoreflect.MessageDescriptor
+}
+
+func compileProtobuf(s Schema) (Validator, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(s.Definition, &set); err != nil {
+		return nil, fmt.Errorf("definition is not a serialized FileDescriptorSet: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptors: %w", err)
+	}
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(s.MessageName))
+	if err != nil {
+		return nil, fmt.Errorf("message %q is not found in the descriptors: %w", s.MessageName, err)
+	}
+	message, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a message", s.MessageName)
+	}
+
+	return protobufValidator{descriptor: message}, nil
+}
+
+func (v protobufValidator) Validate(body []byte) error {
+	// unmarshalling also reports the missing required fields of proto2 messages
+	if err := proto.Unmarshal(body, dynamicpb.NewMessage(v.descriptor)); err != nil {
+		return fmt.Errorf("body is not a valid %s: %w", v.descriptor.FullName(), err)
+	}
+	return nil
+}
@@ -3,10 +3,12 @@ package batch
 import (
 	"context"
 	"fmt"
+	"github.com/MeysamBavi/go-broker/internal/logging"
 	"github.com/MeysamBavi/go-broker/internal/tracing"
 	"github.com/MeysamBavi/go-broker/pkg/broker"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"time"
 )
 
@@ -16,13 +18,15 @@ type impl struct {
 	writer     Writer
 	config     Config
 	itemStream chan *Item
+	logger     *slog.Logger
 }
 
-func NewHandler(config Config, writer Writer, tp trace.TracerProvider) Handler {
+func NewHandler(config Config, writer Writer, tp trace.TracerProvider, logger *slog.Logger) Handler {
 	h := &impl{
 		writer:     writer,
 		config:     config,
 		itemStream: make(chan *Item, 1),
+		logger:     logger,
 	}
 	go h.flusher(tp.Tracer(packageName + ".Handler"))
 
@@ -50,6 +54,10 @@ func (h *impl) flusher(tracer trace.Tracer) {
 		err := h.writer(ctx, buffer)
 
 		tracing.SetStatusAndError(span, err)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "could not write batch",
+				slog.Int("batchSize", len(buffer)), logging.Error(err))
+		}
 
 		for _, item := range buffer {
 			if item.Err == nil {
@@ -2,17 +2,31 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/schema"
 	"github.com/MeysamBavi/go-broker/internal/store/batch"
 	"github.com/MeysamBavi/go-broker/pkg/broker"
 	"github.com/gocql/gocql"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gocql/gocql/otelgocql"
 	"go.opentelemetry.io/otel/trace"
-	"log"
+	"log/slog"
 	"math"
+	"strings"
 	"time"
 )
 
+const (
+	scheduledBucket = 0
+)
+
+// latestKey identifies the latest message of a key in a subject
+type latestKey struct {
+	subject string
+	key     string
+}
+
 type CassandraConfig struct {
 	Host     string `config:"host"`
 	Keyspace string `config:"keyspace"`
@@ -23,9 +37,10 @@ type cassandra struct {
 	config       CassandraConfig
 	sequences    Sequence
 	batchHandler batch.Handler
+	logger       *slog.Logger
 }
 
-func NewCassandra(config CassandraConfig, sequence Sequence, batchHandlerProvider func(batch.Writer) batch.Handler, tracerProvider trace.TracerProvider) (Message, error) {
+func NewCassandra(config CassandraConfig, sequence Sequence, batchHandlerProvider func(batch.Writer) batch.Handler, tracerProvider trace.TracerProvider, logger *slog.Logger) (Message, error) {
 	ctx := context.Background()
 	{
 		cluster := gocql.NewCluster(config.Host)
@@ -40,7 +55,7 @@ func NewCassandra(config CassandraConfig, sequence Sequence, batchHandlerProvide
 			return nil, err
 		}
 
-		log.Printf("keyspace %q created\n", config.Keyspace)
+		logger.Info("keyspace created", slog.String("keyspace", config.Keyspace))
 	}
 
 	cluster := gocql.NewCluster(config.Host)
@@ -56,6 +71,7 @@ func NewCassandra(config CassandraConfig, sequence Sequence, batchHandlerProvide
 		session:   session,
 		config:    config,
 		sequences: sequence,
+		logger:    logger,
 	}
 	c.batchHandler = batchHandlerProvider(c.saveBatch)
 
@@ -75,7 +91,44 @@ func (c *cassandra) init() error {
 	ctx := context.Background()
 
 	if err := c.session.Query(
-		"CREATE TABLE IF NOT EXISTS messages_by_subject_and_id (subject text, id int, body text, expiration duration, PRIMARY KEY (subject, id));",
+		"CREATE TABLE IF NOT EXISTS messages_by_subject_and_id (subject text, id int, body text, expiration duration, headers map<text, text>, key text, schema_version int, PRIMARY KEY (subject, id));",
+	).WithContext(ctx).Exec(); err != nil {
+		return err
+	}
+
+	// tables created by older versions lack the newer columns
+	for _, column := range []string{"headers map<text, text>", "key text", "schema_version int"} {
+		if err := c.session.Query(
+			"ALTER TABLE messages_by_subject_and_id ADD " + column + ";",
+		).WithContext(ctx).Exec(); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+
+	if err := c.session.Query(
+		"CREATE TABLE IF NOT EXISTS latest_ids_by_key (subject text, key text, id int, PRIMARY KEY (subject, key));",
+	).WithContext(ctx).Exec(); err != nil {
+		return err
+	}
+
+	// scheduled messages are few and always read all together,
+	// so they all share bucket 0 as their partition key
+	if err := c.session.Query(
+		"CREATE TABLE IF NOT EXISTS scheduled_messages (bucket int, subject text, id int, body text, expiration duration, deliver_at timestamp, headers map<text, text>, key text, schema_version int, PRIMARY KEY (bucket, subject, id));",
+	).WithContext(ctx).Exec(); err != nil {
+		return err
+	}
+
+	for _, column := range []string{"headers map<text, text>", "key text", "schema_version int"} {
+		if err := c.session.Query(
+			"ALTER TABLE scheduled_messages ADD " + column + ";",
+		).WithContext(ctx).Exec(); err != nil && !isColumnExistsError(err) {
+			return err
+		}
+	}
+
+	if err := c.session.Query(
+		"CREATE TABLE IF NOT EXISTS schemas (subject text, version int, format text, definition blob, message_name text, created_at timestamp, PRIMARY KEY (subject, version));",
 	).WithContext(ctx).Exec(); err != nil {
 		return err
 	}
@@ -83,6 +136,14 @@ func (c *cassandra) init() error {
 	return nil
 }
 
+// isColumnExistsError reports whether err is returned
+// for adding a column that already exists
+func isColumnExistsError(err error) bool {
+	var requestErr gocql.RequestError
+	return errors.As(err, &requestErr) && requestErr.Code() == gocql.ErrCodeInvalid &&
+		strings.Contains(requestErr.Message(), "conflicts with an existing column")
+}
+
 func (c *cassandra) loadSequences(ctx context.Context) error {
 	iter := c.session.Query(
 		"SELECT subject, MAX(id) FROM messages_by_subject_and_id GROUP BY subject ;",
@@ -94,6 +155,7 @@ func (c *cassandra) loadSequences(ctx context.Context) error {
 		if err := c.sequences.Load(ctx, subject, int32(lastId)); err != nil {
 			return err
 		}
+		c.logger.DebugContext(ctx, "sequence loaded", logging.Subject(subject), logging.MessageId(lastId))
 	}
 
 	return iter.Close()
@@ -108,12 +170,12 @@ func (c *cassandra) GetMessage(ctx context.Context, subject string, id int) (*br
 	var expiration gocql.Duration
 
 	if err := c.session.Query(
-		"SELECT id, body, expiration FROM messages_by_subject_and_id WHERE subject=? AND id=?;",
+		"SELECT id, body, expiration, headers, key, schema_version FROM messages_by_subject_and_id WHERE subject=? AND id=?;",
 		subject,
 		id,
-	).WithContext(ctx).Scan(&message.Id, &message.Body, &expiration); err != nil {
+	).WithContext(ctx).Scan(&message.Id, &message.Body, &expiration, &message.Headers, &message.Key, &message.SchemaVersion); err != nil {
 		if err == gocql.ErrNotFound {
-			return nil, ErrExpired
+			return nil, missingMessageError(ctx, c.sequences, subject, id)
 		}
 		return nil, err
 	}
@@ -122,14 +184,246 @@ func (c *cassandra) GetMessage(ctx context.Context, subject string, id int) (*br
 	return &message, nil
 }
 
+func (c *cassandra) GetMessageByKey(ctx context.Context, subject string, key string) (*broker.Message, error) {
+	var id int
+	if err := c.session.Query(
+		"SELECT id FROM latest_ids_by_key WHERE subject=? AND key=?;",
+		subject,
+		key,
+	).WithContext(ctx).Scan(&id); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+
+	return c.GetMessage(ctx, subject, id)
+}
+
+// Compact pages through the messages of subject and removes the
+// superseded ones in bounded batches, which all hit the partition of subject
+func (c *cassandra) Compact(ctx context.Context, subject string) (int, error) {
+	latest := make(map[string]int)
+	iter := c.session.Query(
+		"SELECT key, id FROM latest_ids_by_key WHERE subject=?;",
+		subject,
+	).WithContext(ctx).PageSize(compactionChunk).Iter()
+	var key string
+	var id int
+	for iter.Scan(&key, &id) {
+		latest[key] = id
+	}
+	if err := iter.Close(); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	deleteBatch := c.session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+	flush := func() error {
+		if deleteBatch.Size() == 0 {
+			return nil
+		}
+		if err := c.session.ExecuteBatch(deleteBatch); err != nil {
+			return err
+		}
+		removed += deleteBatch.Size()
+		deleteBatch = c.session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		return nil
+	}
+
+	iter = c.session.Query(
+		"SELECT id, key FROM messages_by_subject_and_id WHERE subject=?;",
+		subject,
+	).WithContext(ctx).PageSize(compactionChunk).Iter()
+	for iter.Scan(&id, &key) {
+		if key == "" || latest[key] <= id {
+			continue
+		}
+		deleteBatch.Query(
+			"DELETE FROM messages_by_subject_and_id WHERE subject=? AND id=?;",
+			subject,
+			id,
+		)
+		if deleteBatch.Size() >= compactionChunk {
+			if err := flush(); err != nil {
+				iter.Close()
+				return removed, err
+			}
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return removed, err
+	}
+
+	return removed, flush()
+}
+
+func (c *cassandra) Subjects(ctx context.Context) ([]string, error) {
+	return c.sequences.Subjects(ctx)
+}
+
+func (c *cassandra) count(ctx context.Context, subject string) (int, error) {
+	var count int
+	err := c.session.Query(
+		"SELECT COUNT(*) FROM messages_by_subject_and_id WHERE subject=?;",
+		subject,
+	).WithContext(ctx).Scan(&count)
+
+	return count, err
+}
+
+func (c *cassandra) Stats(ctx context.Context, subject string) (SubjectStats, error) {
+	count, err := c.count(ctx, subject)
+	if err != nil {
+		return SubjectStats{}, err
+	}
+
+	lastId, err := c.sequences.Current(ctx, subject)
+	if err != nil {
+		return SubjectStats{}, err
+	}
+
+	return SubjectStats{
+		Messages: count,
+		LatestId: int(lastId),
+	}, nil
+}
+
+func (c *cassandra) Purge(ctx context.Context, subject string) (int, error) {
+	count, err := c.count(ctx, subject)
+	if err != nil {
+		return 0, err
+	}
+
+	err = c.session.Query(
+		"DELETE FROM messages_by_subject_and_id WHERE subject=?;",
+		subject,
+	).WithContext(ctx).Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	err = c.session.Query(
+		"DELETE FROM latest_ids_by_key WHERE subject=?;",
+		subject,
+	).WithContext(ctx).Exec()
+
+	return count, err
+}
+
+func (c *cassandra) Add(ctx context.Context, subject string, message *broker.Message) error {
+	return c.session.Query(
+		"INSERT INTO scheduled_messages (bucket, subject, id, body, expiration, deliver_at, headers, key, schema_version) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		scheduledBucket,
+		subject,
+		message.Id,
+		message.Body,
+		message.Expiration,
+		message.DeliverAt,
+		message.Headers,
+		message.Key,
+		message.SchemaVersion,
+	).WithContext(ctx).Exec()
+}
+
+func (c *cassandra) Remove(ctx context.Context, subject string, id int) error {
+	return c.session.Query(
+		"DELETE FROM scheduled_messages WHERE bucket=? AND subject=? AND id=?;",
+		scheduledBucket,
+		subject,
+		id,
+	).WithContext(ctx).Exec()
+}
+
+func (c *cassandra) Pending(ctx context.Context) ([]ScheduledMessage, error) {
+	iter := c.session.Query(
+		"SELECT subject, id, body, expiration, deliver_at, headers, key, schema_version FROM scheduled_messages WHERE bucket=?;",
+		scheduledBucket,
+	).WithContext(ctx).Iter()
+
+	var pending []ScheduledMessage
+	var scheduled ScheduledMessage
+	var expiration gocql.Duration
+	for iter.Scan(&scheduled.Subject, &scheduled.Message.Id, &scheduled.Message.Body, &expiration, &scheduled.Message.DeliverAt, &scheduled.Message.Headers, &scheduled.Message.Key, &scheduled.Message.SchemaVersion) {
+		scheduled.Message.Expiration = time.Duration(expiration.Nanoseconds)
+		pending = append(pending, scheduled)
+		scheduled = ScheduledMessage{}
+	}
+
+	return pending, iter.Close()
+}
+
+func (c *cassandra) SaveSchema(ctx context.Context, s schema.Schema) error {
+	return c.session.Query(
+		"INSERT INTO schemas (subject, version, format, definition, message_name, created_at) VALUES (?, ?, ?, ?, ?, ?);",
+		s.Subject,
+		s.Version,
+		s.Format,
+		s.Definition,
+		s.MessageName,
+		s.CreatedAt,
+	).WithContext(ctx).Exec()
+}
+
+func (c *cassandra) DeleteSchemas(ctx context.Context, subject string) error {
+	return c.session.Query(
+		"DELETE FROM schemas WHERE subject=?;",
+		subject,
+	).WithContext(ctx).Exec()
+}
+
+func (c *cassandra) Schemas(ctx context.Context) ([]schema.Schema, error) {
+	iter := c.session.Query(
+		"SELECT subject, version, format, definition, message_name, created_at FROM schemas;",
+	).WithContext(ctx).Iter()
+
+	var schemas []schema.Schema
+	var s schema.Schema
+	for iter.Scan(&s.Subject, &s.Version, &s.Format, &s.Definition, &s.MessageName, &s.CreatedAt) {
+		schemas = append(schemas, s)
+		s = schema.Schema{}
+	}
+
+	return schemas, iter.Close()
+}
+
+func (c *cassandra) Delete(ctx context.Context, subject string) (int, error) {
+	deleted, err := c.Purge(ctx, subject)
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, c.sequences.Delete(ctx, subject)
+}
+
+func (c *cassandra) Trim(ctx context.Context, subject string, beforeId int) error {
+	return c.session.Query(
+		"DELETE FROM messages_by_subject_and_id WHERE subject=? AND id<?;",
+		subject,
+		beforeId,
+	).WithContext(ctx).Exec()
+}
+
+func (c *cassandra) CheckHealth(ctx context.Context) error {
+	if c.session.Closed() {
+		return errors.New("cassandra session is closed")
+	}
+
+	var version string
+	return c.session.Query("SELECT release_version FROM system.local;").WithContext(ctx).Scan(&version)
+}
+
 func (c *cassandra) saveBatch(ctx context.Context, values []*batch.Item) error {
 	insertBatch := c.session.NewBatch(gocql.UnloggedBatch)
+	latest := make(map[latestKey]int)
 	for _, item := range values {
 		newId, err := c.sequences.CreateNewId(ctx, item.Subject)
 		if err != nil {
 			return err
 		}
 		item.Message.Id = int(newId)
+		if item.Message.Key != "" {
+			latest[latestKey{item.Subject, item.Message.Key}] = int(newId)
+		}
 
 		expirationSeconds := int(math.Round(item.Message.Expiration.Seconds()))
 		if expirationSeconds <= 0 {
@@ -137,14 +431,28 @@ func (c *cassandra) saveBatch(ctx context.Context, values []*batch.Item) error {
 		}
 
 		insertBatch.WithContext(ctx).Query(
-			"INSERT INTO messages_by_subject_and_id (subject, id, body, expiration) VALUES (?, ?, ?, ?) USING TTL ?;",
+			"INSERT INTO messages_by_subject_and_id (subject, id, body, expiration, headers, key, schema_version) VALUES (?, ?, ?, ?, ?, ?, ?) USING TTL ?;",
 			item.Subject,
 			newId,
 			item.Message.Body,
 			item.Message.Expiration,
+			item.Message.Headers,
+			item.Message.Key,
+			item.Message.SchemaVersion,
 			expirationSeconds,
 		)
 	}
 
+	// a key is written once per batch, as writes with the same
+	// timestamp do not resolve to the latest one
+	for k, id := range latest {
+		insertBatch.WithContext(ctx).Query(
+			"INSERT INTO latest_ids_by_key (subject, key, id) VALUES (?, ?, ?);",
+			k.subject,
+			k.key,
+			id,
+		)
+	}
+
 	return c.session.ExecuteBatch(insertBatch)
 }
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"fmt"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/compression"
+)
+
+// compressedPrefix starts the envelope of every compressed body,
+// which is tagged with the name of the algorithm
+const compressedPrefix = "\x1bz:"
+
+type CompressionConfig struct {
+	// Algorithm is one of gzip, snappy and zstd; empty disables compression
+	Algorithm string `config:"algorithm"`
+	// MinSize is the body size in bytes from which bodies are compressed
+	MinSize int `config:"min_size"`
+}
+
+type compressor struct {
+	codec   compression.Codec
+	minSize int
+}
+
+// MessageWithCompression compresses the bodies saved in core, if they are
+// large enough and the compressed body is smaller. Compressed bodies are
+// decompressed on read with the algorithm they were written with, so the
+// algorithm can be changed without migrating stored messages.
+//
+// Bodies compressed before being encrypted leak how well they compress
+// through their encrypted size, which can reveal parts of a body to
+// whoever can both put content in it and read the stored sizes.
+func MessageWithCompression(core Message, config CompressionConfig) (Message, error) {
+	codec, err := compression.Get(config.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &withBodyTransform{
+		Message: core,
+		transform: compressor{
+			codec:   codec,
+			minSize: config.MinSize,
+		},
+	}, nil
+}
+
+func (c compressor) encode(_ string, message *broker.Message) (*broker.Message, error) {
+	looksCompressed := envelope(compressedPrefix).holds(message.Body)
+	if len(message.Body) < c.minSize && !looksCompressed {
+		return message, nil
+	}
+
+	compressed, err := c.codec.Compress([]byte(message.Body))
+	if err != nil {
+		return nil, fmt.Errorf("could not compress message body: %w", err)
+	}
+
+	body := envelope(compressedPrefix).wrap(c.codec.Name(), compressed)
+	if len(body) >= len(message.Body) && !looksCompressed {
+		return message, nil
+	}
+	return withBody(message, body), nil
+}
+
+func (compressor) decode(_ string, message *broker.Message) (*broker.Message, error) {
+	if !envelope(compressedPrefix).holds(message.Body) {
+		return message, nil
+	}
+
+	name, compressed, err := envelope(compressedPrefix).unwrap(message.Body)
+	if err != nil {
+		return nil, fmt.Errorf("compressed body of message %d: %w", message.Id, err)
+	}
+	codec, err := compression.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress body of message %d: %w", message.Id, err)
+	}
+	body, err := codec.Decompress(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress body of message %d: %w", message.Id, err)
+	}
+
+	return withBody(message, string(body)), nil
+}
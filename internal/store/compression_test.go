@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/compression"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressionShouldBeTransparent(t *testing.T) {
+	ctx := context.Background()
+	core := NewInMemoryMessage(GetDefaultTimeProvider())
+	compressed, err := MessageWithCompression(core, CompressionConfig{Algorithm: compression.Zstd, MinSize: 64})
+	assert.Nil(t, err)
+
+	large := strings.Repeat(`{"user":"ali","action":"login"}`, 100)
+	bodies := []string{"small", large, compressedPrefix + "looks compressed"}
+	for _, body := range bodies {
+		msg := &broker.Message{Body: body, Expiration: time.Minute, Key: "k"}
+		assert.Nil(t, compressed.SaveMessage(ctx, "ali", msg))
+		assert.Equal(t, body, msg.Body)
+
+		stored, err := core.GetMessage(ctx, "ali", msg.Id)
+		assert.Nil(t, err)
+		assert.Equal(t, body == "small", stored.Body == body)
+
+		fetched, err := compressed.GetMessage(ctx, "ali", msg.Id)
+		assert.Nil(t, err)
+		assert.Equal(t, body, fetched.Body)
+	}
+
+	stored, _ := core.GetMessage(ctx, "ali", 2)
+	assert.Less(t, len(stored.Body), len(large)/10)
+
+	latest, err := compressed.GetMessageByKey(ctx, "ali", "k")
+	assert.Nil(t, err)
+	assert.Equal(t, bodies[2], latest.Body)
+}
+
+func TestCompressionShouldRejectUnknownAlgorithm(t *testing.T) {
+	_, err := MessageWithCompression(NewInMemoryMessage(GetDefaultTimeProvider()), CompressionConfig{Algorithm: "lz4"})
+	assert.NotNil(t, err)
+}
@@ -2,11 +2,53 @@ package store
 
 import "github.com/MeysamBavi/go-broker/internal/store/batch"
 
+// Names of the storage backends, as referred to by subject policies
+const (
+	BackendMemory    = "memory"
+	BackendCassandra = "cassandra"
+	BackendPostgres  = "postgres"
+)
+
+// Config enables one or more storage backends; when several are
+// enabled, subjects are routed to the backend named by their policy,
+// or to Default
 type Config struct {
+	Default      string          `config:"default"`
 	UseInMemory  bool            `config:"in_memory"`
 	UseCassandra bool            `config:"use_cassandra"`
 	Cassandra    CassandraConfig `config:"cassandra"`
 	UsePostgres  bool            `config:"use_postgres"`
 	Postgres     PostgresConfig  `config:"postgres"`
 	Batch        batch.Config    `config:"batch"`
+	// Compression applies to the bodies stored in Postgres and Cassandra
+	Compression CompressionConfig `config:"compression"`
+	// Encryption applies to the bodies stored in Postgres and Cassandra,
+	// of the subjects whose policy is encrypted
+	Encryption EncryptionConfig `config:"encryption"`
+}
+
+// Backends returns the names of the enabled storage backends
+func (c Config) Backends() []string {
+	var backends []string
+	if c.UseInMemory {
+		backends = append(backends, BackendMemory)
+	}
+	if c.UseCassandra {
+		backends = append(backends, BackendCassandra)
+	}
+	if c.UsePostgres {
+		backends = append(backends, BackendPostgres)
+	}
+	return backends
+}
+
+// Backend returns the name of the default storage backend
+func (c Config) Backend() string {
+	if c.Default != "" {
+		return c.Default
+	}
+	if backends := c.Backends(); len(backends) == 1 {
+		return backends[0]
+	}
+	return ""
 }
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix starts the envelope of every encrypted body, which is
+// tagged with the id of the key and holds the nonce and the sealed body
+const encryptedPrefix = "\x1be:"
+
+type EncryptionConfig struct {
+	Enabled bool `config:"enabled"`
+	// KeyringFile is a json file like
+	// {"current": "2024-02", "keys": {"2024-01": "<base64 key>", "2024-02": "<base64 key>"}}
+	// with 16, 24 or 32 byte keys. New bodies are encrypted with the current
+	// key; older keys are kept to read the messages encrypted before a rotation.
+	KeyringFile string `config:"keyring_file"`
+}
+
+// Keyring holds the AES-GCM keys bodies are encrypted with, by their id
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+type keyringFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyring: %w", err)
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse keyring: %w", err)
+	}
+
+	keyring := &Keyring{
+		current: file.Current,
+		keys:    make(map[string]cipher.AEAD, len(file.Keys)),
+	}
+	for id, encoded := range file.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q in keyring", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q of keyring is not base64: %w", id, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in keyring: %w", id, err)
+		}
+		keyring.keys[id], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in keyring: %w", id, err)
+		}
+	}
+	if _, ok := keyring.keys[keyring.current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the keyring", keyring.current)
+	}
+
+	return keyring, nil
+}
+
+// encrypt seals body with the current key. subject is authenticated
+// along with it, so bodies can't be moved between subjects.
+func (k *Keyring) encrypt(subject string, body string) (string, error) {
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(body)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(body), []byte(subject))
+
+	return envelope(encryptedPrefix).wrap(k.current, sealed), nil
+}
+
+// decrypt opens body if it's encrypted, and returns it as it is otherwise
+func (k *Keyring) decrypt(subject string, body string) (string, error) {
+	if !envelope(encryptedPrefix).holds(body) {
+		return body, nil
+	}
+
+	id, sealed, err := envelope(encryptedPrefix).unwrap(body)
+	if err != nil {
+		return "", fmt.Errorf("encrypted body: %w", err)
+	}
+	aead, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("body is encrypted with key %q, which is not in the keyring", id)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted body")
+	}
+	opened, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(subject))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt body with key %q: %w", id, err)
+	}
+
+	return string(opened), nil
+}
+
+// SubjectFilter reports whether a subject is subject to some behavior
+type SubjectFilter func(subject string) bool
+
+type encryptor struct {
+	keyring   *Keyring
+	encrypted SubjectFilter
+}
+
+func (e encryptor) encode(subject string, message *broker.Message) (*broker.Message, error) {
+	if !e.encrypted(subject) && !envelope(encryptedPrefix).holds(message.Body) {
+		return message, nil
+	}
+
+	body, err := e.keyring.encrypt(subject, message.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt message body: %w", err)
+	}
+	return withBody(message, body), nil
+}
+
+func (e encryptor) decode(subject string, message *broker.Message) (*broker.Message, error) {
+	if !envelope(encryptedPrefix).holds(message.Body) {
+		return message, nil
+	}
+
+	body, err := e.keyring.decrypt(subject, message.Body)
+	if err != nil {
+		return nil, fmt.Errorf("message %d of %s: %w", message.Id, subject, err)
+	}
+	return withBody(message, body), nil
+}
+
+// MessageWithEncryption encrypts the bodies of the subjects
+// selected by encrypted with AES-GCM before saving them in core.
+// Encrypted bodies are decrypted on read, whatever encrypted reports.
+func MessageWithEncryption(core Message, keyring *Keyring, encrypted SubjectFilter) Message {
+	return &withBodyTransform{
+		Message: core,
+		transform: encryptor{
+			keyring:   keyring,
+			encrypted: encrypted,
+		},
+	}
+}
+
+// ScheduleWithEncryption is MessageWithEncryption for the
+// messages kept in core until their delivery time
+func ScheduleWithEncryption(core Schedule, keyring *Keyring, encrypted SubjectFilter) Schedule {
+	return &scheduleWithBodyTransform{
+		Schedule: core,
+		transform: encryptor{
+			keyring:   keyring,
+			encrypted: encrypted,
+		},
+	}
+}
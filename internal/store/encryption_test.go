@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeKeyring(t *testing.T, current string, ids ...string) *Keyring {
+	keys := make(map[string]string)
+	for _, id := range ids {
+		key := []byte(strings.Repeat(id, 32)[:32])
+		keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	data, _ := json.Marshal(keyringFile{Current: current, Keys: keys})
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	assert.Nil(t, os.WriteFile(path, data, 0600))
+
+	keyring, err := LoadKeyring(path)
+	assert.Nil(t, err)
+	return keyring
+}
+
+func encryptSecrets(subject string) bool {
+	return strings.HasPrefix(subject, "secrets.")
+}
+
+func TestEncryptionShouldKeepOldMessagesReadableAfterRotation(t *testing.T) {
+	ctx := context.Background()
+	core := NewInMemoryMessage(GetDefaultTimeProvider())
+	before := MessageWithEncryption(core, writeKeyring(t, "a", "a"), encryptSecrets)
+	after := MessageWithEncryption(core, writeKeyring(t, "b", "a", "b"), encryptSecrets)
+
+	first := &broker.Message{Body: "first", Expiration: time.Minute}
+	assert.Nil(t, before.SaveMessage(ctx, "secrets.db", first))
+	assert.Equal(t, "first", first.Body)
+	second := &broker.Message{Body: "second", Expiration: time.Minute, Key: "k"}
+	assert.Nil(t, after.SaveMessage(ctx, "secrets.db", second))
+
+	for id, keyId := range map[int]string{first.Id: "a", second.Id: "b"} {
+		stored, err := core.GetMessage(ctx, "secrets.db", id)
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(stored.Body, encryptedPrefix+keyId+":"))
+	}
+
+	msg, err := after.GetMessage(ctx, "secrets.db", first.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", msg.Body)
+	msg, err = after.GetMessageByKey(ctx, "secrets.db", "k")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", msg.Body)
+
+	_, err = before.GetMessage(ctx, "secrets.db", second.Id)
+	assert.NotNil(t, err)
+}
+
+func TestEncryptionShouldOnlyApplyToSelectedSubjects(t *testing.T) {
+	ctx := context.Background()
+	core := NewInMemoryMessage(GetDefaultTimeProvider())
+	encrypted := MessageWithEncryption(core, writeKeyring(t, "a", "a"), encryptSecrets)
+
+	for _, body := range []string{"plain", encryptedPrefix + "a:looks encrypted"} {
+		msg := &broker.Message{Body: body, Expiration: time.Minute}
+		assert.Nil(t, encrypted.SaveMessage(ctx, "public", msg))
+
+		stored, _ := core.GetMessage(ctx, "public", msg.Id)
+		assert.Equal(t, body == "plain", stored.Body == body)
+		fetched, err := encrypted.GetMessage(ctx, "public", msg.Id)
+		assert.Nil(t, err)
+		assert.Equal(t, body, fetched.Body)
+	}
+}
+
+func TestScheduleEncryptionShouldRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	core := NewInMemorySchedule()
+	schedule := ScheduleWithEncryption(core, writeKeyring(t, "a", "a"), encryptSecrets)
+
+	assert.Nil(t, schedule.Add(ctx, "secrets.db", &broker.Message{Id: 1, Body: "later"}))
+
+	stored, _ := core.Pending(ctx)
+	assert.NotEqual(t, "later", stored[0].Message.Body)
+	pending, err := schedule.Pending(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "later", pending[0].Message.Body)
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"strings"
+)
+
+// envelope is the text form of a transformed body: the prefix, a tag
+// telling how the body was transformed, a colon and the base64 of the
+// transformed body. The stores keep bodies in text columns, which can't
+// hold arbitrary bytes.
+type envelope string
+
+func (e envelope) wrap(tag string, payload []byte) string {
+	return string(e) + tag + ":" + base64.StdEncoding.EncodeToString(payload)
+}
+
+// holds reports whether body is, or looks like, wrapped by e; bodies
+// looking like wrapped ones must be wrapped too, so that they are
+// not mistaken for one on read
+func (e envelope) holds(body string) bool {
+	return strings.HasPrefix(body, string(e))
+}
+
+func (e envelope) unwrap(body string) (tag string, payload []byte, err error) {
+	tag, encoded, ok := strings.Cut(strings.TrimPrefix(body, string(e)), ":")
+	if !ok {
+		return "", nil, fmt.Errorf("malformed body")
+	}
+	payload, err = base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed body: %w", err)
+	}
+	return tag, payload, nil
+}
+
+// withBody returns a copy of message with body; the callers keep using
+// the messages they save, e.g. to deliver them to subscribers, and
+// the in-memory store returns the messages it keeps
+func withBody(message *broker.Message, body string) *broker.Message {
+	copied := *message
+	copied.Body = body
+	return &copied
+}
+
+// bodyTransform changes the bodies of messages on their way to a store, and
+// reverts the change on their way back; both return message if unchanged
+type bodyTransform interface {
+	encode(subject string, message *broker.Message) (*broker.Message, error)
+	decode(subject string, message *broker.Message) (*broker.Message, error)
+}
+
+type withBodyTransform struct {
+	Message
+	transform bodyTransform
+}
+
+func (w *withBodyTransform) SaveMessage(ctx context.Context, subject string, message *broker.Message) error {
+	stored, err := w.transform.encode(subject, message)
+	if err != nil {
+		return err
+	}
+
+	err = w.Message.SaveMessage(ctx, subject, stored)
+	message.Id = stored.Id
+
+	return err
+}
+
+func (w *withBodyTransform) GetMessage(ctx context.Context, subject string, id int) (*broker.Message, error) {
+	message, err := w.Message.GetMessage(ctx, subject, id)
+	if err != nil {
+		return nil, err
+	}
+	return w.transform.decode(subject, message)
+}
+
+func (w *withBodyTransform) GetMessageByKey(ctx context.Context, subject string, key string) (*broker.Message, error) {
+	message, err := w.Message.GetMessageByKey(ctx, subject, key)
+	if err != nil {
+		return nil, err
+	}
+	return w.transform.decode(subject, message)
+}
+
+func (w *withBodyTransform) CheckHealth(ctx context.Context) error {
+	return CheckHealth(ctx, w.Message)
+}
+
+type scheduleWithBodyTransform struct {
+	Schedule
+	transform bodyTransform
+}
+
+func (s *scheduleWithBodyTransform) Add(ctx context.Context, subject string, message *broker.Message) error {
+	stored, err := s.transform.encode(subject, message)
+	if err != nil {
+		return err
+	}
+	return s.Schedule.Add(ctx, subject, stored)
+}
+
+func (s *scheduleWithBodyTransform) Pending(ctx context.Context) ([]ScheduledMessage, error) {
+	pending, err := s.Schedule.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pending {
+		message, err := s.transform.decode(pending[i].Subject, &pending[i].Message)
+		if err != nil {
+			return nil, err
+		}
+		pending[i].Message = *message
+	}
+	return pending, nil
+}
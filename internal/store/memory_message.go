@@ -23,6 +23,12 @@ func (i *idGen) nextId() int {
 type subjectStore struct {
 	idg      idGen
 	messages sync.Map
+	// trimmed is the id below which all messages are removed by Trim
+	trimmed     int
+	trimmedLock sync.Mutex
+	// keys holds the latest id of every message key
+	keys     map[string]int
+	keysLock sync.RWMutex
 }
 
 func (s *subjectStore) SaveMessage(message messageWithDeadline) error {
@@ -30,9 +36,35 @@ func (s *subjectStore) SaveMessage(message messageWithDeadline) error {
 	message.Message.Id = newId
 	s.messages.Store(newId, message)
 
+	if key := message.Key; key != "" {
+		s.keysLock.Lock()
+		if s.keys == nil {
+			s.keys = make(map[string]int)
+		}
+		if s.keys[key] < newId {
+			s.keys[key] = newId
+		}
+		s.keysLock.Unlock()
+	}
+
 	return nil
 }
 
+func (s *subjectStore) latestId(key string) (int, bool) {
+	s.keysLock.RLock()
+	defer s.keysLock.RUnlock()
+
+	id, ok := s.keys[key]
+	return id, ok
+}
+
+func (s *subjectStore) lastId() int {
+	s.idg.lock.Lock()
+	defer s.idg.lock.Unlock()
+
+	return s.idg.value
+}
+
 func (s *subjectStore) GetMessage(id int) (messageWithDeadline, bool) {
 	m, ok := s.messages.Load(id)
 	if !ok {
@@ -71,6 +103,10 @@ func (i *inMemoryMessage) GetMessage(ctx context.Context, subject string, id int
 
 	message, ok := ss.GetMessage(id)
 	if !ok {
+		// ids up to the last one were assigned and then purged
+		if id > 0 && id <= ss.lastId() {
+			return nil, ErrExpired
+		}
 		return nil, ErrInvalidId
 	}
 
@@ -85,3 +121,129 @@ func (i *inMemoryMessage) getSubjectStore(subject string) *subjectStore {
 	s, _ := i.subjects.LoadOrStore(subject, &subjectStore{})
 	return s.(*subjectStore)
 }
+
+func (i *inMemoryMessage) Subjects(_ context.Context) ([]string, error) {
+	subjects := make([]string, 0)
+	i.subjects.Range(func(key, value any) bool {
+		// subject stores are also created by fetches on unknown subjects
+		if value.(*subjectStore).lastId() > 0 {
+			subjects = append(subjects, key.(string))
+		}
+		return true
+	})
+
+	return subjects, nil
+}
+
+func (i *inMemoryMessage) Stats(_ context.Context, subject string) (SubjectStats, error) {
+	s, ok := i.subjects.Load(subject)
+	if !ok {
+		return SubjectStats{}, nil
+	}
+	ss := s.(*subjectStore)
+	currentTime := i.timeProvider.GetCurrentTime()
+
+	stats := SubjectStats{
+		LatestId: ss.lastId(),
+	}
+	ss.messages.Range(func(_, value any) bool {
+		if !currentTime.After(value.(messageWithDeadline).deadline) {
+			stats.Messages++
+		}
+		return true
+	})
+
+	return stats, nil
+}
+
+func (i *inMemoryMessage) Purge(_ context.Context, subject string) (int, error) {
+	s, ok := i.subjects.Load(subject)
+	if !ok {
+		return 0, nil
+	}
+	ss := s.(*subjectStore)
+
+	count := 0
+	ss.messages.Range(func(key, _ any) bool {
+		if _, loaded := ss.messages.LoadAndDelete(key); loaded {
+			count++
+		}
+		return true
+	})
+
+	ss.keysLock.Lock()
+	ss.keys = nil
+	ss.keysLock.Unlock()
+
+	return count, nil
+}
+
+func (i *inMemoryMessage) Delete(_ context.Context, subject string) (int, error) {
+	s, ok := i.subjects.LoadAndDelete(subject)
+	if !ok {
+		return 0, nil
+	}
+
+	count := 0
+	s.(*subjectStore).messages.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count, nil
+}
+
+func (i *inMemoryMessage) Trim(_ context.Context, subject string, beforeId int) error {
+	s, ok := i.subjects.Load(subject)
+	if !ok {
+		return nil
+	}
+	ss := s.(*subjectStore)
+
+	ss.trimmedLock.Lock()
+	defer ss.trimmedLock.Unlock()
+
+	for id := ss.trimmed; id < beforeId; id++ {
+		ss.messages.Delete(id)
+	}
+	if beforeId > ss.trimmed {
+		ss.trimmed = beforeId
+	}
+	return nil
+}
+
+func (i *inMemoryMessage) GetMessageByKey(ctx context.Context, subject string, key string) (*broker.Message, error) {
+	s, ok := i.subjects.Load(subject)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	id, ok := s.(*subjectStore).latestId(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return i.GetMessage(ctx, subject, id)
+}
+
+func (i *inMemoryMessage) Compact(_ context.Context, subject string) (int, error) {
+	s, ok := i.subjects.Load(subject)
+	if !ok {
+		return 0, nil
+	}
+	ss := s.(*subjectStore)
+
+	removed := 0
+	ss.messages.Range(func(key, value any) bool {
+		message := value.(messageWithDeadline)
+		if message.Key == "" {
+			return true
+		}
+		if latest, _ := ss.latestId(message.Key); latest > key.(int) {
+			ss.messages.Delete(key)
+			removed++
+		}
+		return true
+	})
+
+	return removed, nil
+}
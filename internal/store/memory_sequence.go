@@ -44,11 +44,43 @@ func (m *memSequence) CreateNewId(_ context.Context, subject string) (int32, err
 	return id, nil
 }
 
+// Load keeps the greatest of the loaded ids, as several
+// stores may hold the messages of the same subject
 func (m *memSequence) Load(ctx context.Context, subject string, lastId int32) error {
 	m.lock(subject)
-	m.unlock(subject)
+	defer m.unlock(subject)
 
+	if val, ok := m.sequences.Load(subject); ok && val.(int32) >= lastId {
+		return nil
+	}
 	m.sequences.Store(subject, lastId)
 
 	return nil
 }
+
+func (m *memSequence) Current(_ context.Context, subject string) (int32, error) {
+	val, ok := m.sequences.Load(subject)
+	if !ok {
+		return 0, nil
+	}
+
+	return val.(int32), nil
+}
+
+func (m *memSequence) Subjects(_ context.Context) ([]string, error) {
+	subjects := make([]string, 0)
+	m.sequences.Range(func(key, _ any) bool {
+		subjects = append(subjects, key.(string))
+		return true
+	})
+
+	return subjects, nil
+}
+
+func (m *memSequence) Delete(_ context.Context, subject string) error {
+	m.lock(subject)
+	defer m.unlock(subject)
+
+	m.sequences.Delete(subject)
+	return nil
+}
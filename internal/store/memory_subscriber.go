@@ -3,8 +3,11 @@ package store
 import (
 	"container/list"
 	"context"
+	"github.com/MeysamBavi/go-broker/internal/logging"
 	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,45 +15,185 @@ const (
 	publishTimeout = time.Second
 )
 
+type subscriberEntry struct {
+	info     SubscriberInfo
+	callBack OnPublishFunc
+	logger   *slog.Logger
+
+	// pending holds the messages not handed to callBack yet, in the order
+	// they were published, so that a subscriber slow to take its messages
+	// only delays itself and not the other subscribers of the subject
+	lock    sync.Mutex
+	pending []pendingMessage
+	running bool
+}
+
+type pendingMessage struct {
+	ctx      context.Context
+	deadline time.Time
+	subject  string
+	message  *broker.Message
+}
+
+// enqueue adds p to the pending messages, and starts handing them
+// to callBack unless it's already being done
+func (e *subscriberEntry) enqueue(p pendingMessage) {
+	e.lock.Lock()
+	e.pending = append(e.pending, p)
+	start := !e.running
+	e.running = true
+	e.lock.Unlock()
+
+	if start {
+		go e.run()
+	}
+}
+
+func (e *subscriberEntry) run() {
+	for {
+		e.lock.Lock()
+		if len(e.pending) == 0 {
+			e.running = false
+			e.lock.Unlock()
+			return
+		}
+		next := e.pending[0]
+		e.pending[0] = pendingMessage{}
+		e.pending = e.pending[1:]
+		e.lock.Unlock()
+
+		ctx, cancel := context.WithDeadline(next.ctx, next.deadline)
+		if err := e.callBack(ctx, next.message); err != nil {
+			e.logger.WarnContext(ctx, "subscriber did not receive the message in time",
+				logging.Subject(next.subject), logging.MessageId(next.message.Id), slog.Int64("subscriber", e.info.Id))
+		}
+		cancel()
+	}
+}
+
+type subjectSubscribers struct {
+	lock sync.RWMutex
+	list *list.List
+	// removed is set once the last subscriber leaves and the subject is
+	// deleted, so that no subscriber is added to it afterwards
+	removed bool
+}
+
+func (s *subjectSubscribers) snapshot() []*subscriberEntry {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	entries := make([]*subscriberEntry, 0, s.list.Len())
+	for element := s.list.Front(); element != nil; element = element.Next() {
+		entries = append(entries, element.Value.(*subscriberEntry))
+	}
+	return entries
+}
+
 type inMemorySubscriber struct {
 	subscribers sync.Map
+	lastId      atomic.Int64
+	logger      *slog.Logger
 }
 
-func NewInMemorySubscriber() Subscriber {
-	return &inMemorySubscriber{}
+func NewInMemorySubscriber(logger *slog.Logger) Subscriber {
+	return &inMemorySubscriber{
+		logger: logger,
+	}
 }
 
-func (i *inMemorySubscriber) AddSubscriber(_ context.Context, subject string, callBack OnPublishFunc) {
-	l, _ := i.subscribers.LoadOrStore(subject, list.New())
-	l.(*list.List).PushBack(callBack)
+func (i *inMemorySubscriber) getSubscribers(subject string) *subjectSubscribers {
+	s, _ := i.subscribers.LoadOrStore(subject, &subjectSubscribers{list: list.New()})
+	return s.(*subjectSubscribers)
 }
 
-func (i *inMemorySubscriber) Publish(_ context.Context, subject string, message *broker.Message) {
-	l, ok := i.subscribers.Load(subject)
+// AddSubscriber registers callBack until ctx is done; the subject
+// is forgotten once its last subscriber leaves
+func (i *inMemorySubscriber) AddSubscriber(ctx context.Context, subject string, callBack OnPublishFunc) {
+	entry := &subscriberEntry{
+		info: SubscriberInfo{
+			Id:    i.lastId.Add(1),
+			Since: time.Now(),
+		},
+		callBack: callBack,
+		logger:   i.logger,
+	}
+
+	var subscribers *subjectSubscribers
+	var element *list.Element
+	for element == nil {
+		subscribers = i.getSubscribers(subject)
+		subscribers.lock.Lock()
+		if !subscribers.removed {
+			element = subscribers.list.PushBack(entry)
+		}
+		subscribers.lock.Unlock()
+	}
+
+	context.AfterFunc(ctx, func() {
+		subscribers.lock.Lock()
+		defer subscribers.lock.Unlock()
+		subscribers.list.Remove(element)
+		if subscribers.list.Len() == 0 {
+			subscribers.removed = true
+			i.subscribers.CompareAndDelete(subject, subscribers)
+		}
+	})
+}
+
+// Publish queues message for every subscriber of subject and returns
+// without waiting for them; each subscriber gets its messages in the
+// order they are published, and up to publishTimeout to take every one
+func (i *inMemorySubscriber) Publish(ctx context.Context, subject string, message *broker.Message) {
+	s, ok := i.subscribers.Load(subject)
 	if !ok {
 		return
 	}
-	subscribers := l.(*list.List)
-
-	var wg sync.WaitGroup
-	for element := subscribers.Front(); element != nil; element = element.Next() {
-		callback := element.Value.(OnPublishFunc)
-		wg.Add(1)
-		go func() {
-			callback(message)
-			wg.Done()
-		}()
-	}
-
-	allDone := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(allDone)
-	}()
-	select {
-	case <-time.After(publishTimeout):
-		return
-	case <-allDone:
+	entries := s.(*subjectSubscribers).snapshot()
+	if len(entries) == 0 {
 		return
 	}
+
+	// the subscribers are given publishTimeout regardless of ctx,
+	// which may be cancelled as soon as the publisher gets its response,
+	// and share a copy of message, which the publisher may reuse
+	pending := pendingMessage{
+		ctx:      context.WithoutCancel(ctx),
+		deadline: time.Now().Add(publishTimeout),
+		subject:  subject,
+		message:  new(broker.Message),
+	}
+	*pending.message = *message
+	for _, entry := range entries {
+		entry.enqueue(pending)
+	}
+}
+
+func (i *inMemorySubscriber) Subscribers(_ context.Context, subject string) []SubscriberInfo {
+	s, ok := i.subscribers.Load(subject)
+	if !ok {
+		return []SubscriberInfo{}
+	}
+	entries := s.(*subjectSubscribers).snapshot()
+
+	infos := make([]SubscriberInfo, len(entries))
+	for j, entry := range entries {
+		infos[j] = entry.info
+	}
+	return infos
+}
+
+func (i *inMemorySubscriber) Subjects(_ context.Context) []string {
+	subjects := make([]string, 0)
+	i.subscribers.Range(func(key, value any) bool {
+		s := value.(*subjectSubscribers)
+		s.lock.RLock()
+		if s.list.Len() > 0 {
+			subjects = append(subjects, key.(string))
+		}
+		s.lock.RUnlock()
+		return true
+	})
+
+	return subjects
 }
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSlowSubscriberShouldNotDelayOthers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscribers := NewInMemorySubscriber(logging.NewNopLogger())
+
+	release := make(chan struct{})
+	slow := make(chan int, 3)
+	subscribers.AddSubscriber(ctx, "ali", func(publishCtx context.Context, message *broker.Message) error {
+		select {
+		case <-release:
+		case <-publishCtx.Done():
+			return publishCtx.Err()
+		}
+		slow <- message.Id
+		return nil
+	})
+	fast := make(chan int, 3)
+	subscribers.AddSubscriber(ctx, "ali", func(_ context.Context, message *broker.Message) error {
+		fast <- message.Id
+		return nil
+	})
+
+	for id := 1; id <= 3; id++ {
+		subscribers.Publish(ctx, "ali", &broker.Message{Id: id})
+	}
+
+	for id := 1; id <= 3; id++ {
+		select {
+		case received := <-fast:
+			assert.Equal(t, id, received)
+		case <-time.After(publishTimeout / 2):
+			t.Fatal("fast subscriber was delayed by the slow one")
+		}
+	}
+
+	close(release)
+	for id := 1; id <= 3; id++ {
+		assert.Equal(t, id, <-slow)
+	}
+}
@@ -11,16 +11,60 @@ import (
 
 const (
 	packageName = "/internal/store"
+	// compactionChunk is the most messages a store removes, or reads,
+	// at once while compacting, so that large subjects are compacted
+	// in several small statements instead of a single huge one
+	compactionChunk = 500
 )
 
+// Message keeps the messages of every subject in its own partition; the
+// subjects of namespaces other than the default one are qualified by
+// their namespace, so namespaces never share a partition
 type Message interface {
 	SaveMessage(ctx context.Context, subject string, message *broker.Message) error
 	GetMessage(ctx context.Context, subject string, id int) (*broker.Message, error)
+	// GetMessageByKey returns the latest message of subject published with key
+	GetMessageByKey(ctx context.Context, subject string, key string) (*broker.Message, error)
+	// Subjects returns the subjects that messages are published to
+	Subjects(ctx context.Context) ([]string, error)
+	// Stats returns the number of stored, not expired, messages and the latest id of subject
+	Stats(ctx context.Context, subject string) (SubjectStats, error)
+	// Purge removes all the messages of subject, without resetting its ids,
+	// and returns the number of removed messages
+	Purge(ctx context.Context, subject string) (int, error)
+	// Delete is Purge, but also resets the ids of subject,
+	// as if nothing was ever published to it
+	Delete(ctx context.Context, subject string) (int, error)
+	// Trim removes the messages of subject whose id is below beforeId
+	Trim(ctx context.Context, subject string, beforeId int) error
+	// Compact removes the messages of subject superseded by a newer
+	// message with the same key, and returns the number of removed messages
+	Compact(ctx context.Context, subject string) (int, error)
+}
+
+type SubjectStats struct {
+	Messages int
+	LatestId int
+}
+
+// HealthChecker is implemented by the stores depending on an external service
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// CheckHealth returns the result of m.CheckHealth if m is a HealthChecker,
+// and nil otherwise
+func CheckHealth(ctx context.Context, m Message) error {
+	if checker, ok := m.(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
 }
 
 var (
-	ErrInvalidId = errors.New("no message exists with given id")
-	ErrExpired   = errors.New("this message is expired")
+	ErrInvalidId   = errors.New("no message exists with given id")
+	ErrExpired     = errors.New("this message is expired")
+	ErrKeyNotFound = errors.New("no message exists with given key")
 )
 
 type TimeProvider interface {
@@ -81,3 +125,105 @@ func (w *withTracing) GetMessage(ctx context.Context, subject string, id int) (*
 
 	return m, err
 }
+
+func (w *withTracing) CheckHealth(ctx context.Context) error {
+	ctx, span := w.tracer().Start(ctx, "CheckHealth")
+	defer span.End()
+
+	err := CheckHealth(ctx, w.core)
+
+	tracing.SetStatusAndError(span, err)
+
+	return err
+}
+
+func (w *withTracing) Subjects(ctx context.Context) ([]string, error) {
+	ctx, span := w.tracer().Start(ctx, "Subjects")
+	defer span.End()
+
+	subjects, err := w.core.Subjects(ctx)
+
+	tracing.SetStatusAndError(span, err)
+
+	return subjects, err
+}
+
+func (w *withTracing) Stats(ctx context.Context, subject string) (SubjectStats, error) {
+	ctx, span := w.tracer().Start(ctx, "Stats")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+
+	stats, err := w.core.Stats(ctx, subject)
+
+	tracing.SetStatusAndError(span, err)
+
+	return stats, err
+}
+
+func (w *withTracing) Purge(ctx context.Context, subject string) (int, error) {
+	ctx, span := w.tracer().Start(ctx, "Purge")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+
+	count, err := w.core.Purge(ctx, subject)
+
+	tracing.SetStatusAndError(span, err)
+
+	return count, err
+}
+
+func (w *withTracing) Delete(ctx context.Context, subject string) (int, error) {
+	ctx, span := w.tracer().Start(ctx, "Delete")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+
+	count, err := w.core.Delete(ctx, subject)
+
+	tracing.SetStatusAndError(span, err)
+
+	return count, err
+}
+
+func (w *withTracing) Trim(ctx context.Context, subject string, beforeId int) error {
+	ctx, span := w.tracer().Start(ctx, "Trim")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+	span.SetAttributes(tracing.MessageId(beforeId))
+
+	err := w.core.Trim(ctx, subject, beforeId)
+
+	tracing.SetStatusAndError(span, err)
+
+	return err
+}
+
+func (w *withTracing) GetMessageByKey(ctx context.Context, subject string, key string) (*broker.Message, error) {
+	ctx, span := w.tracer().Start(ctx, "GetMessageByKey")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+	span.SetAttributes(tracing.MessageKey(key))
+
+	m, err := w.core.GetMessageByKey(ctx, subject, key)
+
+	tracing.SetStatusAndError(span, err)
+
+	return m, err
+}
+
+func (w *withTracing) Compact(ctx context.Context, subject string) (int, error) {
+	ctx, span := w.tracer().Start(ctx, "Compact")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+
+	removed, err := w.core.Compact(ctx, subject)
+
+	tracing.SetStatusAndError(span, err)
+
+	return removed, err
+}
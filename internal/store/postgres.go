@@ -3,13 +3,17 @@ package store
 import (
 	"context"
 	"fmt"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/schema"
 	"github.com/MeysamBavi/go-broker/internal/store/batch"
 	"github.com/MeysamBavi/go-broker/pkg/broker"
 	"github.com/uptrace/opentelemetry-go-extra/otelgorm"
 	"go.opentelemetry.io/otel/trace"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	"gorm.io/gorm/clause"
+	gormLogger "gorm.io/gorm/logger"
+	"log/slog"
 	"strings"
 	"time"
 )
@@ -29,13 +33,15 @@ type postgresImpl struct {
 	sequences    Sequence
 	batchHandler batch.Handler
 	timeProvider TimeProvider
+	logger       *slog.Logger
 }
 
-func NewPostgres(config PostgresConfig, sequence Sequence, batchHandlerProvider func(writer batch.Writer) batch.Handler, timeProvider TimeProvider, traceProvider trace.TracerProvider) (Message, error) {
+func NewPostgres(config PostgresConfig, sequence Sequence, batchHandlerProvider func(writer batch.Writer) batch.Handler, timeProvider TimeProvider, traceProvider trace.TracerProvider, logger *slog.Logger) (Message, error) {
 	p := &postgresImpl{
 		config:       config,
 		sequences:    sequence,
 		timeProvider: timeProvider,
+		logger:       logger,
 	}
 	p.batchHandler = batchHandlerProvider(p.saveBatch)
 
@@ -46,14 +52,14 @@ func NewPostgres(config PostgresConfig, sequence Sequence, batchHandlerProvider
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s",
 		config.Host, config.User, config.Password, config.DBName, config.Port)
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Error),
+		Logger: gormLogger.Default.LogMode(gormLogger.Error),
 	})
 	p.db = db
 	if err != nil {
 		return nil, err
 	}
 
-	if err := p.db.AutoMigrate(&postgresMessage{}); err != nil {
+	if err := p.db.AutoMigrate(&postgresMessage{}, &postgresScheduledMessage{}, &postgresLatestId{}, &postgresSchema{}); err != nil {
 		return nil, err
 	}
 
@@ -93,6 +99,7 @@ func (p *postgresImpl) initDB() error {
 	if result.Error != nil && !strings.Contains(result.Error.Error(), "exists") {
 		return result.Error
 	}
+	p.logger.Info("database initialized", slog.String("database", p.config.DBName))
 
 	return nil
 }
@@ -114,6 +121,7 @@ func (p *postgresImpl) loadSequences() error {
 		if err := p.sequences.Load(ctx, subject, lastId); err != nil {
 			return err
 		}
+		p.logger.DebugContext(ctx, "sequence loaded", logging.Subject(subject), logging.MessageId(int(lastId)))
 	}
 
 	return nil
@@ -128,12 +136,10 @@ func (p *postgresImpl) GetMessage(ctx context.Context, subject string, id int) (
 		Subject: subject,
 		Id:      int32(id),
 	}
-	p.db.WithContext(ctx).Take(&msg)
-
-	err := p.db.Error
+	err := p.db.WithContext(ctx).Take(&msg).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, ErrInvalidId
+			return nil, missingMessageError(ctx, p.sequences, subject, id)
 		}
 
 		return nil, err
@@ -144,14 +150,196 @@ func (p *postgresImpl) GetMessage(ctx context.Context, subject string, id int) (
 	}
 
 	message := broker.Message{
-		Id:         id,
-		Body:       msg.Body,
-		Expiration: time.Second * time.Duration(msg.ExpirationSeconds),
+		Id:            id,
+		Body:          msg.Body,
+		Expiration:    time.Second * time.Duration(msg.ExpirationSeconds),
+		Headers:       msg.Headers,
+		Key:           msg.Key,
+		SchemaVersion: int(msg.SchemaVersion),
 	}
 
 	return &message, nil
 }
 
+func (p *postgresImpl) GetMessageByKey(ctx context.Context, subject string, key string) (*broker.Message, error) {
+	latest := postgresLatestId{
+		Subject: subject,
+		Key:     key,
+	}
+	err := p.db.WithContext(ctx).Take(&latest).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+
+	return p.GetMessage(ctx, subject, int(latest.Id))
+}
+
+// Compact removes the superseded messages in chunks, so that the
+// rows of a large subject are not all locked by a single statement
+func (p *postgresImpl) Compact(ctx context.Context, subject string) (int, error) {
+	removed := 0
+	for {
+		result := p.db.WithContext(ctx).Exec(
+			"DELETE FROM messages WHERE subject = ? AND id IN ("+
+				"SELECT m.id FROM messages m JOIN latest_ids_by_key l ON l.subject = m.subject AND l.key = m.key "+
+				"WHERE m.subject = ? AND m.id < l.id LIMIT ?)",
+			subject, subject, compactionChunk,
+		)
+		if result.Error != nil {
+			return removed, result.Error
+		}
+		removed += int(result.RowsAffected)
+		if result.RowsAffected < compactionChunk {
+			return removed, nil
+		}
+	}
+}
+
+func (p *postgresImpl) Subjects(ctx context.Context) ([]string, error) {
+	return p.sequences.Subjects(ctx)
+}
+
+func (p *postgresImpl) Stats(ctx context.Context, subject string) (SubjectStats, error) {
+	var count int64
+	err := p.db.WithContext(ctx).Model(&postgresMessage{}).
+		Where("subject = ? AND EXTRACT(EPOCH FROM (?::timestamptz - created_at)) <= expiration_seconds",
+			subject, p.timeProvider.GetCurrentTime()).
+		Count(&count).Error
+	if err != nil {
+		return SubjectStats{}, err
+	}
+
+	lastId, err := p.sequences.Current(ctx, subject)
+	if err != nil {
+		return SubjectStats{}, err
+	}
+
+	return SubjectStats{
+		Messages: int(count),
+		LatestId: int(lastId),
+	}, nil
+}
+
+func (p *postgresImpl) Purge(ctx context.Context, subject string) (int, error) {
+	var purged int
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("subject = ?", subject).Delete(&postgresMessage{})
+		if result.Error != nil {
+			return result.Error
+		}
+		purged = int(result.RowsAffected)
+
+		return tx.Where("subject = ?", subject).Delete(&postgresLatestId{}).Error
+	})
+
+	return purged, err
+}
+
+func (p *postgresImpl) Add(ctx context.Context, subject string, message *broker.Message) error {
+	return p.db.WithContext(ctx).Create(&postgresScheduledMessage{
+		Subject:           subject,
+		Id:                int32(message.Id),
+		Body:              message.Body,
+		ExpirationSeconds: message.Expiration.Seconds(),
+		DeliverAt:         message.DeliverAt,
+		Headers:           message.Headers,
+		Key:               message.Key,
+		SchemaVersion:     int32(message.SchemaVersion),
+	}).Error
+}
+
+func (p *postgresImpl) Remove(ctx context.Context, subject string, id int) error {
+	return p.db.WithContext(ctx).Delete(&postgresScheduledMessage{
+		Subject: subject,
+		Id:      int32(id),
+	}).Error
+}
+
+func (p *postgresImpl) Pending(ctx context.Context) ([]ScheduledMessage, error) {
+	var rows []postgresScheduledMessage
+	if err := p.db.WithContext(ctx).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	pending := make([]ScheduledMessage, len(rows))
+	for i, row := range rows {
+		pending[i] = ScheduledMessage{
+			Subject: row.Subject,
+			Message: broker.Message{
+				Id:            int(row.Id),
+				Body:          row.Body,
+				Expiration:    time.Duration(row.ExpirationSeconds * float64(time.Second)),
+				DeliverAt:     row.DeliverAt,
+				Headers:       row.Headers,
+				Key:           row.Key,
+				SchemaVersion: int(row.SchemaVersion),
+			},
+		}
+	}
+
+	return pending, nil
+}
+
+func (p *postgresImpl) SaveSchema(ctx context.Context, s schema.Schema) error {
+	return p.db.WithContext(ctx).Create(&postgresSchema{
+		Subject:     s.Subject,
+		Version:     int32(s.Version),
+		Format:      s.Format,
+		Definition:  s.Definition,
+		MessageName: s.MessageName,
+		CreatedAt:   s.CreatedAt,
+	}).Error
+}
+
+func (p *postgresImpl) DeleteSchemas(ctx context.Context, subject string) error {
+	return p.db.WithContext(ctx).Where("subject = ?", subject).Delete(&postgresSchema{}).Error
+}
+
+func (p *postgresImpl) Schemas(ctx context.Context) ([]schema.Schema, error) {
+	var rows []postgresSchema
+	if err := p.db.WithContext(ctx).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	schemas := make([]schema.Schema, len(rows))
+	for i, row := range rows {
+		schemas[i] = schema.Schema{
+			Subject:     row.Subject,
+			Version:     int(row.Version),
+			Format:      row.Format,
+			Definition:  row.Definition,
+			MessageName: row.MessageName,
+			CreatedAt:   row.CreatedAt,
+		}
+	}
+
+	return schemas, nil
+}
+
+func (p *postgresImpl) Delete(ctx context.Context, subject string) (int, error) {
+	deleted, err := p.Purge(ctx, subject)
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, p.sequences.Delete(ctx, subject)
+}
+
+func (p *postgresImpl) Trim(ctx context.Context, subject string, beforeId int) error {
+	return p.db.WithContext(ctx).Where("subject = ? AND id < ?", subject, beforeId).Delete(&postgresMessage{}).Error
+}
+
+func (p *postgresImpl) CheckHealth(ctx context.Context) error {
+	sqlDb, err := p.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDb.PingContext(ctx)
+}
+
 func (p *postgresImpl) saveBatch(ctx context.Context, values []*batch.Item) error {
 	messages := make([]postgresMessage, len(values))
 	for i := range messages {
@@ -163,9 +351,17 @@ func (p *postgresImpl) saveBatch(ctx context.Context, values []*batch.Item) erro
 		messages[i].Subject = values[i].Subject
 		messages[i].Body = values[i].Message.Body
 		messages[i].ExpirationSeconds = values[i].Message.Expiration.Seconds()
+		messages[i].Headers = values[i].Message.Headers
+		messages[i].Key = values[i].Message.Key
+		messages[i].SchemaVersion = int32(values[i].Message.SchemaVersion)
 	}
 
-	err := p.db.WithContext(ctx).CreateInBatches(messages, len(messages)).Error
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(messages, len(messages)).Error; err != nil {
+			return err
+		}
+		return upsertLatestIds(tx, messages)
+	})
 	if err != nil {
 		return err
 	}
@@ -177,14 +373,82 @@ func (p *postgresImpl) saveBatch(ctx context.Context, values []*batch.Item) erro
 	return nil
 }
 
+// upsertLatestIds points the keys of messages to their latest id
+func upsertLatestIds(tx *gorm.DB, messages []postgresMessage) error {
+	// a row can only be upserted once per statement
+	latest := make(map[latestKey]int32)
+	for _, message := range messages {
+		if message.Key != "" {
+			latest[latestKey{message.Subject, message.Key}] = message.Id
+		}
+	}
+	if len(latest) == 0 {
+		return nil
+	}
+
+	rows := make([]postgresLatestId, 0, len(latest))
+	for k, id := range latest {
+		rows = append(rows, postgresLatestId{Subject: k.subject, Key: k.key, Id: id})
+	}
+
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "subject"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"id"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "latest_ids_by_key.id < excluded.id"},
+		}},
+	}).Create(&rows).Error
+}
+
 type postgresMessage struct {
-	Subject           string `gorm:"primaryKey"`
+	Subject           string `gorm:"primaryKey;index:idx_messages_subject_key,priority:1"`
 	Id                int32  `gorm:"primaryKey;autoIncrement:false"`
 	Body              string
 	ExpirationSeconds float64
+	Headers           map[string]string `gorm:"serializer:json"`
+	Key               string            `gorm:"index:idx_messages_subject_key,priority:2"`
+	SchemaVersion     int32
 	CreatedAt         time.Time
 }
 
 func (p *postgresMessage) TableName() string {
 	return "messages"
 }
+
+type postgresScheduledMessage struct {
+	Subject           string `gorm:"primaryKey"`
+	Id                int32  `gorm:"primaryKey;autoIncrement:false"`
+	Body              string
+	ExpirationSeconds float64
+	DeliverAt         time.Time
+	Headers           map[string]string `gorm:"serializer:json"`
+	Key               string
+	SchemaVersion     int32
+}
+
+func (p *postgresScheduledMessage) TableName() string {
+	return "scheduled_messages"
+}
+
+type postgresLatestId struct {
+	Subject string `gorm:"primaryKey"`
+	Key     string `gorm:"primaryKey"`
+	Id      int32
+}
+
+func (p *postgresLatestId) TableName() string {
+	return "latest_ids_by_key"
+}
+
+type postgresSchema struct {
+	Subject     string `gorm:"primaryKey"`
+	Version     int32  `gorm:"primaryKey;autoIncrement:false"`
+	Format      string
+	Definition  []byte
+	MessageName string
+	CreatedAt   time.Time
+}
+
+func (p *postgresSchema) TableName() string {
+	return "schemas"
+}
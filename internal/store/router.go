@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"sort"
+)
+
+// RouteFunc returns the name of the backend keeping the messages
+// of subject, or "" for the default backend
+type RouteFunc func(subject string) string
+
+type router struct {
+	backends       map[string]Message
+	defaultBackend string
+	route          RouteFunc
+}
+
+// NewRouter returns a Message dispatching every call to one of backends,
+// chosen per subject by route. Ids stay increasing when a subject is moved
+// between backends sharing the same Sequence, which the in-memory one does not.
+func NewRouter(backends map[string]Message, defaultBackend string, route RouteFunc) (Message, error) {
+	if _, ok := backends[defaultBackend]; !ok {
+		return nil, fmt.Errorf("default backend %q is not provided", defaultBackend)
+	}
+
+	return &router{
+		backends:       backends,
+		defaultBackend: defaultBackend,
+		route:          route,
+	}, nil
+}
+
+func (r *router) backend(subject string) Message {
+	if m, ok := r.backends[r.route(subject)]; ok {
+		return m
+	}
+	return r.backends[r.defaultBackend]
+}
+
+func (r *router) SaveMessage(ctx context.Context, subject string, message *broker.Message) error {
+	return r.backend(subject).SaveMessage(ctx, subject, message)
+}
+
+func (r *router) GetMessage(ctx context.Context, subject string, id int) (*broker.Message, error) {
+	return r.backend(subject).GetMessage(ctx, subject, id)
+}
+
+func (r *router) GetMessageByKey(ctx context.Context, subject string, key string) (*broker.Message, error) {
+	return r.backend(subject).GetMessageByKey(ctx, subject, key)
+}
+
+// Subjects returns the subjects of all the backends, including the
+// ones that are not routed to them anymore but still have messages
+func (r *router) Subjects(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	subjects := make([]string, 0)
+	for _, m := range r.backends {
+		backendSubjects, err := m.Subjects(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, subject := range backendSubjects {
+			if !seen[subject] {
+				seen[subject] = true
+				subjects = append(subjects, subject)
+			}
+		}
+	}
+	sort.Strings(subjects)
+
+	return subjects, nil
+}
+
+func (r *router) Stats(ctx context.Context, subject string) (SubjectStats, error) {
+	return r.backend(subject).Stats(ctx, subject)
+}
+
+func (r *router) Purge(ctx context.Context, subject string) (int, error) {
+	return r.backend(subject).Purge(ctx, subject)
+}
+
+// Delete deletes subject from all the backends, as it
+// may still have messages in the ones it was routed to before
+func (r *router) Delete(ctx context.Context, subject string) (int, error) {
+	deleted := 0
+	for name, m := range r.backends {
+		count, err := m.Delete(ctx, subject)
+		if err != nil {
+			return deleted, fmt.Errorf("%s store: %w", name, err)
+		}
+		deleted += count
+	}
+	return deleted, nil
+}
+
+func (r *router) Trim(ctx context.Context, subject string, beforeId int) error {
+	return r.backend(subject).Trim(ctx, subject, beforeId)
+}
+
+func (r *router) Compact(ctx context.Context, subject string) (int, error) {
+	return r.backend(subject).Compact(ctx, subject)
+}
+
+// CheckHealth fails if any of the backends is unhealthy
+func (r *router) CheckHealth(ctx context.Context) error {
+	for name, m := range r.backends {
+		if err := CheckHealth(ctx, m); err != nil {
+			return fmt.Errorf("%s store: %w", name, err)
+		}
+	}
+	return nil
+}
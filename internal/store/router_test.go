@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterShouldDispatchBySubject(t *testing.T) {
+	ctx := context.Background()
+	ephemeral := NewInMemoryMessage(GetDefaultTimeProvider())
+	audit := NewInMemoryMessage(GetDefaultTimeProvider())
+	r, err := NewRouter(map[string]Message{"ephemeral": ephemeral, "audit": audit}, "ephemeral", func(subject string) string {
+		if strings.HasPrefix(subject, "audit.") {
+			return "audit"
+		}
+		return ""
+	})
+	assert.Nil(t, err)
+
+	for _, subject := range []string{"audit.login", "metrics"} {
+		err := r.SaveMessage(ctx, subject, &broker.Message{Body: subject, Expiration: time.Minute})
+		assert.Nil(t, err)
+	}
+
+	_, err = audit.GetMessage(ctx, "audit.login", 1)
+	assert.Nil(t, err)
+	_, err = ephemeral.GetMessage(ctx, "metrics", 1)
+	assert.Nil(t, err)
+	_, err = ephemeral.GetMessage(ctx, "audit.login", 1)
+	assert.Equal(t, ErrInvalidId, err)
+
+	msg, err := r.GetMessage(ctx, "audit.login", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "audit.login", msg.Body)
+
+	subjects, err := r.Subjects(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"audit.login", "metrics"}, subjects)
+}
+
+func TestRouterShouldRequireDefaultBackend(t *testing.T) {
+	_, err := NewRouter(map[string]Message{"audit": NewInMemoryMessage(GetDefaultTimeProvider())}, "ephemeral", nil)
+	assert.NotNil(t, err)
+}
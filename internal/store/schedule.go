@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/internal/tracing"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+// Schedule persists the messages waiting for their delivery time,
+// so that they are not lost if the broker restarts
+type Schedule interface {
+	// Add keeps message until it's removed
+	Add(ctx context.Context, subject string, message *broker.Message) error
+	// Remove is called once the message with id is delivered
+	Remove(ctx context.Context, subject string, id int) error
+	// Pending returns every message added and not removed yet
+	Pending(ctx context.Context) ([]ScheduledMessage, error)
+}
+
+type ScheduledMessage struct {
+	Subject string
+	Message broker.Message
+}
+
+// ScheduleOf returns the first of stores that can persist
+// schedules itself, and an in-memory Schedule if there is none
+func ScheduleOf(stores ...Message) Schedule {
+	for _, m := range stores {
+		if schedule, ok := m.(Schedule); ok {
+			return schedule
+		}
+	}
+	return NewInMemorySchedule()
+}
+
+type scheduleKey struct {
+	subject string
+	id      int
+}
+
+type inMemorySchedule struct {
+	lock     sync.Mutex
+	messages map[scheduleKey]broker.Message
+}
+
+func NewInMemorySchedule() Schedule {
+	return &inMemorySchedule{
+		messages: make(map[scheduleKey]broker.Message),
+	}
+}
+
+func (i *inMemorySchedule) Add(_ context.Context, subject string, message *broker.Message) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.messages[scheduleKey{subject, message.Id}] = *message
+	return nil
+}
+
+func (i *inMemorySchedule) Remove(_ context.Context, subject string, id int) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	delete(i.messages, scheduleKey{subject, id})
+	return nil
+}
+
+func (i *inMemorySchedule) Pending(_ context.Context) ([]ScheduledMessage, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	pending := make([]ScheduledMessage, 0, len(i.messages))
+	for key, message := range i.messages {
+		pending = append(pending, ScheduledMessage{
+			Subject: key.subject,
+			Message: message,
+		})
+	}
+	return pending, nil
+}
+
+type scheduleWithTracing struct {
+	trace.TracerProvider
+	core Schedule
+}
+
+func ScheduleWithTracing(core Schedule, tracerProvider trace.TracerProvider) Schedule {
+	return &scheduleWithTracing{
+		TracerProvider: tracerProvider,
+		core:           core,
+	}
+}
+
+func (s *scheduleWithTracing) tracer() trace.Tracer {
+	return s.Tracer(packageName + ".Schedule")
+}
+
+func (s *scheduleWithTracing) Add(ctx context.Context, subject string, message *broker.Message) error {
+	ctx, span := s.tracer().Start(ctx, "Add")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+	span.SetAttributes(tracing.MessageId(message.Id))
+
+	err := s.core.Add(ctx, subject, message)
+
+	tracing.SetStatusAndError(span, err)
+
+	return err
+}
+
+func (s *scheduleWithTracing) Remove(ctx context.Context, subject string, id int) error {
+	ctx, span := s.tracer().Start(ctx, "Remove")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+	span.SetAttributes(tracing.MessageId(id))
+
+	err := s.core.Remove(ctx, subject, id)
+
+	tracing.SetStatusAndError(span, err)
+
+	return err
+}
+
+func (s *scheduleWithTracing) Pending(ctx context.Context) ([]ScheduledMessage, error) {
+	ctx, span := s.tracer().Start(ctx, "Pending")
+	defer span.End()
+
+	pending, err := s.core.Pending(ctx)
+
+	tracing.SetStatusAndError(span, err)
+
+	return pending, err
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"github.com/MeysamBavi/go-broker/internal/schema"
+	"sync"
+)
+
+// SchemaStoreOf returns the first of stores that can persist
+// schemas itself, and an in-memory schema.Store if there is none
+func SchemaStoreOf(stores ...Message) schema.Store {
+	for _, m := range stores {
+		if s, ok := m.(schema.Store); ok {
+			return s
+		}
+	}
+	return NewInMemorySchemaStore()
+}
+
+type inMemorySchemaStore struct {
+	lock    sync.Mutex
+	schemas []schema.Schema
+}
+
+func NewInMemorySchemaStore() schema.Store {
+	return &inMemorySchemaStore{}
+}
+
+func (i *inMemorySchemaStore) SaveSchema(_ context.Context, s schema.Schema) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.schemas = append(i.schemas, s)
+	return nil
+}
+
+func (i *inMemorySchemaStore) Schemas(_ context.Context) ([]schema.Schema, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return append([]schema.Schema(nil), i.schemas...), nil
+}
+
+func (i *inMemorySchemaStore) DeleteSchemas(_ context.Context, subject string) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	kept := make([]schema.Schema, 0, len(i.schemas))
+	for _, s := range i.schemas {
+		if s.Subject != subject {
+			kept = append(kept, s)
+		}
+	}
+	i.schemas = kept
+	return nil
+}
@@ -6,9 +6,33 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+// Sequence keeps a sequence per subject, and so per namespace,
+// as the subjects are qualified by their namespace
 type Sequence interface {
 	CreateNewId(ctx context.Context, subject string) (int32, error)
 	Load(ctx context.Context, subject string, lastId int32) error
+	// Current returns the last id created for subject, 0 if there is none
+	Current(ctx context.Context, subject string) (int32, error)
+	// Subjects returns every subject that has a sequence
+	Subjects(ctx context.Context) ([]string, error)
+	// Delete forgets the sequence of subject, whose ids start over from 1
+	Delete(ctx context.Context, subject string) error
+}
+
+// missingMessageError tells apart a message that is not stored anymore from
+// a message that was never published, by comparing id against the subject's
+// current sequence
+func missingMessageError(ctx context.Context, sequences Sequence, subject string, id int) error {
+	lastId, err := sequences.Current(ctx, subject)
+	if err != nil {
+		return err
+	}
+
+	if id > 0 && id <= int(lastId) {
+		return ErrExpired
+	}
+
+	return ErrInvalidId
 }
 
 type sequenceWithTracing struct {
@@ -54,3 +78,41 @@ func (s *sequenceWithTracing) Load(ctx context.Context, subject string, lastId i
 
 	return err
 }
+
+func (s *sequenceWithTracing) Current(ctx context.Context, subject string) (int32, error) {
+	ctx, span := s.tracer().Start(ctx, "Current")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+
+	id, err := s.core.Current(ctx, subject)
+
+	span.SetAttributes(tracing.MessageId(int(id)))
+	tracing.SetStatusAndError(span, err)
+
+	return id, err
+}
+
+func (s *sequenceWithTracing) Subjects(ctx context.Context) ([]string, error) {
+	ctx, span := s.tracer().Start(ctx, "Subjects")
+	defer span.End()
+
+	subjects, err := s.core.Subjects(ctx)
+
+	tracing.SetStatusAndError(span, err)
+
+	return subjects, err
+}
+
+func (s *sequenceWithTracing) Delete(ctx context.Context, subject string) error {
+	ctx, span := s.tracer().Start(ctx, "Delete")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+
+	err := s.core.Delete(ctx, subject)
+
+	tracing.SetStatusAndError(span, err)
+
+	return err
+}
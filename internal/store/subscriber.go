@@ -5,14 +5,26 @@ import (
 	"github.com/MeysamBavi/go-broker/internal/tracing"
 	"github.com/MeysamBavi/go-broker/pkg/broker"
 	"go.opentelemetry.io/otel/trace"
+	"time"
 )
 
 type Subscriber interface {
 	AddSubscriber(ctx context.Context, subject string, callBack OnPublishFunc)
 	Publish(ctx context.Context, subject string, message *broker.Message)
+	// Subscribers returns the subscribers currently attached to subject
+	Subscribers(ctx context.Context, subject string) []SubscriberInfo
+	// Subjects returns the subjects having at least one subscriber
+	Subjects(ctx context.Context) []string
 }
 
-type OnPublishFunc func(message *broker.Message)
+// OnPublishFunc hands message to a subscriber; it must return
+// an error, instead of blocking, once ctx is done
+type OnPublishFunc func(ctx context.Context, message *broker.Message) error
+
+type SubscriberInfo struct {
+	Id    int64
+	Since time.Time
+}
 
 type subscriberWithTracing struct {
 	core           Subscriber
@@ -48,3 +60,19 @@ func (s *subscriberWithTracing) Publish(ctx context.Context, subject string, mes
 
 	s.core.Publish(ctx, subject, message)
 }
+
+func (s *subscriberWithTracing) Subscribers(ctx context.Context, subject string) []SubscriberInfo {
+	ctx, span := s.tracer().Start(ctx, "Subscribers")
+	defer span.End()
+
+	span.SetAttributes(tracing.Subject(subject))
+
+	return s.core.Subscribers(ctx, subject)
+}
+
+func (s *subscriberWithTracing) Subjects(ctx context.Context) []string {
+	ctx, span := s.tracer().Start(ctx, "Subjects")
+	defer span.End()
+
+	return s.core.Subjects(ctx)
+}
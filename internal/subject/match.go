@@ -0,0 +1,93 @@
+package subject
+
+import "strings"
+
+const (
+	separator = "."
+	// matches exactly one token
+	singleWildcard = "*"
+	// matches one or more trailing tokens, only valid as the last token
+	tailWildcard = ">"
+)
+
+// Match reports whether subject matches pattern. Subjects and patterns
+// are split into tokens by dots; in a pattern, "*" matches any single
+// token and a trailing ">" matches all the remaining tokens.
+// For example "orders.*" matches "orders.new" but not "orders.new.eu",
+// while "orders.>" matches both.
+func Match(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, separator)
+	subjectTokens := strings.Split(subject, separator)
+
+	for i, token := range patternTokens {
+		if token == tailWildcard && i == len(patternTokens)-1 {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) {
+			return false
+		}
+		if token != singleWildcard && token != subjectTokens[i] {
+			return false
+		}
+	}
+
+	return len(patternTokens) == len(subjectTokens)
+}
+
+// MatchAny reports whether subject matches at least one of patterns
+func MatchAny(patterns []string, subject string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether pattern matches every subject that other matches,
+// so that rights granted on pattern also hold on all of other.
+// For example "orders.>" covers "orders.*" and "orders.eu.>",
+// while "orders.*" covers neither "orders.>" nor ">".
+func Covers(pattern, other string) bool {
+	patternTokens := strings.Split(pattern, separator)
+	otherTokens := strings.Split(other, separator)
+
+	for i, token := range patternTokens {
+		if token == tailWildcard && i == len(patternTokens)-1 {
+			return len(otherTokens) > i
+		}
+		if i >= len(otherTokens) {
+			return false
+		}
+		otherToken := otherTokens[i]
+		if otherToken == tailWildcard && i == len(otherTokens)-1 {
+			return false
+		}
+		if token != singleWildcard && token != otherToken {
+			return false
+		}
+	}
+
+	return len(patternTokens) == len(otherTokens)
+}
+
+// Overlaps reports whether at least one subject matches both a and b
+func Overlaps(a, b string) bool {
+	aTokens := strings.Split(a, separator)
+	bTokens := strings.Split(b, separator)
+
+	for i := 0; i < len(aTokens) && i < len(bTokens); i++ {
+		if isTail(aTokens, i) || isTail(bTokens, i) {
+			return true
+		}
+		if aTokens[i] != singleWildcard && bTokens[i] != singleWildcard && aTokens[i] != bTokens[i] {
+			return false
+		}
+	}
+
+	return len(aTokens) == len(bTokens)
+}
+
+func isTail(tokens []string, i int) bool {
+	return tokens[i] == tailWildcard && i == len(tokens)-1
+}
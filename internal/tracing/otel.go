@@ -9,7 +9,7 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 )
@@ -18,26 +18,31 @@ const (
 	shutdownTimeout = time.Second * 4
 )
 
-func NewTracerProvider(config Config) (tp trace.TracerProvider, shutdown func()) {
+func NewTracerProvider(config Config, logger *slog.Logger) (tp trace.TracerProvider, shutdown func()) {
 	if !config.Enabled {
 		return trace.NewNoopTracerProvider(), func() {}
 	}
 
+	fatal := func(msg string, err error) {
+		logger.Error(msg, slog.Any("error", err))
+		os.Exit(1)
+	}
+
 	var exporter traceSdk.SpanExporter
 	if config.UseJaeger {
 		exp, err := newJaegerExporter(config)
 		if err != nil {
-			log.Fatal(err)
+			fatal("could not create jaeger exporter", err)
 		}
 		exporter = exp
 	} else {
 		file, err := os.Create(config.OutputFile)
 		if err != nil {
-			log.Fatal(err)
+			fatal("could not create traces output file", err)
 		}
 		exporter, err = newStdoutExporter(file)
 		if err != nil {
-			log.Fatal(err)
+			fatal("could not create stdout exporter", err)
 		}
 	}
 
@@ -51,7 +56,7 @@ func NewTracerProvider(config Config) (tp trace.TracerProvider, shutdown func())
 		defer cancel()
 		tpp.ForceFlush(ctx)
 		if err := tpp.Shutdown(ctx); err != nil {
-			log.Fatal(err)
+			logger.Error("could not shutdown tracer provider", slog.Any("error", err))
 		}
 	}
 
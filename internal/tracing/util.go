@@ -10,6 +10,7 @@ const (
 	subjectKey    = "subject"
 	idKey         = "id"
 	assignedIdKey = "assignedId"
+	keyKey        = "key"
 )
 
 func SetStatusAndError(span trace.Span, err error) {
@@ -30,3 +31,7 @@ func MessageId(id int) attribute.KeyValue {
 func MessageAssignedId(id int) attribute.KeyValue {
 	return attribute.Int(assignedIdKey, id)
 }
+
+func MessageKey(key string) attribute.KeyValue {
+	return attribute.String(keyKey, key)
+}
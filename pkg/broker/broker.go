@@ -17,6 +17,24 @@ type Message struct {
 	// with the proper Message id
 	// 0 when there is no need to keep message ( fire & forget mode )
 	Expiration time.Duration
+	// This parameter is optional. If it's in the future, subscribers
+	// get the Message at this time instead of right after Publish();
+	// the Message gets its id and is fetchable right away
+	DeliverAt time.Time
+	// Headers are optional key-value metadata delivered with the Message
+	Headers map[string]string
+	// This parameter is optional. Key identifies the state the Message
+	// carries; the latest Message of a key can be fetched by the key,
+	// and compacted subjects only retain the latest Message of every key
+	Key string
+	// This parameter is optional. Messages with a higher Priority are
+	// delivered first to the subscribers lagging behind; Priority does
+	// not change the ids, nor is it kept by the persistent stores
+	Priority int
+	// SchemaVersion is the version of the schema of the subject the Body
+	// is validated against; 0 on Publish() means the latest version,
+	// and on a subject without a schema
+	SchemaVersion int
 }
 
 // The whole implementation should be thread-safe
@@ -26,7 +44,11 @@ type Broker interface {
 	// Publish returns an int as the id of message published.
 	// It should preserve the order. So if we are publishing messages
 	// A, B and C, all subscribers should get these messages as
-	// A, B and C.
+	// A, B and C. Concurrent publishes on a subject are delivered
+	// in the order of their ids; delayed messages are delivered
+	// in the order of their DeliverAt instead. The messages of a higher
+	// Priority may overtake the ones buffered for a subscriber that
+	// lags behind; the order holds among the messages of a Priority.
 	Publish(ctx context.Context, subject string, msg Message) (int, error)
 
 	// Subscribe listens to every publish, and returns the messages to all
@@ -35,7 +57,21 @@ type Broker interface {
 	// to this subscriber. Do nothing on time-out
 	Subscribe(ctx context.Context, subject string) (<-chan Message, error)
 
+	// SubscribeWithFilter is Subscribe, only returning the messages
+	// selected by filter
+	SubscribeWithFilter(ctx context.Context, subject string, filter Filter) (<-chan Message, error)
+
 	// Fetch enables us to retrieve a message that is already published, if
 	// it's not expired yet.
 	Fetch(ctx context.Context, subject string, id int) (Message, error)
+
+	// FetchByKey retrieves the latest message published with key,
+	// if it's not expired yet.
+	FetchByKey(ctx context.Context, subject string, key string) (Message, error)
+
+	// Request publishes msg with a HeaderReplyTo header naming a new
+	// inbox subject, and returns the first message published to it.
+	// If the context is done before a reply, it returns ErrNoReply
+	// on a deadline and the context error otherwise
+	Request(ctx context.Context, subject string, msg Message) (Message, error)
 }
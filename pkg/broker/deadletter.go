@@ -0,0 +1,14 @@
+package broker
+
+// Headers set on the messages published to a dead-letter subject,
+// describing why and from where they were dead-lettered
+const (
+	HeaderDeadLetterReason = "dead-letter-reason"
+	HeaderOriginalSubject  = "original-subject"
+	HeaderOriginalId       = "original-id"
+	HeaderDeadLetteredAt   = "dead-lettered-at"
+
+	// ReasonSubscriberTimeout means a subscriber did not take
+	// the message in time, because its buffer was full
+	ReasonSubscriberTimeout = "SUBSCRIBER_TIMEOUT"
+)
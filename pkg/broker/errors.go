@@ -11,4 +11,28 @@ var (
 	// Use this error when message had been published, but it is not
 	// available anymore because the expiration time has reached.
 	ErrExpiredID = errors.New("message with id provided is expired")
+	// Use this error when no message is published with the provided key
+	ErrKeyNotFound = errors.New("no message is published with the key provided")
+	// Use this error, wrapping the reason, when the body of a published
+	// message does not validate against the schema of its subject
+	ErrSchemaViolation = errors.New("message body does not match the schema of the subject")
+	// Use this error when a message is published with a schema
+	// version that is not registered for its subject
+	ErrUnknownSchemaVersion = errors.New("schema version provided is not registered for the subject")
+	// Use this error when no reply to a request is published
+	// before its context is done
+	ErrNoReply = errors.New("no reply is published for the request")
+	// Use this error, wrapping the reason, when a subscription
+	// filter does not compile
+	ErrInvalidFilter = errors.New("subscription filter provided is not valid")
+)
+
+// Reasons attached as error info details to the grpc statuses,
+// so that clients can tell the errors apart without parsing messages
+const (
+	ErrorDomain       = "go-broker"
+	ReasonInvalidID   = "INVALID_ID"
+	ReasonExpiredID   = "EXPIRED_ID"
+	ReasonKeyNotFound = "KEY_NOT_FOUND"
+	ReasonNoReply     = "NO_REPLY"
 )
@@ -0,0 +1,22 @@
+package broker
+
+// Filter selects the messages delivered to a subscriber;
+// the zero Filter selects every message
+type Filter struct {
+	// Headers must all be set on a message, with the same values
+	Headers map[string]string
+	// BodyPrefix must prefix the body of a message
+	BodyPrefix string
+	// Expression is a small CEL-like boolean expression a message must
+	// satisfy. It can compare body, key, id, schema_version and
+	// headers["name"], call startsWith, endsWith and contains on
+	// strings, test "name" in headers, and combine them with !, && and ||:
+	//
+	//	headers["type"] == "created" && (id > 100 || body.startsWith("{"))
+	Expression string
+}
+
+// IsZero reports whether f selects every message
+func (f Filter) IsZero() bool {
+	return len(f.Headers) == 0 && f.BodyPrefix == "" && f.Expression == ""
+}
@@ -0,0 +1,10 @@
+package broker
+
+// HeaderReplyTo is set on the messages published by Request(),
+// naming the subject their reply should be published to
+const HeaderReplyTo = "reply-to"
+
+// InboxPrefix prefixes the subjects Request() waits for its reply on;
+// messages published to them are only delivered to their current
+// subscribers, and are neither stored nor given an id
+const InboxPrefix = "_INBOX."
@@ -0,0 +1,536 @@
+package client
+
+import (
+	"context"
+	"errors"
+	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/compression"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	subscribeChannelBuffer = 72
+)
+
+// Client is a broker.Broker backed by a remote go-broker server
+type Client interface {
+	broker.Broker
+	// Subjects returns the subjects that have messages or subscribers
+	// on the server, as listed by its Admin service
+	Subjects(ctx context.Context) ([]string, error)
+	// Reply publishes reply to the subject named by the HeaderReplyTo
+	// header of request, as received by a subscriber; it returns
+	// ErrNoReplyTo if request was not published by Request()
+	Reply(ctx context.Context, request broker.Message, reply broker.Message) (int, error)
+	// SubscribeWithCredits is Subscribe for consumers with bounded memory;
+	// the server sends at most window messages ahead of the ones taken
+	// from the returned channel
+	SubscribeWithCredits(ctx context.Context, subject string, filter broker.Filter, window int) (<-chan broker.Message, error)
+}
+
+type client struct {
+	config  Config
+	conns   []*grpc.ClientConn
+	brokers []pb.BrokerClient
+	admins  []pb.AdminClient
+	next    atomic.Uint32
+	closed  atomic.Bool
+	// ctx is cancelled on Close to stop all the subscriptions
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New dials config.Connections connections to config.Host.
+// Insecure transport credentials are used unless tls is enabled
+// in config or opts provide others.
+func New(config Config, opts ...grpc.DialOption) (Client, error) {
+	if config.Connections <= 0 {
+		config.Connections = 1
+	}
+
+	creds := insecure.NewCredentials()
+	if config.TLS.Enabled {
+		var err error
+		creds, err = NewTLSCredentials(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if config.Token != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(bearerToken{
+			token:      config.Token,
+			requireTLS: config.TLS.Enabled,
+		}))
+	}
+	if config.Namespace != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(namespaceHeader(config.Namespace)))
+	}
+	if config.Compression != "" {
+		if _, err := compression.Get(config.Compression); err != nil {
+			return nil, err
+		}
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(grpc.UseCompressor(config.Compression)))
+	}
+	dialOptions = append(dialOptions, opts...)
+
+	c := &client{
+		config:  config,
+		conns:   make([]*grpc.ClientConn, 0, config.Connections),
+		brokers: make([]pb.BrokerClient, 0, config.Connections),
+		admins:  make([]pb.AdminClient, 0, config.Connections),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	for i := 0; i < config.Connections; i++ {
+		conn, err := grpc.Dial(config.Host, dialOptions...)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		c.conns = append(c.conns, conn)
+		c.brokers = append(c.brokers, pb.NewBrokerClient(conn))
+		c.admins = append(c.admins, pb.NewAdminClient(conn))
+	}
+
+	return c, nil
+}
+
+func (c *client) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	c.cancel()
+
+	var err error
+	for _, conn := range c.conns {
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (c *client) Publish(ctx context.Context, subject string, msg broker.Message) (int, error) {
+	request := toPublishRequest(subject, msg)
+
+	var id int
+	err := c.withRetryUnsent(ctx, func(bc pb.BrokerClient, opt grpc.CallOption) error {
+		res, err := bc.Publish(ctx, request, opt)
+		if err != nil {
+			return err
+		}
+		id = int(res.GetId())
+		return nil
+	})
+
+	return id, toBrokerError(err)
+}
+
+func toPublishRequest(subject string, msg broker.Message) *pb.PublishRequest {
+	request := &pb.PublishRequest{
+		Subject:           subject,
+		Body:              []byte(msg.Body),
+		ExpirationSeconds: int32(msg.Expiration.Seconds()),
+		Headers:           msg.Headers,
+		Key:               msg.Key,
+		SchemaVersion:     int32(msg.SchemaVersion),
+		Priority:          int32(msg.Priority),
+	}
+	if !msg.DeliverAt.IsZero() {
+		request.DeliverAtUnixMillis = msg.DeliverAt.UnixMilli()
+	}
+
+	return request
+}
+
+// Request waits for the reply until the deadline of ctx,
+// or the default timeout of the server if ctx has none
+func (c *client) Request(ctx context.Context, subject string, msg broker.Message) (broker.Message, error) {
+	request := &pb.RequestRequest{
+		Message: toPublishRequest(subject, msg),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		request.TimeoutMillis = int32(time.Until(deadline).Milliseconds())
+		if request.TimeoutMillis <= 0 {
+			return broker.Message{}, broker.ErrNoReply
+		}
+	}
+
+	var reply broker.Message
+	err := c.withRetryUnsent(ctx, func(bc pb.BrokerClient, opt grpc.CallOption) error {
+		res, err := bc.Request(ctx, request, opt)
+		if err != nil {
+			return err
+		}
+		reply = toBrokerMessage(res)
+		return nil
+	})
+	// the deadline of ctx may pass before the server gives up on the reply
+	if status.Code(err) == codes.DeadlineExceeded || err == context.DeadlineExceeded {
+		return reply, broker.ErrNoReply
+	}
+
+	return reply, toBrokerError(err)
+}
+
+func (c *client) Reply(ctx context.Context, request broker.Message, reply broker.Message) (int, error) {
+	replyTo, ok := request.Headers[broker.HeaderReplyTo]
+	if !ok || replyTo == "" {
+		return 0, ErrNoReplyTo
+	}
+
+	return c.Publish(ctx, replyTo, reply)
+}
+
+func (c *client) Subscribe(ctx context.Context, subject string) (<-chan broker.Message, error) {
+	return c.SubscribeWithFilter(ctx, subject, broker.Filter{})
+}
+
+func (c *client) SubscribeWithFilter(ctx context.Context, subject string, filter broker.Filter) (<-chan broker.Message, error) {
+	if c.closed.Load() {
+		return nil, broker.ErrUnavailable
+	}
+
+	request := toSubscribeRequest(subject, filter)
+
+	var stream pb.Broker_SubscribeClient
+	err := c.withRetry(ctx, func(bc pb.BrokerClient) error {
+		var err error
+		stream, err = bc.Subscribe(ctx, request)
+		return err
+	})
+	if err != nil {
+		return nil, toBrokerError(err)
+	}
+
+	ch := make(chan broker.Message, subscribeChannelBuffer)
+	go c.receive(ctx, request, stream, ch)
+
+	return ch, nil
+}
+
+// receive pumps the messages of stream into ch and opens a new stream
+// whenever the current one breaks with a resubscribable error.
+// ch is closed when ctx is done, the client is closed, or the
+// stream fails permanently.
+func (c *client) receive(ctx context.Context, request *pb.SubscribeRequest, stream pb.Broker_SubscribeClient, ch chan<- broker.Message) {
+	defer close(ch)
+
+	attempt := 0
+	for {
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				if err == io.EOF || !isResubscribable(err) {
+					return
+				}
+				break
+			}
+			attempt = 0
+
+			select {
+			case ch <- toBrokerMessage(res):
+			case <-ctx.Done():
+				return
+			case <-c.ctx.Done():
+				return
+			}
+		}
+
+		ok := c.reopen(ctx, &attempt, func(bc pb.BrokerClient) error {
+			var err error
+			stream, err = bc.Subscribe(ctx, request)
+			return err
+		})
+		if !ok {
+			return
+		}
+	}
+}
+
+func (c *client) SubscribeWithCredits(ctx context.Context, subject string, filter broker.Filter, window int) (<-chan broker.Message, error) {
+	if window <= 0 {
+		return nil, errors.New("window must be positive")
+	}
+	if c.closed.Load() {
+		return nil, broker.ErrUnavailable
+	}
+
+	open := func(bc pb.BrokerClient) (pb.Broker_SubscribeWithCreditsClient, error) {
+		stream, err := bc.SubscribeWithCredits(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = stream.Send(&pb.SubscribeWithCreditsRequest{
+			Request: &pb.SubscribeWithCreditsRequest_Subscribe{Subscribe: toSubscribeRequest(subject, filter)},
+		})
+		if err == nil {
+			err = stream.Send(creditsRequest(window))
+		}
+		// the headers are sent once the subscription is established,
+		// and carry the status otherwise
+		if _, headerErr := stream.Header(); headerErr != nil {
+			err = headerErr
+		}
+		return stream, err
+	}
+
+	var stream pb.Broker_SubscribeWithCreditsClient
+	err := c.withRetry(ctx, func(bc pb.BrokerClient) error {
+		var err error
+		stream, err = open(bc)
+		return err
+	})
+	if err != nil {
+		return nil, toBrokerError(err)
+	}
+
+	// ch is not buffered, so that the messages not taken by the consumer are
+	// the ones the server holds back, and not ones piling up in the client
+	ch := make(chan broker.Message)
+	go c.receiveWithCredits(ctx, open, stream, window, ch)
+
+	return ch, nil
+}
+
+// receiveWithCredits is receive for the streams of SubscribeWithCredits;
+// credits are granted back in batches of half the window to save round trips
+func (c *client) receiveWithCredits(ctx context.Context, open func(pb.BrokerClient) (pb.Broker_SubscribeWithCreditsClient, error), stream pb.Broker_SubscribeWithCreditsClient, window int, ch chan<- broker.Message) {
+	defer close(ch)
+
+	batch := (window + 1) / 2
+	attempt := 0
+	for {
+		taken := 0
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				if err == io.EOF || !isResubscribable(err) {
+					return
+				}
+				break
+			}
+			attempt = 0
+
+			select {
+			case ch <- toBrokerMessage(res):
+			case <-ctx.Done():
+				return
+			case <-c.ctx.Done():
+				return
+			}
+
+			taken++
+			if taken >= batch {
+				// a broken stream is detected by the next Recv
+				_ = stream.Send(creditsRequest(taken))
+				taken = 0
+			}
+		}
+
+		ok := c.reopen(ctx, &attempt, func(bc pb.BrokerClient) error {
+			var err error
+			stream, err = open(bc)
+			return err
+		})
+		if !ok {
+			return
+		}
+	}
+}
+
+func toSubscribeRequest(subject string, filter broker.Filter) *pb.SubscribeRequest {
+	request := &pb.SubscribeRequest{
+		Subject: subject,
+	}
+	if !filter.IsZero() {
+		request.Filter = &pb.Filter{
+			Headers:    filter.Headers,
+			BodyPrefix: []byte(filter.BodyPrefix),
+			Expression: filter.Expression,
+		}
+	}
+
+	return request
+}
+
+func creditsRequest(credits int) *pb.SubscribeWithCreditsRequest {
+	return &pb.SubscribeWithCreditsRequest{
+		Request: &pb.SubscribeWithCreditsRequest_Credits{Credits: int32(credits)},
+	}
+}
+
+// reopen calls open with backoff until it opens a new stream, and reports
+// false if it fails permanently, ctx is done or the client is closed
+func (c *client) reopen(ctx context.Context, attempt *int, open func(pb.BrokerClient) error) bool {
+	for {
+		if !c.sleep(ctx, c.backoff(*attempt)) {
+			return false
+		}
+		*attempt++
+
+		err := open(c.pick())
+		if err == nil {
+			return true
+		}
+		if !isResubscribable(err) {
+			return false
+		}
+	}
+}
+
+func (c *client) Fetch(ctx context.Context, subject string, id int) (broker.Message, error) {
+	return c.fetch(ctx, &pb.FetchRequest{
+		Subject: subject,
+		Id:      int32(id),
+	})
+}
+
+func (c *client) FetchByKey(ctx context.Context, subject string, key string) (broker.Message, error) {
+	return c.fetch(ctx, &pb.FetchRequest{
+		Subject: subject,
+		Key:     key,
+	})
+}
+
+func (c *client) fetch(ctx context.Context, request *pb.FetchRequest) (broker.Message, error) {
+	var msg broker.Message
+	err := c.withRetry(ctx, func(bc pb.BrokerClient) error {
+		res, err := bc.Fetch(ctx, request)
+		if err != nil {
+			return err
+		}
+		msg = toBrokerMessage(res)
+		return nil
+	})
+
+	return msg, toBrokerError(err)
+}
+
+func toBrokerMessage(res *pb.MessageResponse) broker.Message {
+	return broker.Message{
+		Id:            int(res.GetId()),
+		Body:          string(res.GetBody()),
+		Headers:       res.GetHeaders(),
+		Key:           res.GetKey(),
+		SchemaVersion: int(res.GetSchemaVersion()),
+	}
+}
+
+func (c *client) Subjects(ctx context.Context) ([]string, error) {
+	var subjects []string
+	err := c.retry(ctx, func(i int) error {
+		res, err := c.admins[i].ListSubjects(ctx, &pb.ListSubjectsRequest{})
+		if err != nil {
+			return err
+		}
+		subjects = res.GetSubjects()
+		return nil
+	})
+
+	return subjects, toBrokerError(err)
+}
+
+// pick returns the next connection of the pool in a round-robin fashion
+func (c *client) pick() pb.BrokerClient {
+	return c.brokers[c.nextIndex()]
+}
+
+func (c *client) nextIndex() int {
+	n := c.next.Add(1)
+	return int(n) % len(c.conns)
+}
+
+// withRetry calls f with a connection from the pool, and calls it again
+// with backoff as long as it fails with a retryable error
+func (c *client) withRetry(ctx context.Context, f func(pb.BrokerClient) error) error {
+	return c.retry(ctx, func(i int) error {
+		return f(c.brokers[i])
+	})
+}
+
+// withRetryUnsent is withRetry for calls that are not idempotent, which
+// are only retried if they provably never left the client; grpc only
+// assigns a peer to a call once it's sent on a connection
+func (c *client) withRetryUnsent(ctx context.Context, f func(pb.BrokerClient, grpc.CallOption) error) error {
+	err := c.retry(ctx, func(i int) error {
+		var p peer.Peer
+		err := f(c.brokers[i], grpc.Peer(&p))
+		if err != nil && p.Addr != nil {
+			return &sentError{err: err}
+		}
+		return err
+	})
+
+	var sent *sentError
+	if errors.As(err, &sent) {
+		return sent.err
+	}
+	return err
+}
+
+// retry is withRetry for calls needing the index of the connection
+func (c *client) retry(ctx context.Context, f func(int) error) error {
+	if c.closed.Load() {
+		return broker.ErrUnavailable
+	}
+
+	var err error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 && !c.sleep(ctx, c.backoff(attempt-1)) {
+			break
+		}
+
+		err = f(c.nextIndex())
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// backoff returns an exponential delay with jitter for the given attempt
+func (c *client) backoff(attempt int) time.Duration {
+	d := c.config.InitialBackoff
+	for i := 0; i < attempt && d < c.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.config.MaxBackoff {
+		d = c.config.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for d and reports false if ctx is done
+// or the client is closed in the meantime
+func (c *client) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-c.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	pb "github.com/MeysamBavi/go-broker/api/proto"
+	"github.com/MeysamBavi/go-broker/api/server"
+	"github.com/MeysamBavi/go-broker/internal/auth"
+	internalBroker "github.com/MeysamBavi/go-broker/internal/broker"
+	"github.com/MeysamBavi/go-broker/internal/logging"
+	"github.com/MeysamBavi/go-broker/internal/ratelimit"
+	"github.com/MeysamBavi/go-broker/internal/store"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"github.com/MeysamBavi/go-broker/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var mainCtx = context.Background()
+
+func newTestClient(t *testing.T, module broker.Broker) Client {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	pb.RegisterBrokerServer(s, server.NewServer(module, metrics.NewEmptyHandler(), store.GetDefaultTimeProvider(), logging.NewNopLogger(), auth.AllowAll(), ratelimit.NoLimit(), server.ValidationConfig{}, nil))
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	t.Cleanup(s.Stop)
+
+	cfg := DefaultConfig()
+	cfg.Host = "bufnet"
+	cfg.Connections = 2
+	cfg.MaxRetries = 2
+	cfg.InitialBackoff = time.Millisecond
+	cfg.MaxBackoff = 5 * time.Millisecond
+
+	c, err := New(cfg, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+
+	return c
+}
+
+func TestPublishAndFetch(t *testing.T) {
+	c := newTestClient(t, internalBroker.NewModule())
+
+	headers := map[string]string{"content-type": "text/plain"}
+	id, err := c.Publish(mainCtx, "ali", broker.Message{Body: "hello", Expiration: time.Minute, Headers: headers})
+	assert.Nil(t, err)
+
+	msg, err := c.Fetch(mainCtx, "ali", id)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", msg.Body)
+	assert.Equal(t, id, msg.Id)
+	assert.Equal(t, headers, msg.Headers)
+}
+
+func TestFetchNeverPublishedShouldReturnInvalidID(t *testing.T) {
+	c := newTestClient(t, internalBroker.NewModule())
+
+	_, err := c.Fetch(mainCtx, "ali", 42)
+	assert.Equal(t, broker.ErrInvalidID, err)
+}
+
+func TestFetchExpiredShouldReturnExpiredID(t *testing.T) {
+	c := newTestClient(t, internalBroker.NewModule())
+
+	id, err := c.Publish(mainCtx, "ali", broker.Message{Body: "hello"})
+	assert.Nil(t, err)
+
+	_, err = c.Fetch(mainCtx, "ali", id)
+	assert.Equal(t, broker.ErrExpiredID, err)
+}
+
+func TestSubscribeShouldReceivePublishedMessages(t *testing.T) {
+	c := newTestClient(t, internalBroker.NewModule())
+	ctx, cancel := context.WithCancel(mainCtx)
+	defer cancel()
+
+	sub, err := c.Subscribe(ctx, "ali")
+	assert.Nil(t, err)
+
+	// the subscription is registered asynchronously on the server
+	assert.Eventually(t, func() bool {
+		_, err := c.Publish(mainCtx, "ali", broker.Message{Body: "hello"})
+		assert.Nil(t, err)
+		select {
+		case msg := <-sub:
+			return msg.Body == "hello"
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, time.Second, 20*time.Millisecond)
+
+	cancel()
+	for range sub {
+	}
+}
+
+func TestClosedServerShouldReturnUnavailable(t *testing.T) {
+	module := internalBroker.NewModule()
+	c := newTestClient(t, module)
+	_ = module.Close()
+
+	_, err := c.Publish(mainCtx, "ali", broker.Message{Body: "hello"})
+	assert.Equal(t, broker.ErrUnavailable, err)
+}
+
+func TestClosedClientShouldReturnUnavailable(t *testing.T) {
+	c := newTestClient(t, internalBroker.NewModule())
+	_ = c.Close()
+
+	_, err := c.Publish(mainCtx, "ali", broker.Message{Body: "hello"})
+	assert.Equal(t, broker.ErrUnavailable, err)
+
+	_, err = c.Subscribe(mainCtx, "ali")
+	assert.Equal(t, broker.ErrUnavailable, err)
+}
+
+func TestRequestShouldReturnReply(t *testing.T) {
+	module := internalBroker.NewModule()
+	c := newTestClient(t, module)
+	ctx, cancel := context.WithTimeout(mainCtx, time.Second)
+	defer cancel()
+
+	requests, err := module.Subscribe(ctx, "echo")
+	assert.Nil(t, err)
+	go func() {
+		request := <-requests
+		_, _ = c.Reply(ctx, request, broker.Message{Body: "re: " + request.Body})
+	}()
+
+	reply, err := c.Request(ctx, "echo", broker.Message{Body: "hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, "re: hello", reply.Body)
+}
+
+func TestRequestWithoutReplyShouldReturnNoReply(t *testing.T) {
+	c := newTestClient(t, internalBroker.NewModule())
+	ctx, cancel := context.WithTimeout(mainCtx, 50*time.Millisecond)
+	defer cancel()
+
+	_, err := c.Request(ctx, "echo", broker.Message{Body: "hello"})
+	assert.Equal(t, broker.ErrNoReply, err)
+
+	_, err = c.Reply(mainCtx, broker.Message{Body: "hello"}, broker.Message{Body: "re: hello"})
+	assert.Equal(t, ErrNoReplyTo, err)
+}
+
+func TestSubscribeWithCreditsShouldReceiveMoreThanWindow(t *testing.T) {
+	module := internalBroker.NewModule()
+	c := newTestClient(t, module)
+	ctx, cancel := context.WithTimeout(mainCtx, 5*time.Second)
+	defer cancel()
+
+	sub, err := c.SubscribeWithCredits(ctx, "ali", broker.Filter{}, 2)
+	assert.Nil(t, err)
+
+	n := 10
+	for i := 0; i < n; i++ {
+		_, err := module.Publish(ctx, "ali", broker.Message{Body: "hello"})
+		assert.Nil(t, err)
+	}
+	for id := 1; id <= n; id++ {
+		msg := <-sub
+		assert.Equal(t, id, msg.Id)
+	}
+
+	_, err = c.SubscribeWithCredits(ctx, "ali", broker.Filter{}, 0)
+	assert.NotNil(t, err)
+}
+
+// unavailableBroker fails every publish as if the server was shutting down
+type unavailableBroker struct {
+	broker.Broker
+	publishes atomic.Int32
+}
+
+func (u *unavailableBroker) Publish(context.Context, string, broker.Message) (int, error) {
+	u.publishes.Add(1)
+	return 0, broker.ErrUnavailable
+}
+
+func TestPublishReachingServerShouldNotBeRetried(t *testing.T) {
+	module := &unavailableBroker{Broker: internalBroker.NewModule()}
+	c := newTestClient(t, module)
+
+	_, err := c.Publish(mainCtx, "ali", broker.Message{Body: "hello"})
+	assert.Equal(t, broker.ErrUnavailable, err)
+	assert.Equal(t, int32(1), module.publishes.Load())
+}
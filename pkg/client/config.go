@@ -0,0 +1,35 @@
+package client
+
+import "time"
+
+type Config struct {
+	Host string `config:"host"`
+	// Connections is the number of grpc connections in the pool;
+	// calls are spread over them in a round-robin fashion
+	Connections int `config:"connections"`
+	// MaxRetries is the number of times a call is retried
+	// after an Unavailable response, 0 disables retries; publishes
+	// and requests are only retried if they never left the client
+	MaxRetries     int           `config:"max_retries"`
+	InitialBackoff time.Duration `config:"initial_backoff"`
+	MaxBackoff     time.Duration `config:"max_backoff"`
+	TLS            TLSConfig     `config:"tls"`
+	// Token is sent as a bearer token with every call, if provided
+	Token string `config:"token"`
+	// Namespace is the namespace every call is made in; if empty, the
+	// namespace the token is bound to is used, or the default one
+	Namespace string `config:"namespace"`
+	// Compression is the algorithm compressing the calls on the wire;
+	// one of gzip, snappy and zstd, or empty for none
+	Compression string `config:"compression"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Host:           "localhost:50043",
+		Connections:    1,
+		MaxRetries:     3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"github.com/MeysamBavi/go-broker/pkg/broker"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoReplyTo is returned by Reply for a message that is
+// not a request, as it has no subject to reply to
+var ErrNoReplyTo = errors.New("message has no reply-to header")
+
+// toBrokerError maps the status returned by the server back to
+// the errors defined in pkg/broker, so that callers can compare
+// errors the same way they would with an in-process broker.Broker
+func toBrokerError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	if st.Code() == codes.Unavailable {
+		return broker.ErrUnavailable
+	}
+
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.GetDomain() != broker.ErrorDomain {
+			continue
+		}
+		switch info.GetReason() {
+		case broker.ReasonInvalidID:
+			return broker.ErrInvalidID
+		case broker.ReasonExpiredID:
+			return broker.ErrExpiredID
+		case broker.ReasonKeyNotFound:
+			return broker.ErrKeyNotFound
+		case broker.ReasonNoReply:
+			return broker.ErrNoReply
+		}
+	}
+
+	return err
+}
+
+func isRetryable(err error) bool {
+	var sent *sentError
+	if errors.As(err, &sent) {
+		return false
+	}
+	return status.Code(err) == codes.Unavailable
+}
+
+// sentError is the error of a call that is not idempotent
+// and may have reached the server, so it's never retried
+type sentError struct {
+	err error
+}
+
+func (e *sentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sentError) Unwrap() error {
+	return e.err
+}
+
+// isResubscribable reports whether a broken subscription stream
+// is worth opening again
+func isResubscribable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.Aborted:
+		return true
+	}
+	return false
+}
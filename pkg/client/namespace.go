@@ -0,0 +1,16 @@
+package client
+
+import "context"
+
+// namespaceHeader asks for the namespace every call is made in
+type namespaceHeader string
+
+func (n namespaceHeader) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{
+		"namespace": string(n),
+	}, nil
+}
+
+func (n namespaceHeader) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"google.golang.org/grpc/credentials"
+	"os"
+)
+
+type TLSConfig struct {
+	Enabled bool `config:"enabled"`
+	// CAFile is used to verify the server certificate;
+	// the system roots are used if it's empty
+	CAFile string `config:"ca_file"`
+	// CertFile and KeyFile are presented to servers requiring mutual tls
+	CertFile   string `config:"cert_file"`
+	KeyFile    string `config:"key_file"`
+	ServerName string `config:"server_name"`
+}
+
+// NewTLSCredentials builds the transport credentials described by config
+func NewTLSCredentials(config TLSConfig) (credentials.TransportCredentials, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.ServerName,
+	}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in %s", config.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load key pair: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(cfg), nil
+}
@@ -0,0 +1,19 @@
+package client
+
+import "context"
+
+// bearerToken attaches an "authorization: Bearer <token>" header to calls
+type bearerToken struct {
+	token      string
+	requireTLS bool
+}
+
+func (b bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + b.token,
+	}, nil
+}
+
+func (b bearerToken) RequireTransportSecurity() bool {
+	return b.requireTLS
+}
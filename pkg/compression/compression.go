@@ -0,0 +1,122 @@
+// Package compression provides the codecs used to compress message
+// bodies in the stores, and registers them as grpc compressors
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sort"
+)
+
+// Names of the supported algorithms, as used in config and
+// in the grpc-encoding header
+const (
+	Gzip   = "gzip"
+	Snappy = "snappy"
+	Zstd   = "zstd"
+)
+
+// Codec compresses whole payloads. Implementations are safe for concurrent use.
+type Codec interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var codecs = map[string]Codec{
+	Gzip:   gzipCodec{},
+	Snappy: snappyCodec{},
+	Zstd:   newZstdCodec(),
+}
+
+// Get returns the codec of the algorithm name
+func Get(name string) (Codec, error) {
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression algorithm %q, expected one of %v", name, Names())
+	}
+	return codec, nil
+}
+
+// Names returns the names of the supported algorithms
+func Names() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return Gzip
+}
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string {
+	return Snappy
+}
+
+func (snappyCodec) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCodec) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCodec() zstdCodec {
+	// with no writer or reader, EncodeAll and DecodeAll
+	// can be called concurrently and never fail here
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil)
+	return zstdCodec{
+		encoder: encoder,
+		decoder: decoder,
+	}
+}
+
+func (zstdCodec) Name() string {
+	return Zstd
+}
+
+func (c zstdCodec) Compress(data []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c zstdCodec) Decompress(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
@@ -0,0 +1,139 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/encoding"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// payload returns a json body of about size bytes, resembling a typical message
+func payload(size int) []byte {
+	r := rand.New(rand.NewSource(int64(size)))
+	type event struct {
+		Id      int    `json:"id"`
+		User    string `json:"user"`
+		Action  string `json:"action"`
+		Amount  int    `json:"amount"`
+		Comment string `json:"comment"`
+	}
+	actions := []string{"login", "logout", "purchase", "refund"}
+
+	var events []event
+	for {
+		data, _ := json.Marshal(events)
+		if len(data) >= size {
+			return data[:size]
+		}
+		events = append(events, event{
+			Id:      len(events),
+			User:    fmt.Sprintf("user-%d", r.Intn(100)),
+			Action:  actions[r.Intn(len(actions))],
+			Amount:  r.Intn(100000),
+			Comment: fmt.Sprintf("%x", r.Int63()),
+		})
+	}
+}
+
+func TestCodecsShouldRoundTrip(t *testing.T) {
+	data := payload(4096)
+	for _, name := range Names() {
+		codec, err := Get(name)
+		assert.Nil(t, err)
+
+		compressed, err := codec.Compress(data)
+		assert.Nil(t, err, name)
+		assert.Less(t, len(compressed), len(data), name)
+
+		decompressed, err := codec.Decompress(compressed)
+		assert.Nil(t, err, name)
+		assert.Equal(t, data, decompressed, name)
+	}
+}
+
+func TestGrpcCompressorsShouldBeRegistered(t *testing.T) {
+	data := payload(4096)
+	for _, name := range Names() {
+		compressor := encoding.GetCompressor(name)
+		if !assert.NotNil(t, compressor, name) {
+			continue
+		}
+
+		var buf bytes.Buffer
+		w, err := compressor.Compress(&buf)
+		assert.Nil(t, err, name)
+		_, err = w.Write(data)
+		assert.Nil(t, err, name)
+		assert.Nil(t, w.Close(), name)
+
+		r, err := compressor.Decompress(&buf)
+		assert.Nil(t, err, name)
+		decompressed, err := io.ReadAll(r)
+		assert.Nil(t, err, name)
+		assert.Equal(t, data, decompressed, name)
+	}
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestGrpcDecompressionShouldBeBounded(t *testing.T) {
+	for _, name := range []string{Snappy, Zstd} {
+		compressor := encoding.GetCompressor(name)
+
+		var buf bytes.Buffer
+		w, err := compressor.Compress(&buf)
+		assert.Nil(t, err, name)
+		_, err = io.CopyN(w, zeros{}, MaxDecodedSize+1)
+		assert.Nil(t, err, name)
+		assert.Nil(t, w.Close(), name)
+
+		r, err := compressor.Decompress(&buf)
+		assert.Nil(t, err, name)
+		_, err = io.Copy(io.Discard, r)
+		assert.ErrorIs(t, err, ErrTooLarge, name)
+	}
+}
+
+// BenchmarkCompress reports the throughput of every codec, and the
+// size of the compressed payloads relative to the original ones as ratio
+func BenchmarkCompress(b *testing.B) {
+	for _, size := range []int{256, 4096, 65536} {
+		data := payload(size)
+		for _, name := range Names() {
+			codec, _ := Get(name)
+			b.Run(fmt.Sprintf("%s/%d", name, size), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				var compressed []byte
+				for i := 0; i < b.N; i++ {
+					compressed, _ = codec.Compress(data)
+				}
+				b.ReportMetric(float64(len(compressed))/float64(len(data)), "ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, size := range []int{256, 4096, 65536} {
+		data := payload(size)
+		for _, name := range Names() {
+			codec, _ := Get(name)
+			compressed, _ := codec.Compress(data)
+			b.Run(fmt.Sprintf("%s/%d", name, size), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					_, _ = codec.Decompress(compressed)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"io"
+	"sync"
+)
+
+// MaxDecodedSize is the largest grpc message decompressed, whatever the
+// max receive size of the server or client is, so that a small message
+// can never expand into an unbounded amount of memory
+const MaxDecodedSize = 64 << 20
+
+var ErrTooLarge = fmt.Errorf("decompressed message is larger than %d bytes", MaxDecodedSize)
+
+// importing the package registers snappy and zstd as grpc compressors,
+// next to the gzip compressor provided by grpc; servers accept any of
+// them, and clients pick one with grpc.UseCompressor
+func init() {
+	encoding.RegisterCompressor(grpcCompressor{
+		name:      Snappy,
+		newWriter: newSnappyWriter,
+		newReader: newSnappyReader,
+	})
+	encoding.RegisterCompressor(grpcCompressor{
+		name:      Zstd,
+		newWriter: newZstdWriter,
+		newReader: newZstdReader,
+	})
+}
+
+// grpcCompressor adapts a streaming format to encoding.Compressor; messages
+// are decompressed as grpc reads them, so grpc's own limit on the
+// received size stops the decompression instead of applying after it
+type grpcCompressor struct {
+	name      string
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.Reader, error)
+}
+
+func (c grpcCompressor) Name() string {
+	return c.name
+}
+
+func (c grpcCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return c.newWriter(w)
+}
+
+func (c grpcCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	decoded, err := c.newReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReader{r: decoded, left: MaxDecodedSize}, nil
+}
+
+// limitedReader fails with ErrTooLarge, instead of
+// stopping silently like io.LimitReader, past its limit
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+func newSnappyWriter(w io.Writer) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func newSnappyReader(r io.Reader) (io.Reader, error) {
+	return snappy.NewReader(r), nil
+}
+
+// the encoders and decoders allocate large buffers,
+// so they are reused across messages
+var (
+	zstdEncoders sync.Pool
+	zstdDecoders sync.Pool
+)
+
+type zstdWriter struct {
+	*zstd.Encoder
+}
+
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	if encoder, ok := zstdEncoders.Get().(*zstd.Encoder); ok {
+		encoder.Reset(w)
+		return zstdWriter{encoder}, nil
+	}
+
+	encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return zstdWriter{encoder}, nil
+}
+
+func (w zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	zstdEncoders.Put(w.Encoder)
+	return err
+}
+
+// zstdReader returns its decoder to the pool once the message is read;
+// a decoder left behind by grpc stopping early is garbage collected
+type zstdReader struct {
+	decoder *zstd.Decoder
+}
+
+func newZstdReader(r io.Reader) (io.Reader, error) {
+	if decoder, ok := zstdDecoders.Get().(*zstd.Decoder); ok {
+		if err := decoder.Reset(r); err != nil {
+			return nil, err
+		}
+		return &zstdReader{decoder: decoder}, nil
+	}
+
+	// with a concurrency of 1, streams are decoded without
+	// goroutines, so unfinished decoders need no closing
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(MaxDecodedSize))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{decoder: decoder}, nil
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	if z.decoder == nil {
+		return 0, io.EOF
+	}
+
+	n, err := z.decoder.Read(p)
+	if errors.Is(err, io.EOF) {
+		z.decoder.Reset(nil)
+		zstdDecoders.Put(z.decoder)
+		z.decoder = nil
+	}
+	return n, err
+}
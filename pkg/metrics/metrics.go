@@ -2,14 +2,23 @@ package metrics
 
 import "time"
 
+// Handler reports the calls made in every namespace separately
 type Handler interface {
-	IncPublishCallCount(success bool)
-	IncSubscribeCallCount(success bool)
-	IncFetchCallCount(success bool)
-	ReportPublishLatency(value time.Duration)
-	ReportFetchLatency(value time.Duration)
-	IncActiveSubscribers()
-	DecActiveSubscribers()
+	IncPublishCallCount(namespace string, success bool)
+	IncSubscribeCallCount(namespace string, success bool)
+	IncFetchCallCount(namespace string, success bool)
+	IncRequestCallCount(namespace string, success bool)
+	ReportPublishLatency(namespace string, value time.Duration)
+	ReportFetchLatency(namespace string, value time.Duration)
+	// ReportRequestLatency includes the time spent waiting for the reply
+	ReportRequestLatency(namespace string, value time.Duration)
+	IncActiveSubscribers(namespace string)
+	DecActiveSubscribers(namespace string)
+	IncPublishRateLimitedCount(namespace, scope, limit string)
+	IncSubscribeRateLimitedCount(namespace, scope, limit string)
+	// ReportFilterEvaluation reports how long evaluating the filter of
+	// a subscription on a message took, and whether it was selected
+	ReportFilterEvaluation(value time.Duration, matched bool)
 }
 
 type noImpl struct{}
@@ -18,16 +27,26 @@ func NewEmptyHandler() Handler {
 	return noImpl{}
 }
 
-func (n noImpl) IncPublishCallCount(_ bool) {}
+func (n noImpl) IncPublishCallCount(_ string, _ bool) {}
 
-func (n noImpl) IncSubscribeCallCount(_ bool) {}
+func (n noImpl) IncSubscribeCallCount(_ string, _ bool) {}
 
-func (n noImpl) IncFetchCallCount(_ bool) {}
+func (n noImpl) IncFetchCallCount(_ string, _ bool) {}
 
-func (n noImpl) ReportPublishLatency(_ time.Duration) {}
+func (n noImpl) IncRequestCallCount(_ string, _ bool) {}
 
-func (n noImpl) ReportFetchLatency(_ time.Duration) {}
+func (n noImpl) ReportPublishLatency(_ string, _ time.Duration) {}
 
-func (n noImpl) IncActiveSubscribers() {}
+func (n noImpl) ReportFetchLatency(_ string, _ time.Duration) {}
 
-func (n noImpl) DecActiveSubscribers() {}
+func (n noImpl) ReportRequestLatency(_ string, _ time.Duration) {}
+
+func (n noImpl) IncActiveSubscribers(_ string) {}
+
+func (n noImpl) DecActiveSubscribers(_ string) {}
+
+func (n noImpl) IncPublishRateLimitedCount(_, _, _ string) {}
+
+func (n noImpl) IncSubscribeRateLimitedCount(_, _, _ string) {}
+
+func (n noImpl) ReportFilterEvaluation(_ time.Duration, _ bool) {}
@@ -8,17 +8,24 @@ import (
 )
 
 const (
-	publish      = "publish"
-	subscribe    = "subscribe"
-	fetch        = "fetch"
-	successLabel = "success"
-	methodLabel  = "method"
+	publish        = "publish"
+	subscribe      = "subscribe"
+	fetch          = "fetch"
+	request        = "request"
+	successLabel   = "success"
+	methodLabel    = "method"
+	scopeLabel     = "scope"
+	limitLabel     = "limit"
+	matchedLabel   = "matched"
+	namespaceLabel = "namespace"
 )
 
 type prometheusImpl struct {
 	methodCount       *prometheus.CounterVec
 	methodDuration    *prometheus.SummaryVec
 	activeSubscribers *prometheus.GaugeVec
+	rateLimitedCount  *prometheus.CounterVec
+	filterDuration    *prometheus.SummaryVec
 }
 
 func NewPrometheusHandler() Handler {
@@ -26,7 +33,7 @@ func NewPrometheusHandler() Handler {
 		methodCount: promauto.NewCounterVec(prometheus.CounterOpts{
 			Name: "method_count",
 			Help: "number of failed/successful calls for each rpc endpoint",
-		}, []string{successLabel, methodLabel}),
+		}, []string{namespaceLabel, successLabel, methodLabel}),
 		methodDuration: promauto.NewSummaryVec(prometheus.SummaryOpts{
 			Name: "method_duration",
 			Help: "the method latency for each rpc endpoint in nanoseconds",
@@ -35,52 +42,93 @@ func NewPrometheusHandler() Handler {
 				.95: .01,
 				.50: .01,
 			},
-		}, []string{methodLabel}),
+		}, []string{namespaceLabel, methodLabel}),
 		activeSubscribers: promauto.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "active_subscribers",
 			Help: "number of active subscribers",
-		}, []string{}),
+		}, []string{namespaceLabel}),
+		rateLimitedCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limited_count",
+			Help: "number of calls rejected by rate limits and quotas",
+		}, []string{namespaceLabel, methodLabel, scopeLabel, limitLabel}),
+		filterDuration: promauto.NewSummaryVec(prometheus.SummaryOpts{
+			Name: "filter_evaluation_duration",
+			Help: "the time spent evaluating subscription filters on messages in nanoseconds",
+			Objectives: map[float64]float64{
+				.99: .01,
+				.95: .01,
+				.50: .01,
+			},
+		}, []string{matchedLabel}),
 	}
 }
 
-func (p *prometheusImpl) incMethodCount(method string, success bool) {
+func (p *prometheusImpl) incMethodCount(namespace, method string, success bool) {
 	p.methodCount.
-		With(prometheus.Labels{methodLabel: method, successLabel: strconv.FormatBool(success)}).
+		With(prometheus.Labels{namespaceLabel: namespace, methodLabel: method, successLabel: strconv.FormatBool(success)}).
 		Inc()
 }
 
-func (p *prometheusImpl) reportMethodLatency(method string, latency time.Duration) {
+func (p *prometheusImpl) reportMethodLatency(namespace, method string, latency time.Duration) {
 	p.methodDuration.
-		With(prometheus.Labels{methodLabel: method}).
+		With(prometheus.Labels{namespaceLabel: namespace, methodLabel: method}).
 		Observe(float64(latency.Nanoseconds()))
 }
 
-func (p *prometheusImpl) IncPublishCallCount(success bool) {
-	p.incMethodCount(publish, success)
+func (p *prometheusImpl) IncPublishCallCount(namespace string, success bool) {
+	p.incMethodCount(namespace, publish, success)
+}
+
+func (p *prometheusImpl) IncSubscribeCallCount(namespace string, success bool) {
+	p.incMethodCount(namespace, subscribe, success)
+}
+
+func (p *prometheusImpl) IncFetchCallCount(namespace string, success bool) {
+	p.incMethodCount(namespace, fetch, success)
 }
 
-func (p *prometheusImpl) IncSubscribeCallCount(success bool) {
-	p.incMethodCount(subscribe, success)
+func (p *prometheusImpl) IncRequestCallCount(namespace string, success bool) {
+	p.incMethodCount(namespace, request, success)
 }
 
-func (p *prometheusImpl) IncFetchCallCount(success bool) {
-	p.incMethodCount(fetch, success)
+func (p *prometheusImpl) ReportPublishLatency(namespace string, value time.Duration) {
+	p.reportMethodLatency(namespace, publish, value)
 }
 
-func (p *prometheusImpl) ReportPublishLatency(value time.Duration) {
-	p.reportMethodLatency(publish, value)
+func (p *prometheusImpl) ReportFetchLatency(namespace string, value time.Duration) {
+	p.reportMethodLatency(namespace, fetch, value)
 }
 
-func (p *prometheusImpl) ReportFetchLatency(value time.Duration) {
-	p.reportMethodLatency(fetch, value)
+func (p *prometheusImpl) ReportRequestLatency(namespace string, value time.Duration) {
+	p.reportMethodLatency(namespace, request, value)
 }
 
-func (p *prometheusImpl) IncActiveSubscribers() {
+func (p *prometheusImpl) IncActiveSubscribers(namespace string) {
 	p.activeSubscribers.
-		With(prometheus.Labels{}).Inc()
+		With(prometheus.Labels{namespaceLabel: namespace}).Inc()
 }
 
-func (p *prometheusImpl) DecActiveSubscribers() {
+func (p *prometheusImpl) DecActiveSubscribers(namespace string) {
 	p.activeSubscribers.
-		With(prometheus.Labels{}).Dec()
+		With(prometheus.Labels{namespaceLabel: namespace}).Dec()
+}
+
+func (p *prometheusImpl) incRateLimitedCount(namespace, method, scope, limit string) {
+	p.rateLimitedCount.
+		With(prometheus.Labels{namespaceLabel: namespace, methodLabel: method, scopeLabel: scope, limitLabel: limit}).
+		Inc()
+}
+
+func (p *prometheusImpl) IncPublishRateLimitedCount(namespace, scope, limit string) {
+	p.incRateLimitedCount(namespace, publish, scope, limit)
+}
+
+func (p *prometheusImpl) IncSubscribeRateLimitedCount(namespace, scope, limit string) {
+	p.incRateLimitedCount(namespace, subscribe, scope, limit)
+}
+
+func (p *prometheusImpl) ReportFilterEvaluation(value time.Duration, matched bool) {
+	p.filterDuration.
+		With(prometheus.Labels{matchedLabel: strconv.FormatBool(matched)}).
+		Observe(float64(value.Nanoseconds()))
 }
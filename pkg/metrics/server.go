@@ -2,14 +2,28 @@ package metrics
 
 import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
-func RunServer(config Config) {
+// RunServer serves the prometheus metrics on /metrics, along with
+// /healthz which is always ok while the process is up, and /readyz
+// which is ok only when readiness returns nil
+func RunServer(config Config, logger *slog.Logger, readiness func() error) {
 	http.Handle("/metrics", promhttp.Handler())
-	log.Printf("metrics http server listening on port %s\n", config.HttpPort)
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if err := readiness(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	logger.Info("metrics http server listening", slog.String("port", config.HttpPort))
 	if err := http.ListenAndServe(":"+config.HttpPort, nil); err != nil {
-		log.Fatal("could not server metrics: ", err)
+		logger.Error("could not serve metrics", slog.Any("error", err))
 	}
 }